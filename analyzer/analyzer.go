@@ -0,0 +1,249 @@
+// Package analyzer provides an analysis.Analyzer reporting breaking changes to
+// a package's API, compared to a baseline git revision or snapshot, for use
+// with go vet, gopls and multichecker based linters:
+//
+//	multichecker.Main(analyzer.Analyzer)
+//
+// Breaking changes are reported at the changed declaration in the package
+// being analysed, or its package clause if the declaration was removed.
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bradleyfalzon/apicompat"
+	"golang.org/x/tools/go/analysis"
+)
+
+// Analyzer reports breaking API changes compared to a baseline.
+var Analyzer = &analysis.Analyzer{
+	Name: "apicompat",
+	Doc: `report breaking changes to a package's API
+
+The baseline is a git revision, -rev, or a tar archive of the repository,
+-snapshot, such as one created by git archive. A snapshot's root is the
+directory -root.`,
+	Run: run,
+}
+
+var (
+	rev      string // git revision of the baseline
+	snapshot string // tar archive of the baseline, used instead of rev if set
+	root     string // directory the snapshot's root corresponds to
+	vcsName  string // git or native
+)
+
+func init() {
+	Analyzer.Flags.StringVar(&rev, "rev", "HEAD", "git revision to compare against")
+	Analyzer.Flags.StringVar(&snapshot, "snapshot", "", "tar archive of the repository to compare against instead of -rev")
+	Analyzer.Flags.StringVar(&root, "root", ".", "directory the root of -snapshot corresponds to")
+	Analyzer.Flags.StringVar(&vcsName, "vcs", "auto", "version control system to read -rev with, one of: auto, git, native")
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	// Only the package's own files are part of its API
+	var files []*ast.File
+	for _, file := range pass.Files {
+		if !strings.HasSuffix(pass.Fset.File(file.Pos()).Name(), "_test.go") {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 || pass.Pkg.Name() == "main" || strings.HasSuffix(pass.Pkg.Name(), "_test") {
+		return nil, nil
+	}
+	dir := filepath.Dir(pass.Fset.File(files[0].Pos()).Name())
+
+	vcs, baseRev, err := baseline(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	checker := apicompat.New(apicompat.SetVCS(vcs))
+	before, err := checker.LoadPackage(ctx, dir, pass.Pkg.Path(), baseRev)
+	if err != nil {
+		var perr *apicompat.ParseError
+		if errors.As(err, &perr) {
+			// A new package, or one which didn't build, has no API to break
+			return nil, nil
+		}
+		return nil, err
+	}
+	after, err := copyPackage(pass, files)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := checker.ComparePackages(ctx, before, after)
+	if err != nil {
+		return nil, err
+	}
+	positions := declPositions(files)
+	for _, change := range changes {
+		if change.Change != apicompat.Breaking {
+			continue
+		}
+		pos, ok := positions[change.ID]
+		if !ok {
+			pos = files[0].Name.Pos()
+		}
+		pass.Reportf(pos, "breaking API change to %s: %s", change.ID, change.Description())
+	}
+	return nil, nil
+}
+
+// openVCS is a VCS opened by baseline, shared by the packages within its
+// repository or snapshot.
+type openVCS struct {
+	once sync.Once
+	vcs  apicompat.VCS
+	err  error
+}
+
+var (
+	vcsMu sync.Mutex
+	vcses = make(map[vcsKey]*openVCS)
+)
+
+// vcsKey identifies an openVCS.
+type vcsKey struct {
+	name string // git, native or snapshot
+	root string // repository root, or snapshot's root
+	snap string // snapshot's tar archive
+}
+
+// baseline returns the VCS and revision to compare the package in dir
+// against. The VCS is opened once per repository or snapshot, and stays open
+// for later packages.
+func baseline(dir string) (apicompat.VCS, string, error) {
+	key, baseRev := vcsKey{name: "snapshot", root: root, snap: snapshot}, "snapshot"
+	if snapshot == "" {
+		key, baseRev = vcsKey{name: vcsName, root: repoRoot(dir)}, rev
+		if key.name == "auto" {
+			key.name = "native"
+			if _, err := exec.LookPath("git"); err == nil {
+				key.name = "git"
+			}
+		}
+	}
+
+	vcsMu.Lock()
+	open, ok := vcses[key]
+	if !ok {
+		open = new(openVCS)
+		vcses[key] = open
+	}
+	vcsMu.Unlock()
+	open.once.Do(func() {
+		switch key.name {
+		case "snapshot":
+			open.vcs, open.err = apicompat.NewSnapshot(key.snap, key.root)
+		case "git":
+			open.vcs, open.err = apicompat.NewGit(key.root)
+		case "native":
+			open.vcs, open.err = apicompat.NewNativeGit(key.root)
+		default:
+			open.err = fmt.Errorf("unknown vcs %q, must be one of: auto, git, native", key.name)
+		}
+	})
+	return open.vcs, baseRev, open.err
+}
+
+// repoRoot returns the closest directory to dir containing .git, or dir if
+// there is none.
+func repoRoot(dir string) string {
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// copyPackage returns the Package of files, which are copied as comparing
+// modifies them and the pass's files are shared with other analyzers.
+func copyPackage(pass *analysis.Pass, files []*ast.File) (*apicompat.Package, error) {
+	fset := token.NewFileSet()
+	var copies []*ast.File
+	for _, file := range files {
+		var buf bytes.Buffer
+		if err := format.Node(&buf, pass.Fset, file); err != nil {
+			return nil, err
+		}
+		name := pass.Fset.File(file.Pos()).Name()
+		clone, err := parser.ParseFile(fset, name, buf.Bytes(), 0)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, clone)
+	}
+	return apicompat.NewPackage(pass.Pkg.Path(), fset, copies, packageImporter{pass.Pkg})
+}
+
+// packageImporter imports the packages imported by pkg, which have already
+// been type checked.
+type packageImporter struct {
+	pkg *types.Package
+}
+
+// Import implements types.Importer.
+func (p packageImporter) Import(path string) (*types.Package, error) {
+	for _, imp := range p.pkg.Imports() {
+		if imp.Path() == path {
+			return imp, nil
+		}
+	}
+	return nil, fmt.Errorf("package %q not imported by %s", path, p.pkg.Path())
+}
+
+// declPositions returns the position of each declaration by its Change.ID.
+func declPositions(files []*ast.File) map[string]token.Pos {
+	positions := make(map[string]token.Pos)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.ValueSpec:
+						for _, name := range s.Names {
+							positions[name.Name] = name.Pos()
+						}
+					case *ast.TypeSpec:
+						positions[s.Name.Name] = s.Name.Pos()
+					}
+				}
+			case *ast.FuncDecl:
+				id := d.Name.Name
+				if d.Recv != nil && len(d.Recv.List) > 0 {
+					recv := d.Recv.List[0].Type
+					if star, ok := recv.(*ast.StarExpr); ok {
+						recv = star.X
+					}
+					if ident, ok := recv.(*ast.Ident); ok {
+						id = ident.Name + "." + id
+					}
+				}
+				positions[id] = d.Name.Pos()
+			}
+		}
+	}
+	return positions
+}
@@ -0,0 +1,111 @@
+package analyzer
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+	"golang.org/x/tools/go/analysis"
+)
+
+// TestAnalyzer runs the analyzer on uncommitted changes to a package,
+// against the last commit and a snapshot of it.
+func TestAnalyzer(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "an")
+	dir := filepath.Join(repo, "p")
+	file := filepath.Join(dir, "p.go")
+	testenv.GitCommit(t, repo, map[string]string{
+		"p/p.go": "package p\n\nfunc F(a int) {}\n\nfunc G() {}\n\nconst K = 1\n",
+	})
+	snap := filepath.Join(gopath, "snapshot.tar")
+	testenv.Git(t, repo, "archive", "-o", snap, "HEAD")
+
+	// Uncommitted changes, as an editor would analyse
+	src := "package p\n\nfunc F(a, b int) {}\n\nconst K = 1\n\nfunc New() {}\n"
+	testenv.WriteFiles(t, repo, map[string]string{"p/p.go": src})
+	t.Cleanup(func() { snapshot, root = "", "." })
+
+	tests := []struct {
+		name  string
+		flags map[string]string
+	}{
+		{name: "rev", flags: map[string]string{"rev": "HEAD"}},
+		{name: "snapshot", flags: map[string]string{"snapshot": snap, "root": repo}},
+	}
+	for _, test := range tests {
+		for name, value := range test.flags {
+			if err := Analyzer.Flags.Set(name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, file, src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := &types.Info{
+			Types: make(map[ast.Expr]types.TypeAndValue),
+			Defs:  make(map[*ast.Ident]types.Object),
+			Uses:  make(map[*ast.Ident]types.Object),
+		}
+		conf := types.Config{Importer: importer.Default()}
+		pkg, err := conf.Check("example.com/an/p", fset, []*ast.File{f}, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		pass := &analysis.Pass{
+			Analyzer:  Analyzer,
+			Fset:      fset,
+			Files:     []*ast.File{f},
+			Pkg:       pkg,
+			TypesInfo: info,
+			Report: func(d analysis.Diagnostic) {
+				msg := d.Message
+				if i := strings.IndexByte(msg, ':'); i >= 0 {
+					msg = msg[:i]
+				}
+				got = append(got, fset.Position(d.Pos).String()+" "+msg)
+			},
+		}
+		if _, err := Analyzer.Run(pass); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		sort.Strings(got)
+		exp := []string{
+			file + ":1:9 breaking API change to G",
+			file + ":3:6 breaking API change to F",
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s: exp diagnostics %q got %q", test.name, exp, got)
+		}
+	}
+
+	// Packages in the same repository share its VCS
+	snapshot, root = "", "."
+	v1, _, err := baseline(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, _, err := baseline(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1 != v2 {
+		t.Errorf("exp packages in %s to share a VCS", repo)
+	}
+}
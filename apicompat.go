@@ -2,21 +2,26 @@ package apicompat
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"go/ast"
 	"go/build"
-	"go/importer"
+	"go/format"
 	"go/parser"
 	"go/printer"
+	"go/scanner"
 	"go/token"
 	"go/types"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -36,22 +41,35 @@ var (
 	errNotInGOPATH = errors.New("target directory not in $GOPATH")
 )
 
-// Checker is used to check for changes between two versions of a package.
+// Checker is used to check for changes between two versions of a package. A
+// Checker is not modified after New, so multiple goroutines may check with it
+// concurrently. However, relative patterns are resolved using the process's
+// working directory, and $GOPATH is read by each check unless set by
+// SetBuildContext, so neither may change while checks are running.
 type Checker struct {
 	vcs         VCS
 	vlog        io.Writer
-	path        string         // import path
-	recurse     bool           // scan paths recursively
 	excludeFile *regexp.Regexp // exclude files
 	excludeDir  *regexp.Regexp // exclude directory
-
-	b map[string]pkg
-	a map[string]pkg
+	unchanged   bool           // report declarations without changes
+	concurrency int            // maximum packages to parse concurrently
+	deps        bool           // compare dependency types reachable from declarations
+	lenient     bool           // tolerate type check errors
+	corpus      []string       // directories of dependents to count usage in
+	bctx        *build.Context // nil for build.Default with the current $GOPATH
+	logMu       *sync.Mutex    // serialises writes to vlog
+
+	excludeSegments   []string // directory names whose packages aren't checked
+	internalConsumers []string // import paths which may import internal packages
 }
 
+// defaultExcludeSegments are the directory names whose packages aren't checked
+// by default, see SetExcludeSegments.
+var defaultExcludeSegments = []string{"testdata", "vendor"}
+
 // New returns a Checker with the given options.
 func New(options ...func(*Checker)) *Checker {
-	c := &Checker{}
+	c := &Checker{logMu: &sync.Mutex{}, excludeSegments: defaultExcludeSegments}
 	for _, option := range options {
 		option(c)
 	}
@@ -87,79 +105,500 @@ func SetExcludeDir(pattern string) func(*Checker) {
 	}
 }
 
+// SetReportUnchanged is an option to New that also reports declarations that
+// were compared but did not change, their Change is None.
+func SetReportUnchanged(report bool) func(*Checker) {
+	return func(c *Checker) {
+		c.unchanged = report
+	}
+}
+
+// SetConcurrency is an option to New that sets the maximum number of packages
+// parsed and type checked concurrently, if n is less than 1, GOMAXPROCS is used.
+func SetConcurrency(n int) func(*Checker) {
+	return func(c *Checker) {
+		c.concurrency = n
+	}
+}
+
+// SetCheckDependencies is an option to New that also compares the structure
+// of types from external dependencies, outside the standard library and the
+// checked package's module or GOPATH repository, which are reachable from
+// each declaration. This detects changes to a declaration's
+// API caused by changing a dependency's version, such as in go.mod.
+func SetCheckDependencies(check bool) func(*Checker) {
+	return func(c *Checker) {
+		c.deps = check
+	}
+}
+
+// SetBuildContext is an option to New that sets the go/build context used to
+// find packages and select their files, such as its GOPATH, instead of
+// build.Default. Its GOOS and GOARCH are replaced by each platform checked.
+func SetBuildContext(ctx build.Context) func(*Checker) {
+	return func(c *Checker) {
+		c.bctx = &ctx
+	}
+}
+
+// defaultBuild returns the go/build context set by SetBuildContext, or
+// build.Default using the current $GOPATH.
+func (c *Checker) defaultBuild() build.Context {
+	if c.bctx != nil {
+		return *c.bctx
+	}
+	ctx := build.Default
+	ctx.GOPATH = envGOPATH()
+	return ctx
+}
+
+// SetLenient is an option to New that tolerates packages which fail to type
+// check, such as an old revision missing a generated file. Declarations are
+// still compared using the type information available, but changes to such
+// packages, and packages importing them, have LowConfidence set and the errors
+// are reported as diagnostics by CheckReport. Packages which fail to parse or build aren't compared, and
+// are also reported as diagnostics.
+func SetLenient(lenient bool) func(*Checker) {
+	return func(c *Checker) {
+		c.lenient = lenient
+	}
+}
+
+// SetUsageCorpus is an option to New that counts the packages within dirs,
+// such as a module cache or a mirror of dependents, which reference each
+// changed declaration, see Change.Usage. The corpus isn't type checked, so a
+// method's usage counts packages which select any method or field with its
+// name in a file importing the changed package, an upper bound.
+func SetUsageCorpus(dirs ...string) func(*Checker) {
+	return func(c *Checker) {
+		c.corpus = dirs
+	}
+}
+
+// SetExcludeSegments is an option to New that sets the directory names whose
+// packages, and packages in their subdirectories, aren't checked, by default
+// testdata and vendor. Names match whole path segments, so vendor doesn't
+// exclude myvendor.
+func SetExcludeSegments(names ...string) func(*Checker) {
+	return func(c *Checker) {
+		c.excludeSegments = names
+	}
+}
+
+// SetInternalConsumers is an option to New that checks the internal packages
+// which any of paths may import under Go's internal visibility rules, such as
+// packages in a sibling module. A path ending in /... also includes the
+// packages beneath it. By default internal packages aren't checked, as only
+// their own module may import them. A directory outside GOPATH is matched by
+// the import path from its go.mod.
+func SetInternalConsumers(paths ...string) func(*Checker) {
+	return func(c *Checker) {
+		c.internalConsumers = paths
+	}
+}
+
+// CheckRequest describes the packages and revisions to check.
+type CheckRequest struct {
+	// Patterns are the packages to check, each either an import path or a
+	// directory, a pattern ending in /... also checks the packages in all
+	// subdirectories. If empty, the current working directory is checked.
+	Patterns []string
+	// Before and After are the revisions to compare, if either is empty the
+	// VCS's default revision is used.
+	Before, After string
+	// Platforms are the GOOS/GOARCH pairs to check, such as "linux/amd64",
+	// changes found on multiple platforms are only reported once. If empty,
+	// the default build context's platform is checked.
+	Platforms []string
+	// ExcludeFile and ExcludeDir are regexp patterns excluding files and
+	// directories, in addition to the Checker's.
+	ExcludeFile, ExcludeDir string
+}
+
+// check is the state of a single call to Checker.CheckReport.
+type check struct {
+	*Checker
+	ctx          context.Context
+	vcs          VCS             // Checker's VCS bound to ctx
+	build        build.Context   // platform being checked
+	roots        []string        // import paths of the requested packages
+	recurse      map[string]bool // import path -> check subdirectories
+	excludeFiles []*regexp.Regexp
+	excludeDirs  []*regexp.Regexp
+}
+
+// Report is the result of CheckReport.
+type Report struct {
+	Changes     []Change     // Changes found, sorted by ID
+	Diagnostics []Diagnostic // Diagnostics are problems tolerated by lenient mode
+}
+
+// Diagnostic is a problem with a package tolerated by lenient mode.
+type Diagnostic struct {
+	Pkg string         // Pkg is the import path of the package
+	Rev string         // Rev is the revision with the problem
+	Pos token.Position // Pos is the position of the problem if known, without the revision prefix
+	Msg string         // Msg describes the problem
+	Err error          // Err is the underlying error, such as a *TypeCheckError
+}
+
+func (d Diagnostic) String() string {
+	if d.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s at revision %q: %s", d.Pos, d.Pkg, d.Rev, d.Msg)
+	}
+	return fmt.Sprintf("%s at revision %q: %s", d.Pkg, d.Rev, d.Msg)
+}
+
 // Check an import path and before and after revision for changes. Import path
 // maybe empty, if so, the current working directory will be used. If a
 // revision is blank, the default VCS revision is used.
+//
+// Deprecated: use CheckPackages, which checks multiple packages and platforms.
 func (c *Checker) Check(rel string, recurse bool, beforeRev, afterRev string) ([]Change, error) {
+	return c.CheckContext(context.Background(), rel, recurse, beforeRev, afterRev)
+}
+
+// CheckContext is Check using ctx, if ctx is done the check is abandoned and
+// ctx's error is returned. Reads from the VCS use ctx if it's a ContextVCS.
+//
+// Deprecated: use CheckPackages, which checks multiple packages and platforms.
+func (c *Checker) CheckContext(ctx context.Context, rel string, recurse bool, beforeRev, afterRev string) ([]Change, error) {
+	pattern := rel
+	if pattern == "" {
+		pattern = "."
+	}
+	if recurse {
+		pattern += string(os.PathSeparator) + "..."
+	}
+	return c.CheckPackages(ctx, CheckRequest{Patterns: []string{pattern}, Before: beforeRev, After: afterRev})
+}
+
+// CheckPackages checks the packages in req for changes between the before and
+// after revision. If ctx is done the check is abandoned and ctx's error is
+// returned, reads from the VCS use ctx if it's a ContextVCS.
+func (c *Checker) CheckPackages(ctx context.Context, req CheckRequest) ([]Change, error) {
+	report, err := c.CheckReport(ctx, req)
+	return report.Changes, err
+}
+
+// CheckReport is CheckPackages, also returning any diagnostics.
+func (c *Checker) CheckReport(ctx context.Context, req CheckRequest) (Report, error) {
+	var report Report
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+
 	// If revision is unset use VCS's default revision
-	dBefore, dAfter := c.vcs.DefaultRevision()
-	if beforeRev == "" {
-		beforeRev = dBefore
+	beforeRev, afterRev := c.revisions(req)
+
+	chk := check{
+		Checker: c,
+		ctx:     ctx,
+		vcs:     withContext(ctx, c.vcs),
+		build:   c.defaultBuild(),
+		recurse: make(map[string]bool),
+	}
+	if c.excludeFile != nil {
+		chk.excludeFiles = append(chk.excludeFiles, c.excludeFile)
+	}
+	if c.excludeDir != nil {
+		chk.excludeDirs = append(chk.excludeDirs, c.excludeDir)
+	}
+	if req.ExcludeFile != "" {
+		re, err := regexp.Compile(req.ExcludeFile)
+		if err != nil {
+			return report, fmt.Errorf("invalid exclude file pattern: %v", err)
+		}
+		chk.excludeFiles = append(chk.excludeFiles, re)
 	}
-	if afterRev == "" {
-		afterRev = dAfter
+	if req.ExcludeDir != "" {
+		re, err := regexp.Compile(req.ExcludeDir)
+		if err != nil {
+			return report, fmt.Errorf("invalid exclude directory pattern: %v", err)
+		}
+		chk.excludeDirs = append(chk.excludeDirs, re)
 	}
-	c.recurse = recurse
 
-	var err error
-	c.path, err = importPathTo(rel)
-	if err != nil {
-		return nil, err
+	patterns := req.Patterns
+	if len(patterns) == 0 {
+		patterns = []string{""}
+	}
+	for _, pattern := range patterns {
+		rel, recurse, err := relativePathToTarget(pattern, chk.build.GOPATH)
+		if err != nil {
+			return report, err
+		}
+		path, err := importPathTo(rel, chk.build.GOPATH)
+		if err != nil {
+			return report, err
+		}
+		if _, ok := chk.recurse[path]; !ok {
+			chk.roots = append(chk.roots, path)
+		}
+		chk.recurse[path] = chk.recurse[path] || recurse
+	}
+
+	platforms := req.Platforms
+	if len(platforms) == 0 {
+		platforms = []string{chk.build.GOOS + "/" + chk.build.GOARCH}
+	}
+
+	var (
+		seen      = make(map[changeKey]bool)
+		seenDiags = make(map[string]bool)
+	)
+	for _, platform := range platforms {
+		var err error
+		chk.build.GOOS, chk.build.GOARCH, err = parsePlatform(platform)
+		if err != nil {
+			return report, err
+		}
+
+		c.logf("import paths: %q before: %q after: %q platform: %v\n", chk.roots, beforeRev, afterRev, platform)
+		pchanges, diags, err := chk.run(beforeRev, afterRev)
+		if err != nil {
+			return report, err
+		}
+		for _, change := range pchanges {
+			key := changeKey{pkg: change.Pkg, id: change.ID, change: change.Change, msg: change.Msg}
+			if !seen[key] {
+				seen[key] = true
+				report.Changes = append(report.Changes, change)
+			}
+		}
+		for _, diag := range diags {
+			if key := diag.String(); !seenDiags[key] {
+				seenDiags[key] = true
+				report.Diagnostics = append(report.Diagnostics, diag)
+			}
+		}
 	}
 
-	c.logf("import path: %q before: %q after: %q recursive: %v\n", c.path, beforeRev, afterRev, c.recurse)
+	start := time.Now()
+	sort.Sort(byID(report.Changes))
+	c.logf("Timing: sort: %v\n", time.Since(start))
+	c.logf("Changes detected: %v\n", len(report.Changes))
+
+	if len(c.corpus) > 0 {
+		start = time.Now()
+		if err := countUsage(ctx, report.Changes, c.corpus); err != nil {
+			return report, err
+		}
+		c.logf("Timing: usage: %v\n", time.Since(start))
+	}
+
+	return report, nil
+}
+
+// parsePlatform returns the GOOS and GOARCH of a GOOS/GOARCH platform.
+func parsePlatform(platform string) (goos, goarch string, err error) {
+	i := strings.IndexByte(platform, '/')
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid platform %q, must be GOOS/GOARCH", platform)
+	}
+	return platform[:i], platform[i+1:], nil
+}
 
-	// Parse revisions from VCS into go/ast
+// run parses both revisions for the check's platform and compares them.
+func (c check) run(beforeRev, afterRev string) ([]Change, []Diagnostic, error) {
+	// Parse revisions from VCS into go/ast, both revisions share the
+	// semaphore limiting the number of packages parsed concurrently
 	start := time.Now()
-	if c.b, err = c.parse(beforeRev); err != nil {
-		return nil, err
+	concurrency := c.concurrency
+	if concurrency < 1 {
+		concurrency = runtime.GOMAXPROCS(0)
 	}
-	if c.a, err = c.parse(afterRev); err != nil {
-		return nil, err
+	var (
+		sem            = make(chan struct{}, concurrency)
+		wg             sync.WaitGroup
+		bpkgs, apkgs   map[string]pkg
+		bdiags, adiags []Diagnostic
+		berr, aerr     error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		bpkgs, bdiags, berr = c.parse(beforeRev, sem)
+	}()
+	go func() {
+		defer wg.Done()
+		apkgs, adiags, aerr = c.parse(afterRev, sem)
+	}()
+	wg.Wait()
+	if berr != nil {
+		return nil, nil, berr
+	}
+	if aerr != nil {
+		return nil, nil, aerr
+	}
+	// A package which failed to parse in lenient mode isn't compared, rather
+	// than being reported as removed or added
+	for _, diag := range bdiags {
+		if errors.As(diag.Err, new(*ParseError)) {
+			delete(apkgs, diag.Pkg)
+		}
+	}
+	for _, diag := range adiags {
+		if errors.As(diag.Err, new(*ParseError)) {
+			delete(bpkgs, diag.Pkg)
+		}
 	}
 	parse := time.Since(start)
 
 	start = time.Now()
-	changes, err := c.compareDecls()
+	changes, diags, err := c.compareDecls(bpkgs, apkgs)
 	if err != nil {
-		var buf bytes.Buffer
-		fmt.Fprintf(&buf, "error comparing declarations: %s\n", err)
-		if derr, ok := err.(*diffError); ok {
-			_ = ast.Fprint(&buf, c.b[derr.pkg].fset, derr.bdecl, ast.NotNilFilter)
-			_ = ast.Fprint(&buf, c.a[derr.pkg].fset, derr.adecl, ast.NotNilFilter)
+		var cerr *CompareError
+		if errors.As(err, &cerr) && c.vlog != nil {
+			var buf bytes.Buffer
+			_ = ast.Fprint(&buf, bpkgs[cerr.Pkg].fset, cerr.Before, ast.NotNilFilter)
+			_ = ast.Fprint(&buf, apkgs[cerr.Pkg].fset, cerr.After, ast.NotNilFilter)
+			c.logf("%s\n%s", err, buf.Bytes())
 		}
-		return nil, errors.New(buf.String())
+		return nil, nil, err
 	}
 	diff := time.Since(start)
 
-	start = time.Now()
-	sort.Sort(byID(changes))
-	sort := time.Since(start)
+	c.logf("Timing: parse: %v, diff: %v, total: %v\n", parse, diff, parse+diff)
+	diags = append(append(bdiags, adiags...), diags...)
+	return changes, diags, nil
+}
 
-	c.logf("Timing: parse: %v, diff: %v, sort: %v, total: %v\n", parse, diff, sort, parse+diff+sort)
-	c.logf("Changes detected: %v\n", len(changes))
+// excluded returns true if s matches any of res.
+func excluded(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludedSegment returns true if any segment of path is one of the excluded
+// directory names.
+func (c *Checker) excludedSegment(path string) bool {
+	for _, name := range c.excludeSegments {
+		if hasSegment(path, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// internalVisible returns true if path, an import path or directory, has no
+// internal segment, or may be imported by one of the internal consumers. Go
+// only permits importing a path containing an internal segment from within the
+// tree rooted at the parent of the last internal segment. A directory's import
+// path is found from GOPATH, or else its module's go.mod.
+func (c check) internalVisible(path string) (bool, error) {
+	if !hasSegment(path, "internal") {
+		return true, nil
+	}
+	if build.IsLocalImport(path) || filepath.IsAbs(path) {
+		if len(c.internalConsumers) == 0 {
+			return false, nil
+		}
+		importPath, err := importPathTo(path, c.build.GOPATH)
+		if err != nil {
+			importPath, err = moduleImportPath(path)
+		}
+		if err != nil {
+			return false, fmt.Errorf("could not find import path of %s for internal consumers: %v", path, err)
+		}
+		path = importPath
+	}
+	segments := strings.Split(filepath.ToSlash(path), "/")
+	last := -1
+	for i, segment := range segments {
+		if segment == "internal" {
+			last = i
+		}
+	}
+	if last < 0 {
+		return true, nil
+	}
+
+	root := strings.Join(segments[:last], "/")
+	for _, consumer := range c.internalConsumers {
+		prefix := strings.TrimSuffix(consumer, "/...")
+		switch {
+		case root == "", prefix == root, strings.HasPrefix(prefix, root+"/"):
+			return true, nil
+		case prefix != consumer && strings.HasPrefix(root, prefix+"/"):
+			// some package beneath the pattern is within the root
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
-	return changes, nil
+// hasSegment returns true if any segment of path is name.
+func hasSegment(path, name string) bool {
+	for _, segment := range strings.Split(filepath.ToSlash(path), "/") {
+		if segment == name {
+			return true
+		}
+	}
+	return false
 }
 
-func importPathTo(rel string) (string, error) {
-	gopaths := filepath.SplitList(os.Getenv("GOPATH"))
+// importPathTo returns the import path of the directory rel within gopath, a
+// list of GOPATH directories.
+func importPathTo(rel, gopath string) (string, error) {
+	gopaths := filepath.SplitList(gopath)
 	for _, gopath := range gopaths {
 		abs, err := filepath.Abs(rel)
 		if err != nil {
 			return "", err
 		}
-		if strings.HasPrefix(abs, gopath) {
-			return abs[len(gopath)+2+len("src"):], nil
+		src := filepath.Join(gopath, "src") + string(os.PathSeparator)
+		if strings.HasPrefix(abs, src) {
+			return abs[len(src):], nil
 		}
 	}
 	return "", errImportPathNotFound
 }
 
+// moduleImportPath returns the import path of the directory rel from the
+// module path of the closest go.mod in it or its parents.
+func moduleImportPath(rel string) (string, error) {
+	abs, err := filepath.Abs(rel)
+	if err != nil {
+		return "", err
+	}
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		f, err := os.Open(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			mod, err := parseGoMod(f)
+			f.Close()
+			if err != nil {
+				return "", err
+			}
+			sub, err := filepath.Rel(dir, abs)
+			if err != nil {
+				return "", err
+			}
+			if sub == "." {
+				return mod.path, nil
+			}
+			return mod.path + "/" + filepath.ToSlash(sub), nil
+		}
+		if filepath.Dir(dir) == dir {
+			return "", errImportPathNotFound
+		}
+	}
+}
+
 // RelativePathToTarget returns the relative path to the given path, wether it's
 // an import path or direct path and also returns if the path had recursion
 // requested (/...).
 func RelativePathToTarget(path string) (rel string, recurse bool, err error) {
+	return relativePathToTarget(path, os.Getenv("GOPATH"))
+}
+
+// relativePathToTarget is RelativePathToTarget, finding import paths in
+// gopath.
+func relativePathToTarget(path, gopath string) (rel string, recurse bool, err error) {
 	// Detect recursion
 	if strings.HasSuffix(path, string(os.PathSeparator)+"...") {
 		recurse = true
@@ -176,7 +615,7 @@ func RelativePathToTarget(path string) (rel string, recurse bool, err error) {
 			if serr, ok := perr.Err.(syscall.Errno); ok {
 				if serr == syscall.ENOENT { // we might be given a import path.
 					var err error
-					path, err = findRelativeFromImport(path)
+					path, err = findRelativeFromImport(path, gopath)
 					if err != nil {
 						return "", false, err
 					}
@@ -189,8 +628,8 @@ func RelativePathToTarget(path string) (rel string, recurse bool, err error) {
 	return path, recurse, nil
 }
 
-func findRelativeFromImport(path string) (string, error) {
-	gopaths := filepath.SplitList(os.Getenv("GOPATH"))
+func findRelativeFromImport(path, gopath string) (string, error) {
+	gopaths := filepath.SplitList(gopath)
 	for _, gopath := range gopaths {
 		fullpath := filepath.Join(gopath, "src", path)
 		if _, err := os.Stat(fullpath); err == nil {
@@ -209,72 +648,213 @@ func findRelativeFromImport(path string) (string, error) {
 }
 
 func (c Checker) logf(format string, a ...interface{}) {
-	if c.vlog != nil {
-		fmt.Fprintf(c.vlog, format, a...)
+	if c.vlog == nil {
+		return
+	}
+	if c.logMu != nil {
+		c.logMu.Lock()
+		defer c.logMu.Unlock()
 	}
+	fmt.Fprintf(c.vlog, format, a...)
 }
 
 type pkg struct {
 	importPath string // import path
+	rev        string // revision the package was parsed at
 	fset       *token.FileSet
 	decls      map[string]ast.Decl
 	info       *types.Info
+	diags      []Diagnostic // type check errors tolerated in lenient mode
+	tpkg       *types.Package
+	clause     token.Pos // position of the package clause's name in the first file
+	module     string    // module path, or import path of the GOPATH repository, containing the package
+
+	brokenImports bool // an import, directly or transitively, has type errors tolerated in lenient mode
+
+	// sources for suggested fixes
+	srcs   map[string][]byte                // file name -> contents
+	bodies map[*ast.FuncDecl]*ast.BlockStmt // function bodies removed by pkgDecls
 }
 
-func (c Checker) parse(rev string) (pkgs map[string]pkg, err error) {
-	c.logf("Parsing revision: %s path: %s recurse: %v\n", rev, c.path, c.recurse)
+// parse parses and type checks all packages at revision rev, each package is
+// parsed concurrently, limited by the capacity of sem. Packages not yet parsed
+// when the check's context is done are skipped. In lenient mode, packages
+// which fail to parse, and type errors in imported packages, are returned as
+// diagnostics.
+func (c check) parse(rev string, sem chan struct{}) (pkgs map[string]pkg, diags []Diagnostic, err error) {
+	var (
+		paths     []string
+		recursive = make(map[string]bool) // path -> found by recursion
+		known     = make(map[string]bool) // directories found by recursion, which may only exist at rev
+	)
+	for _, root := range c.roots {
+		c.logf("Parsing revision: %s path: %s recurse: %v\n", rev, root, c.recurse[root])
 
-	// c.path is either dot or import path
-	paths := []string{c.path}
-	if c.recurse {
+		// root is either dot or import path
+		rootPaths := []string{root}
+		if c.recurse[root] {
 
-		// Technically this isn't correct, GOPATH could be a list
-		dir, err := findGOPATH(c.path)
-		if err != nil {
-			return nil, err
+			// Technically this isn't correct, GOPATH could be a list
+			dir, err := findGOPATH(root, c.build.GOPATH)
+			if err != nil {
+				return nil, nil, err
+			}
+			dir = filepath.Join(dir, "src")
+			var prefix string
+			if root == cwd {
+				// could root = getwd instead ?
+				if dir, err = os.Getwd(); err != nil {
+					return nil, nil, err
+				}
+				prefix = "." + string(os.PathSeparator)
+			}
+			dirs := c.getDirsRecursive(dir, rev, root, prefix)
+			for _, path := range dirs {
+				known[filepath.Join(dir, path)] = true
+			}
+			rootPaths = append(rootPaths, dirs...)
 		}
-		dir = filepath.Join(dir, "src")
-		var prefix string
-		if c.path == cwd {
-			// could c.path = getwd instead ?
-			if dir, err = os.Getwd(); err != nil {
-				return nil, err
+
+		for _, path := range rootPaths {
+			if _, ok := recursive[path]; !ok {
+				paths = append(paths, path)
 			}
-			prefix = "." + string(os.PathSeparator)
+			recursive[path] = recursive[path] || c.recurse[root]
 		}
-		paths = append(paths, c.getDirsRecursive(dir, rev, c.path, prefix)...)
 	}
 
 	c.logf("building paths: %s\n", paths)
 
-	pkgs = make(map[string]pkg)
-	for _, path := range paths {
-		if c.excludeDir != nil && c.excludeDir.MatchString(path) {
+	type result struct {
+		p   pkg
+		err error
+	}
+	var (
+		results = make([]*result, len(paths))
+		imp     = newVCSImporter(c.vcs, rev, c.build)
+		wg      sync.WaitGroup
+	)
+	imp.lenient = c.lenient
+	for i, path := range paths {
+		if excluded(c.excludeDirs, path) {
 			c.logf("Excluding path: %s\n", path)
 			continue
 		}
-		if strings.Contains(path, "internal/") || strings.Contains(path, "vendor/") {
+		if c.excludedSegment(path) {
 			c.logf("Excluding path: %s\n", path)
 			continue
 		}
-
-		p, err := c.parseDir(rev, path)
+		visible, err := c.internalVisible(path)
 		if err != nil {
-			if err == errSkipPackage {
+			return nil, nil, err
+		}
+		if !visible {
+			c.logf("Excluding path: %s\n", path)
+			continue
+		}
+
+		results[i] = &result{}
+		wg.Add(1)
+		go func(r *result, path string) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-c.ctx.Done():
+				r.err = c.ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			r.p, r.err = c.parseDir(rev, path, imp, known)
+		}(results[i], path)
+	}
+	wg.Wait()
+	if err := c.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// Handle results in the order of paths, so errors are deterministic
+	pkgs = make(map[string]pkg)
+	for i, r := range results {
+		if r == nil {
+			// excluded
+			continue
+		}
+		if r.err != nil {
+			if r.err == errSkipPackage {
 				continue
 			}
 			// skip errors if we're recursing and the error is no buildable sources
-			if !c.recurse || !strings.Contains(err.Error(), "no buildable") {
-				return pkgs, err
+			var noGo *build.NoGoError
+			if recursive[paths[i]] && errors.As(r.err, &noGo) {
+				continue
+			}
+			var perr *ParseError
+			if c.lenient && errors.As(r.err, &perr) {
+				diags = append(diags, perr.diagnostic())
+				continue
+			}
+			return pkgs, nil, r.err
+		}
+		pkgs[r.p.importPath] = r.p
+	}
+	// Type errors in packages which are checked are already diagnostics
+	broken := make(map[string]bool)
+	for _, diag := range imp.diagnostics() {
+		broken[diag.Pkg] = true
+		if _, ok := pkgs[diag.Pkg]; !ok {
+			diags = append(diags, diag)
+		}
+	}
+	// Packages importing a package with type errors are low confidence
+	if len(broken) > 0 {
+		for path, p := range pkgs {
+			if p.tpkg != nil && importsBroken(p.tpkg, broken, make(map[*types.Package]bool)) {
+				p.brokenImports = true
+				pkgs[path] = p
 			}
 		}
-		pkgs[p.importPath] = p
 	}
-	return pkgs, nil
+	return pkgs, diags, nil
+}
+
+// importsBroken returns true if tpkg imports, directly or transitively, any of
+// the import paths in paths. seen holds the packages already walked.
+func importsBroken(tpkg *types.Package, paths map[string]bool, seen map[*types.Package]bool) bool {
+	for _, imp := range tpkg.Imports() {
+		if seen[imp] {
+			continue
+		}
+		seen[imp] = true
+		if paths[imp.Path()] || importsBroken(imp, paths, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// typeCheckError returns a *TypeCheckError for an error from go/types.
+func (p pkg) typeCheckError(err error) *TypeCheckError {
+	terr := &TypeCheckError{Rev: p.rev, Pkg: p.importPath, Msg: err.Error(), Err: err}
+	if e, ok := err.(types.Error); ok {
+		terr.Pos = p.position(e.Pos)
+		terr.File = terr.Pos.Filename
+		terr.Msg = e.Msg
+	}
+	return terr
 }
 
-func findGOPATH(path string) (string, error) {
-	for _, gopath := range filepath.SplitList(os.Getenv("GOPATH")) {
+// findGOPATH returns the directory of gopaths, a GOPATH list, containing the
+// import path, or the directory relative to the current directory, path.
+func findGOPATH(path, gopaths string) (string, error) {
+	if path != cwd {
+		// An import path, which may be outside of the current directory
+		for _, gopath := range filepath.SplitList(gopaths) {
+			if fi, err := os.Stat(filepath.Join(gopath, "src", filepath.FromSlash(path))); err == nil && fi.IsDir() {
+				return gopath, nil
+			}
+		}
+	}
+	for _, gopath := range filepath.SplitList(gopaths) {
 		abs, err := filepath.Abs(path)
 		if err != nil {
 			return "", err
@@ -288,7 +868,7 @@ func findGOPATH(path string) (string, error) {
 
 // getDirsRecursive returns relative paths to all subdirectories within base
 // at revision rev. Paths can be prefixed with prefix
-func (c Checker) getDirsRecursive(base, rev, rel, prefix string) (dirs []string) {
+func (c check) getDirsRecursive(base, rev, rel, prefix string) (dirs []string) {
 	paths, err := c.vcs.ReadDir(rev, filepath.Join(base, rel))
 	if err != nil {
 		c.logf("could not read path: %s revision: %s, error: %s\n", filepath.Join(base, rel), rev, err)
@@ -296,7 +876,7 @@ func (c Checker) getDirsRecursive(base, rev, rel, prefix string) (dirs []string)
 	}
 
 	for _, path := range paths {
-		if !path.IsDir() || path.Name() == "testdata" {
+		if !path.IsDir() || c.excludedSegment(path.Name()) {
 			continue
 		}
 
@@ -306,17 +886,19 @@ func (c Checker) getDirsRecursive(base, rev, rel, prefix string) (dirs []string)
 	return dirs
 }
 
-func (c Checker) parseDir(rev, dir string) (pkg, error) {
-
+// parseDir parses and type checks the package in dir at rev, imports are
+// resolved by imp at the same revision. Directories in known exist at rev,
+// even if not on the file system, such as a package since removed.
+func (c check) parseDir(rev, dir string, imp *vcsImporter, known map[string]bool) (pkg, error) {
 	// Use go/build to get the list of files relevant for a specific OS and ARCH
-	ctx := build.Default
-	ctx.ReadDir = func(dir string) ([]os.FileInfo, error) {
-		return c.vcs.ReadDir(rev, dir)
-	}
-	ctx.OpenFile = func(path string) (io.ReadCloser, error) {
-		return c.vcs.OpenFile(rev, path)
+	ctx := c.buildContext(rev)
+	ctx.IsDir = func(path string) bool {
+		if known[path] {
+			return true
+		}
+		fi, err := os.Stat(path)
+		return err == nil && fi.IsDir()
 	}
-	ctx.GOPATH = os.Getenv("GOPATH")
 
 	// wd is for relative imports, such as "."
 	wd, err := os.Getwd()
@@ -325,39 +907,78 @@ func (c Checker) parseDir(rev, dir string) (pkg, error) {
 	}
 	ipkg, err := ctx.Import(dir, wd, 0)
 	if err != nil {
-		return pkg{}, fmt.Errorf("go/build error: %v", err)
+		perr := &ParseError{Rev: rev, Pkg: dir, Err: err}
+		if ipkg != nil && ipkg.ImportPath != "" && ipkg.ImportPath != "." {
+			perr.Pkg = ipkg.ImportPath
+		}
+		return pkg{}, perr
 	}
 
 	if ipkg.Name == "main" {
 		return pkg{}, errSkipPackage
 	}
+	return c.parseFiles(rev, wd, ipkg, imp)
+}
+
+// buildContext returns the check's go/build context, reading files at rev.
+func (c check) buildContext(rev string) build.Context {
+	ctx := c.build
+	ctx.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		return c.vcs.ReadDir(rev, dir)
+	}
+	ctx.OpenFile = func(path string) (io.ReadCloser, error) {
+		return c.vcs.OpenFile(rev, path)
+	}
+	return ctx
+}
 
+// readFile returns the contents of file at revision rev.
+func readFile(vcs VCS, rev, file string) ([]byte, error) {
+	rc, err := vcs.OpenFile(rev, file)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// parseFiles parses and type checks the Go files of ipkg at revision rev,
+// file names are relative to wd.
+func (c check) parseFiles(rev, wd string, ipkg *build.Package, imp *vcsImporter) (pkg, error) {
 	var (
 		fset     = token.NewFileSet()
 		pkgFiles []*ast.File
+		srcs     = make(map[string][]byte)
 	)
 	for _, file := range ipkg.GoFiles {
-		if c.excludeFile != nil && c.excludeFile.MatchString(file) {
+		if excluded(c.excludeFiles, file) {
 			c.logf("Excluding file: %s\n", file)
 			continue
 		}
 
-		contents, err := c.vcs.OpenFile(rev, filepath.Join(ipkg.Dir, file))
+		contents, err := readFile(c.vcs, rev, filepath.Join(ipkg.Dir, file))
 		if err != nil {
-			return pkg{}, fmt.Errorf("could not read file %q at revision %q: %s", file, rev, err)
+			return pkg{}, &ParseError{Rev: rev, Pkg: ipkg.ImportPath, File: file, Err: err}
 		}
 
 		filename, err := filepath.Rel(wd, filepath.Join(ipkg.Dir, file))
 		if err != nil {
-			return pkg{}, fmt.Errorf("could not make path relative for revision %q: %s", rev, err)
+			return pkg{}, &ParseError{Rev: rev, Pkg: ipkg.ImportPath, File: file, Err: err}
 		}
 		if rev != revisionFS {
 			// prefix revision to file's path when reading from vcs and not file system
 			filename = rev + ":" + filename
 		}
+		srcs[filename] = contents
 		src, err := parser.ParseFile(fset, filename, contents, 0)
 		if err != nil {
-			return pkg{}, fmt.Errorf("could not parse file %q at revision %q: %s", file, rev, err)
+			perr := &ParseError{Rev: rev, Pkg: ipkg.ImportPath, File: file, Err: err}
+			if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+				perr.Pos = list[0].Pos
+				perr.Pos.Filename = strings.TrimPrefix(perr.Pos.Filename, rev+":")
+				perr.Err = errors.New(list[0].Msg)
+			}
+			return pkg{}, perr
 		}
 
 		pkgFiles = append(pkgFiles, src)
@@ -366,7 +987,10 @@ func (c Checker) parseDir(rev, dir string) (pkg, error) {
 	// Loop through all the parsed files and type check them
 	p := pkg{
 		importPath: ipkg.ImportPath,
+		rev:        rev,
 		fset:       fset,
+		srcs:       srcs,
+		module:     c.modulePath(ipkg.Dir, imp),
 		info: &types.Info{
 			Types: make(map[ast.Expr]types.TypeAndValue),
 			Defs:  make(map[*ast.Ident]types.Object),
@@ -377,37 +1001,74 @@ func (c Checker) parseDir(rev, dir string) (pkg, error) {
 	conf := &types.Config{
 		IgnoreFuncBodies:         true,
 		DisableUnusedImportCheck: true,
-		Importer:                 importer.Default(),
+		Importer:                 imp.forDir(ipkg.Dir),
 	}
-	_, err = conf.Check(ipkg.ImportPath, fset, pkgFiles, p.info)
-	if err != nil {
-		return pkg{}, fmt.Errorf("go/types error: %v", err)
+	if c.lenient {
+		// continue after errors, using the partial type information
+		conf.Error = func(err error) {
+			terr := p.typeCheckError(err)
+			p.diags = append(p.diags, Diagnostic{Pkg: p.importPath, Rev: rev, Pos: terr.Pos, Msg: terr.Msg, Err: terr})
+		}
+	}
+	tpkg, err := conf.Check(ipkg.ImportPath, fset, pkgFiles, p.info)
+	if err != nil && !c.lenient {
+		return pkg{}, p.typeCheckError(err)
+	}
+
+	if len(pkgFiles) > 0 {
+		p.clause = pkgFiles[0].Name.Pos()
 	}
 
 	// Get declarations and nil their bodies, so do it last
-	p.decls = pkgDecls(pkgFiles)
+	p.tpkg = tpkg
+	p.saveBodies(pkgFiles)
+	p.decls = pkgDecls(pkgFiles, p.info, tpkg)
 
 	return p, nil
 }
 
+// modulePath returns the module path of the go.mod at imp's revision in dir
+// or its closest parent, or else the import path of the GOPATH repository
+// containing dir, or an empty string if neither is found.
+func (c check) modulePath(dir string, imp *vcsImporter) string {
+	if mod := imp.goMod(dir); mod != nil {
+		return mod.path
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for d := abs; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			path, err := importPathTo(d, c.build.GOPATH)
+			if err != nil {
+				return ""
+			}
+			return filepath.ToSlash(path)
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
 // pkgDecls returns all declarations that need to be checked, this includes
-// all exported declarations as well as unexported types that are returned by
-// exported functions.
+// all exported declarations as well as unexported types, and their exported
+// methods, which are reachable from them, such as the result of an exported
+// function or the type of an exported field. info and tpkg are the type
+// checked package.
 //
 // Remove struct's private members and separate indentifier lists
 // into one per declaration.
 // from: struct { p1, p2 int, P3, P4 uint }
 // into: struct { P3 uint, P4 uint }
-func pkgDecls(files []*ast.File) map[string]ast.Decl {
+func pkgDecls(files []*ast.File, info *types.Info, tpkg *types.Package) map[string]ast.Decl {
 	var (
 		// exported values and functions
 		decls = make(map[string]ast.Decl)
 
 		// unexported values and functions
 		priv = make(map[string]ast.Decl)
-
-		// IDs of ValSpecs that are returned by a function
-		returned []string
 	)
 	for _, file := range files {
 		for _, astDecl := range file.Decls {
@@ -497,21 +1158,6 @@ func pkgDecls(files []*ast.File) map[string]ast.Decl {
 					// We're not interested in the body, nil it, alternatively we could set an
 					// Body.List, but that included parenthesis on different lines when printed
 					decls[id] = astDecl
-
-					// note which ident types are returned, to find those that were not
-					// exported but are returned and therefor need to be checked
-					if d.Type.Results != nil {
-						for _, field := range d.Type.Results.List {
-							switch ftype := field.Type.(type) {
-							case *ast.Ident:
-								returned = append(returned, ftype.String())
-							case *ast.StarExpr:
-								if ident, ok := ftype.X.(*ast.Ident); ok {
-									returned = append(returned, ident.String())
-								}
-							}
-						}
-					}
 				} else {
 					priv[id] = astDecl
 				}
@@ -521,24 +1167,33 @@ func pkgDecls(files []*ast.File) map[string]ast.Decl {
 		}
 	}
 
-	// Add any value specs returned by a function, but wasn't exported
-	for _, id := range returned {
-		// Find unexported types that need to be checked
-		if _, ok := priv[id]; ok {
-			decls[id] = priv[id]
+	// Add unexported types reachable from exported declarations, along with
+	// their exported methods
+	var objs []types.Object
+	for _, decl := range decls {
+		if obj := declObject(info, decl); obj != nil {
+			objs = append(objs, obj)
 		}
-
-		// Find exported functions with unexported receivers that also need to be checked
-		for rid, decl := range priv {
-			dotIndex := strings.IndexRune(rid, '.')
-			if dotIndex < 0 {
-				continue
+	}
+	seen := make(map[*types.TypeName]bool)
+	for _, obj := range objs {
+		walkType(declType(obj), func(named *types.Named) bool {
+			tname := named.Obj()
+			if tname.Pkg() != tpkg || seen[tname] {
+				return false
 			}
-			pid, pfunc := rid[:dotIndex], rid[dotIndex+1:]
-			if id == pid && ast.IsExported(pfunc) {
-				decls[rid] = decl
+			seen[tname] = true
+			if decl, ok := priv[tname.Name()]; ok {
+				decls[tname.Name()] = decl
 			}
-		}
+			for i := 0; i < named.NumMethods(); i++ {
+				id := tname.Name() + "." + named.Method(i).Name()
+				if decl, ok := priv[id]; ok && named.Method(i).Exported() {
+					decls[id] = decl
+				}
+			}
+			return true
+		})
 	}
 	return decls
 }
@@ -600,13 +1255,34 @@ func keepField(expr ast.Expr, isStruct bool) bool {
 
 // Change is the ast declaration containing the before and after
 type Change struct {
-	Pkg    string   // Pkg is the name of the package the change occurred in
-	ID     string   // ID is an identifier to match a declaration between versions
-	Msg    string   // Msg describes the change
-	Change string   // Change describes whether it was unknown, no change, non-breaking or breaking change
-	Pos    string   // Pos is the ASTs position prefixed with a version
-	Before ast.Decl // Before is the previous declaration
-	After  ast.Decl // After is the new declaration
+	Pkg       string         // Pkg is the name of the package the change occurred in
+	ID        string         // ID is an identifier to match a declaration between versions
+	Msg       string         // Msg describes the change, one of a fixed set so changes can be grouped by it
+	Detail    string         // Detail describes the change with the names Msg omits, such as the new name of a renamed declaration, empty if Msg is complete
+	Change    string         // Change describes whether it was unknown, no change, non-breaking or breaking change
+	Pos       string         // Pos is the ASTs position prefixed with a version
+	Before    ast.Decl       // Before is the previous declaration
+	After     ast.Decl       // After is the new declaration
+	BeforePos token.Position // BeforePos is the position of Before, without the revision prefix
+	AfterPos  token.Position // AfterPos is the position of the change in After, without the revision prefix
+
+	// LowConfidence is set in lenient mode if the package, or a package it
+	// imports, had type errors, so the change may be incorrect or other
+	// changes missed
+	LowConfidence bool
+
+	// Usage is the number of packages in the usage corpus which reference
+	// the declaration, an upper bound for methods, see SetUsageCorpus
+	Usage int
+
+	// Fixes are suggested edits to the after revision restoring
+	// compatibility, set for some breaking changes. A Change isn't
+	// comparable with ==, as it holds Fixes
+	Fixes []SuggestedFix
+
+	// BeforeFset and AfterFset are the file sets of Before and After, used
+	// by Source to format them
+	BeforeFset, AfterFset *token.FileSet
 }
 
 func (c Change) String() string {
@@ -614,7 +1290,14 @@ func (c Change) String() string {
 	var buf bytes.Buffer
 	pcfg := printer.Config{Mode: printer.RawFormat, Indent: 1}
 
-	fmt.Fprintf(&buf, "%s: %s %s\n", c.Pos, c.Change, c.Msg)
+	fmt.Fprintf(&buf, "%s: %s %s", c.Pos, c.Change, c.Description())
+	if c.LowConfidence {
+		fmt.Fprint(&buf, " (low confidence)")
+	}
+	if c.Usage > 0 {
+		fmt.Fprintf(&buf, " (used by %d packages)", c.Usage)
+	}
+	fmt.Fprintln(&buf)
 
 	if c.Before != nil {
 		_ = pcfg.Fprint(&buf, &fset, c.Before)
@@ -627,42 +1310,131 @@ func (c Change) String() string {
 	return buf.String()
 }
 
-// byID implements sort.Interface for []change based on the id field
-type byID []Change
+// Description returns Detail, or Msg if Detail is empty.
+func (c Change) Description() string {
+	if c.Detail != "" {
+		return c.Detail
+	}
+	return c.Msg
+}
 
-func (a byID) Len() int           { return len(a) }
-func (a byID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byID) Less(i, j int) bool { return a[i].ID < a[j].ID }
+// Source returns the gofmt formatted source of the before and after
+// declarations, either is empty if the declaration was added or removed.
+func (c Change) Source() (before, after string) {
+	return formatDecl(c.BeforeFset, c.Before), formatDecl(c.AfterFset, c.After)
+}
 
-type diffError struct {
-	err error
-	pkg string
-	bdecl,
-	adecl ast.Decl
+// formatDecl returns the gofmt formatted source of decl, or an empty string if
+// decl is nil.
+func formatDecl(fset *token.FileSet, decl ast.Decl) string {
+	if decl == nil {
+		return ""
+	}
+	if fset == nil {
+		fset = token.NewFileSet()
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, decl); err != nil {
+		// fallback to the raw printer, as used by String
+		buf.Reset()
+		pcfg := printer.Config{Mode: printer.RawFormat}
+		_ = pcfg.Fprint(&buf, &token.FileSet{}, decl)
+	}
+	return buf.String()
+}
+
+// changeKey identifies a change reported by more than one platform.
+type changeKey struct {
+	pkg, id, change, msg string
 }
 
-func (e diffError) Error() string {
-	return e.err.Error()
+// byID implements sort.Interface for []change based on the id field
+type byID []Change
+
+func (a byID) Len() int      { return len(a) }
+func (a byID) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byID) Less(i, j int) bool {
+	if a[i].ID == a[j].ID {
+		// the same ID in different packages
+		return a[i].Pkg < a[j].Pkg
+	}
+	return a[i].ID < a[j].ID
 }
 
 // compareDecls compares a Checker's before and after declarations and returns
-// all changes or nil and an error
-func (c Checker) compareDecls() ([]Change, error) {
-	var changes []Change
-	for pkgName, bpkg := range c.b {
-		apkg, ok := c.a[pkgName]
+// all changes or nil and an error. In lenient mode, declarations which fail to
+// compare in packages with type errors are reported as diagnostics instead.
+func (c check) compareDecls(b, a map[string]pkg) ([]Change, []Diagnostic, error) {
+	var (
+		changes []Change
+		diags   []Diagnostic
+	)
+	// packages in the check aren't dependencies, as they're checked themselves
+	checked := func(path string) bool {
+		_, bok := b[path]
+		_, aok := a[path]
+		return bok || aok
+	}
+	for pkgName, bpkg := range b {
+		apkg, ok := a[pkgName]
 		if !ok {
-			c := Change{Pkg: pkgName, Change: Breaking, Msg: "package removed"}
-			changes = append(changes, c)
+			// removed, see packageChanges
+			diags = append(diags, bpkg.diags...)
 			continue
 		}
 
+		// changes to packages with type errors may be incorrect
+		var (
+			pkgStart      = len(changes)
+			lowConfidence = len(bpkg.diags) > 0 || len(apkg.diags) > 0 || bpkg.brokenImports || apkg.brokenImports
+		)
+		diags = append(diags, bpkg.diags...)
+		diags = append(diags, apkg.diags...)
+
+		// pair removed and added declarations which were renamed
+		var removed, added []string
+		for id := range bpkg.decls {
+			if _, ok := apkg.lookupDecl(id); !ok {
+				removed = append(removed, id)
+			}
+		}
+		for id := range apkg.decls {
+			if _, ok := bpkg.decls[id]; !ok {
+				added = append(added, id)
+			}
+		}
+		var (
+			renamed, guessed = renames(bpkg, apkg, removed, added)
+			renamedTo        = make(map[string]bool)
+		)
+		for _, aid := range renamed {
+			renamedTo[aid] = true
+		}
+
 		d := NewDeclChecker(bpkg.info, apkg.info)
 		for id, bDecl := range bpkg.decls {
-			aDecl, ok := apkg.decls[id]
+			if aid, ok := renamed[id]; ok {
+				aDecl := apkg.decls[aid]
+				var fixes []SuggestedFix
+				if !guessed[id] {
+					fixes = renameFixes(bpkg, apkg, id, aid, bDecl, aDecl)
+				}
+				c := Change{
+					Pkg: pkgName, ID: id, Change: Breaking, Msg: "declaration renamed", Detail: fmt.Sprintf("renamed %s → %s", id, aid), Pos: pos(apkg.fset, declPos(aDecl)),
+					Before: bDecl, After: aDecl, BeforePos: bpkg.position(declPos(bDecl)), AfterPos: apkg.position(declPos(aDecl)),
+					Fixes: fixes, BeforeFset: bpkg.fset, AfterFset: apkg.fset,
+				}
+				changes = append(changes, c)
+				continue
+			}
+			aDecl, ok := apkg.lookupDecl(id)
 			if !ok {
 				// in before, not in after, therefore it was removed
-				c := Change{Pkg: pkgName, ID: id, Change: Breaking, Msg: "declaration removed", Pos: pos(bpkg.fset, bDecl.End()), Before: bDecl}
+				c := Change{
+					Pkg: pkgName, ID: id, Change: Breaking, Msg: "declaration removed", Pos: pos(bpkg.fset, bDecl.End()),
+					Before: bDecl, BeforePos: bpkg.position(declPos(bDecl)), BeforeFset: bpkg.fset,
+					Fixes: suggestFixes(bpkg, apkg, id, bDecl, nil),
+				}
 				changes = append(changes, c)
 				continue
 			}
@@ -670,33 +1442,74 @@ func (c Checker) compareDecls() ([]Change, error) {
 			// in before and in after, check if there's a difference
 			change, err := d.Check(bDecl, aDecl)
 			if err != nil {
-				return nil, &diffError{pkg: pkgName, err: err, bdecl: bDecl, adecl: aDecl}
+				cerr := &CompareError{Pkg: pkgName, ID: id, Before: bDecl, After: aDecl, Err: err}
+				if !lowConfidence {
+					return nil, nil, cerr
+				}
+				diags = append(diags, Diagnostic{Pkg: pkgName, Rev: apkg.rev, Pos: apkg.position(declPos(aDecl)), Msg: cerr.Error(), Err: cerr})
+				continue
+			}
+
+			var detail string
+			if c.deps {
+				// A dependency's change is only reported if it's more severe
+				if dchange, ddetail := depChange(bpkg, apkg, bDecl, aDecl, checked); severity(dchange.Change) > severity(change.Change) {
+					change, detail = dchange, ddetail
+				}
 			}
 
-			if change.Change == None {
+			if change.Change == None && !c.unchanged {
 				continue
 			}
 
+			changePos := change.Pos
+			if !changePos.IsValid() {
+				changePos = declPos(aDecl)
+			}
+
+			var fixes []SuggestedFix
+			if change.Change == Breaking {
+				fixes = suggestFixes(bpkg, apkg, id, bDecl, aDecl)
+			}
 			changes = append(changes, Change{
-				Pkg:    pkgName,
-				ID:     id,
-				Change: change.Change,
-				Msg:    change.Msg,
-				Pos:    pos(apkg.fset, change.Pos),
-				Before: bDecl,
-				After:  aDecl,
+				Pkg:        pkgName,
+				ID:         id,
+				Change:     change.Change,
+				Msg:        change.Msg,
+				Detail:     detail,
+				Pos:        pos(apkg.fset, change.Pos),
+				Before:     bDecl,
+				After:      aDecl,
+				BeforePos:  bpkg.position(declPos(bDecl)),
+				AfterPos:   apkg.position(changePos),
+				Fixes:      fixes,
+				BeforeFset: bpkg.fset,
+				AfterFset:  apkg.fset,
 			})
 		}
 
 		for id, aDecl := range apkg.decls {
-			if _, ok := bpkg.decls[id]; !ok {
+			if _, ok := bpkg.decls[id]; !ok && !renamedTo[id] {
 				// in after, not in before, therefore it was added
-				c := Change{Pkg: pkgName, ID: id, Change: NonBreaking, Msg: "declaration added", Pos: pos(apkg.fset, aDecl.End()), After: aDecl}
+				c := Change{
+					Pkg: pkgName, ID: id, Change: NonBreaking, Msg: "declaration added", Pos: pos(apkg.fset, aDecl.End()),
+					After: aDecl, AfterPos: apkg.position(declPos(aDecl)), AfterFset: apkg.fset,
+				}
 				changes = append(changes, c)
 			}
 		}
+
+		for i := pkgStart; i < len(changes); i++ {
+			changes[i].LowConfidence = lowConfidence
+		}
 	}
-	return changes, nil
+	for pkgName, apkg := range a {
+		if _, ok := b[pkgName]; !ok {
+			diags = append(diags, apkg.diags...)
+		}
+	}
+	changes = append(changes, packageChanges(b, a)...)
+	return moves(b, a, changes), diags, nil
 }
 
 // pos returns the declaration's position within a file.
@@ -704,3 +1517,165 @@ func pos(fset *token.FileSet, p token.Pos) string {
 	pos := fset.Position(p)
 	return fmt.Sprintf("%s:%d", pos.Filename, pos.Line)
 }
+
+// declObject returns the object declared by decl, or nil if it is not found.
+func declObject(info *types.Info, decl ast.Decl) types.Object {
+	var ident *ast.Ident
+	switch d := decl.(type) {
+	case *ast.GenDecl:
+		switch s := d.Specs[0].(type) {
+		case *ast.ValueSpec:
+			ident = s.Names[0]
+		case *ast.TypeSpec:
+			ident = s.Name
+		}
+	case *ast.FuncDecl:
+		ident = d.Name
+	}
+	if ident == nil {
+		return nil
+	}
+	return info.Defs[ident]
+}
+
+// declType returns the type of a declared object, or the underlying type if
+// obj declares a named type, so its fields and methods are followed.
+func declType(obj types.Object) types.Type {
+	if named, ok := obj.Type().(*types.Named); ok && named.Obj() == obj {
+		return named.Underlying()
+	}
+	return obj.Type()
+}
+
+// walkType calls visit for each named type reachable from t by a consumer of
+// the package. If visit returns true, the named type's exported and embedded
+// fields and the exported methods of it and its pointer are also walked.
+func walkType(t types.Type, visit func(*types.Named) bool) {
+	switch t := t.(type) {
+	case *types.Named:
+		args := t.TypeArgs()
+		for i := 0; i < args.Len(); i++ {
+			walkType(args.At(i), visit)
+		}
+		if !visit(t) {
+			return
+		}
+		walkType(t.Underlying(), visit)
+		if _, ok := t.Underlying().(*types.Interface); ok {
+			// methods were walked with the underlying interface
+			return
+		}
+		mset := types.NewMethodSet(types.NewPointer(t))
+		for i := 0; i < mset.Len(); i++ {
+			if mset.At(i).Obj().Exported() {
+				walkType(mset.At(i).Type(), visit)
+			}
+		}
+	case *types.Alias:
+		walkType(types.Unalias(t), visit)
+	case *types.Pointer:
+		walkType(t.Elem(), visit)
+	case *types.Slice:
+		walkType(t.Elem(), visit)
+	case *types.Array:
+		walkType(t.Elem(), visit)
+	case *types.Chan:
+		walkType(t.Elem(), visit)
+	case *types.Map:
+		walkType(t.Key(), visit)
+		walkType(t.Elem(), visit)
+	case *types.Signature:
+		walkType(t.Params(), visit)
+		walkType(t.Results(), visit)
+	case *types.Tuple:
+		for i := 0; i < t.Len(); i++ {
+			walkType(t.At(i).Type(), visit)
+		}
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			// embedded fields promote their exported fields and methods
+			if f := t.Field(i); f.Exported() || f.Embedded() && promotes(f.Type(), nil) {
+				walkType(f.Type(), visit)
+			}
+		}
+	case *types.Interface:
+		for i := 0; i < t.NumMethods(); i++ {
+			if t.Method(i).Exported() {
+				walkType(t.Method(i).Type(), visit)
+			}
+		}
+	}
+}
+
+// promotes returns true if t, the type of an embedded field, has exported
+// fields or methods to promote. seen holds the types already checked.
+func promotes(t types.Type, seen map[types.Type]bool) bool {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if seen[t] {
+		return false
+	}
+	if seen == nil {
+		seen = make(map[types.Type]bool)
+	}
+	seen[t] = true
+
+	mset := types.NewMethodSet(t)
+	if !types.IsInterface(t) {
+		mset = types.NewMethodSet(types.NewPointer(t))
+	}
+	for i := 0; i < mset.Len(); i++ {
+		if mset.At(i).Obj().Exported() {
+			return true
+		}
+	}
+	if s, ok := t.Underlying().(*types.Struct); ok {
+		for i := 0; i < s.NumFields(); i++ {
+			if f := s.Field(i); f.Exported() || f.Embedded() && promotes(f.Type(), seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// declPos returns the position of a declaration's first spec, declarations
+// split by pkgDecls do not have a valid position for their token.
+func declPos(decl ast.Decl) token.Pos {
+	if gen, ok := decl.(*ast.GenDecl); ok && len(gen.Specs) > 0 {
+		return gen.Specs[0].Pos()
+	}
+	return decl.Pos()
+}
+
+// lookupDecl returns the declaration with id, including methods of a type
+// declared as an alias of another type in the package, such as after renaming
+// the type.
+func (p pkg) lookupDecl(id string) (ast.Decl, bool) {
+	if decl, ok := p.decls[id]; ok {
+		return decl, ok
+	}
+	i := strings.IndexByte(id, '.')
+	if i < 0 {
+		return nil, false
+	}
+	spec, ok := typeSpec(p.decls[id[:i]])
+	if !ok || !spec.Assign.IsValid() {
+		return nil, false
+	}
+	aliased, ok := spec.Type.(*ast.Ident)
+	if !ok {
+		return nil, false
+	}
+	decl, ok := p.decls[aliased.Name+id[i:]]
+	return decl, ok
+}
+
+// position returns the position within the package's fileset, with the
+// revision prefix removed from the filename.
+func (p pkg) position(pos token.Pos) token.Position {
+	position := p.fset.Position(pos)
+	position.Filename = strings.TrimPrefix(position.Filename, p.rev+":")
+	return position
+}
@@ -2,13 +2,25 @@ package apicompat
 
 import (
 	"bytes"
+	"context"
+	"flag"
 	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"sync"
 	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
 )
 
 // TestParse tests the results from the parser against an expected golden master
@@ -31,7 +43,7 @@ func TestParse(t *testing.T) {
 	// Run checks
 	c := New(SetVCS(vcs))
 
-	changes, err := c.Check("", false, "rev1", "rev2")
+	changes, err := c.CheckPackages(context.Background(), CheckRequest{Before: "rev1", After: "rev2"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -43,7 +55,7 @@ func TestParse(t *testing.T) {
 	}
 
 	// Overwrite the gold master with go test -args update
-	if len(os.Args) > 1 && os.Args[1] == "update" {
+	if flag.Arg(0) == "update" {
 		err = ioutil.WriteFile("testdata/exp.txt", buf.Bytes(), os.FileMode(0644))
 		if err != nil {
 			t.Fatal("could not write exp data:", err)
@@ -63,6 +75,63 @@ func TestParse(t *testing.T) {
 	}
 }
 
+// TestPkgDecls checks unexported types are only included when a consumer
+// can reach them from an exported declaration.
+func TestPkgDecls(t *testing.T) {
+	const src = `package p
+
+type field struct{}
+type elem struct{}
+type result struct{}
+type embedded struct{ X int }
+type empty struct{ y int }
+type inferred struct{}
+type unused struct{}
+
+type T struct {
+	F field
+	M map[string][]elem
+	embedded
+	empty
+	hidden unused
+}
+
+func (field) Exported()   {}
+func (field) unexported() {}
+func (*result) Next() *result { return nil }
+func (unused) Exported()  {}
+
+func F(int) *T              { return nil }
+func (T) R() (result, error) { return result{}, nil }
+
+func newInferred() inferred { return inferred{} }
+
+var V = newInferred()
+`
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{Defs: make(map[*ast.Ident]types.Object)}
+	tpkg, err := (&types.Config{Importer: importer.Default()}).Check("p", fset, []*ast.File{file}, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for id := range pkgDecls([]*ast.File{file}, info, tpkg) {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	exp := []string{"F", "T", "T.R", "V", "elem", "embedded", "field", "field.Exported", "inferred", "result", "result.Next"}
+	if !reflect.DeepEqual(ids, exp) {
+		t.Errorf("exp %v got %v", exp, ids)
+	}
+}
+
 // TestPaths tests an example project with various paths and verifies
 // it finds a certain number of changes ensuring recursive is working
 // as expected
@@ -97,41 +166,188 @@ func TestPaths(t *testing.T) {
 		{"example.com/lib/main", "", 0},   // main package
 	}
 
-	oldPath := os.Getenv("GOPATH")
+	// Restore the working directory for subsequent tests
 	defer func() {
-		if err := os.Setenv("GOPATH", oldPath); err != nil {
-			t.Fatalf("cannot setenv in defer: %s", err)
+		if err := os.Chdir(wd); err != nil {
+			t.Fatalf("cannot chdir in defer: %s", err)
 		}
 	}()
-	if err := os.Setenv("GOPATH", filepath.Join(testdataDir, "gopath")); err != nil {
-		t.Fatalf("cannot setenv: %s", err)
-	}
 
-	for _, test := range tests {
-		t.Logf("Test: %#v", test)
-		err := os.Chdir(filepath.Join(testdataDir, "gopath", "src", test.wd))
-		if err != nil {
-			t.Errorf("Cannot chdir: %s", err)
+	// Each VCS is tested with loose objects, then again after packing them
+	vcss := []struct {
+		name string
+		new  func(path string) (VCS, error)
+	}{
+		{"git", func(path string) (VCS, error) { return NewGit(path) }},
+		{"native", func(path string) (VCS, error) { return NewNativeGit(path) }},
+	}
+	for _, packed := range []bool{false, true} {
+		gopath := filepath.Join(testdataDir, "gopath")
+		if packed {
+			// Pack a copy, leaving the shared testdata untouched
+			copied := filepath.Join(testenv.TempDir(t), "gopath")
+			testenv.CopyDir(t, copied, gopath)
+			testenv.Git(t, copied, "gc", "--quiet")
+			gopath = copied
 		}
+		testenv.Setenv(t, "GOPATH", gopath)
 
-		rel, rec, err := RelativePathToTarget(test.path)
-		if err != nil {
-			t.Fatalf("unexpected error from RelativePathToTarget: %v", err)
-		}
+		for _, test := range tests {
+			for _, v := range vcss {
+				t.Logf("Test: %#v vcs: %s packed: %v", test, v.name, packed)
+				err := os.Chdir(filepath.Join(gopath, "src", test.wd))
+				if err != nil {
+					t.Errorf("Cannot chdir: %s", err)
+				}
 
-		git, err := NewGit(rel)
-		if err != nil {
-			t.Errorf("Cannot get new git: %s", err)
+				rel, recurse, err := RelativePathToTarget(test.path)
+				if err != nil {
+					t.Fatalf("unexpected error from RelativePathToTarget: %v", err)
+				}
+
+				vcs, err := v.new(rel)
+				if err != nil {
+					t.Errorf("Cannot get new %s: %s", v.name, err)
+					continue
+				}
+				checker := New(SetVCS(vcs))
+
+				changes, err := checker.CheckPackages(context.Background(), CheckRequest{
+					Patterns: []string{test.path}, Before: "HEAD~1", After: "HEAD",
+				})
+				if err != nil {
+					t.Errorf("Check error: %s", err)
+				}
+				// the deprecated Check is the same as a single pattern
+				deprecated, err := checker.Check(rel, recurse, "HEAD~1", "HEAD")
+				if err != nil {
+					t.Errorf("deprecated Check error: %s", err)
+				}
+				if len(deprecated) != len(changes) {
+					t.Errorf("deprecated Check: exp %d got %d", len(changes), len(deprecated))
+				}
+				if err := vcs.(io.Closer).Close(); err != nil {
+					t.Errorf("Close error: %s", err)
+				}
+
+				if test.exp != len(changes) {
+					t.Errorf("exp %d got %d", test.exp, len(changes))
+				}
+			}
 		}
-		checker := New(SetVCS(git))
+	}
+}
+
+// TestCheckRequest checks overlapping patterns on multiple platforms, using
+// a single Checker concurrently.
+func TestCheckRequest(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "req")
+	testenv.GitCommit(t, repo, map[string]string{
+		"a/a.go":         "package a\n\nconst A int = 1\n",
+		"b/b_linux.go":   "package b\n\nconst L int = 1\n",
+		"b/b_windows.go": "package b\n\nconst W int = 1\n",
+	})
+	testenv.GitCommit(t, repo, map[string]string{
+		"a/a.go":       "package a\n\nconst A uint = 1\n",
+		"b/b_linux.go": "package b\n\nconst L uint = 1\n",
+	})
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(repo); err != nil {
+		t.Fatal(err)
+	}
 
-		changes, err := checker.Check(rel, rec, "HEAD~1", "HEAD")
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	checker := New(SetVCS(vcs))
+
+	req := CheckRequest{
+		Patterns:  []string{"example.com/req/a", "example.com/req/..."},
+		Before:    "HEAD~1",
+		After:     "HEAD",
+		Platforms: []string{"linux/amd64", "windows/amd64"},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			changes, err := checker.CheckPackages(context.Background(), req)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			var ids []string
+			for _, change := range changes {
+				ids = append(ids, change.ID)
+			}
+			if exp := []string{"A", "L"}; !reflect.DeepEqual(ids, exp) {
+				t.Errorf("exp %v got %v", exp, ids)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestTypeToAlias checks replacing a type with an alias of an identical type
+// is only non-breaking if the aliased type has the type's methods.
+func TestTypeToAlias(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		exp    []string // ID and message of each change
+	}{
+		{
+			name:   "identical",
+			before: "type T struct{ A int }\n\nfunc (T) M() {}\n\ntype U struct{ A int }\n\nfunc (U) M() {}\n",
+			after:  "type T = U\n\ntype U struct{ A int }\n\nfunc (U) M() {}\n",
+			exp:    []string{"T changed to an alias of an identical type"},
+		},
+		{
+			name:   "method lost",
+			before: "type T struct{ A int }\n\nfunc (T) M() {}\n\ntype U struct{ A int }\n",
+			after:  "type T = U\n\ntype U struct{ A int }\n",
+			exp:    []string{"T changed to an alias of a type missing methods", "T.M declaration removed"},
+		},
+		{
+			name:   "pointer method lost",
+			before: "type T struct{ A int }\n\nfunc (*T) M() {}\n\ntype U struct{ A int }\n\nfunc (U) N() {}\n",
+			after:  "type T = U\n\ntype U struct{ A int }\n\nfunc (U) N() {}\n",
+			exp:    []string{"T changed to an alias of a type missing methods", "T.M declaration removed"},
+		},
+		{
+			name:   "method changed",
+			before: "type T struct{ A int }\n\nfunc (T) M() {}\n\ntype U struct{ A int }\n\nfunc (U) M(int) {}\n",
+			after:  "type T = U\n\ntype U struct{ A int }\n\nfunc (U) M(int) {}\n",
+			exp:    []string{"T changed to an alias of a type missing methods", "T.M parameter types changed"},
+		},
+	}
+	for _, test := range tests {
+		var vcs StrVCS
+		vcs.SetFile("rev1", "p.go", []byte("package p\n\n"+test.before))
+		vcs.SetFile("rev2", "p.go", []byte("package p\n\n"+test.after))
+		changes, err := New(SetVCS(vcs)).CheckPackages(context.Background(), CheckRequest{Before: "rev1", After: "rev2"})
 		if err != nil {
-			t.Errorf("Check error: %s", err)
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
 		}
-
-		if test.exp != len(changes) {
-			t.Errorf("exp %d got %d", test.exp, len(changes))
+		var got []string
+		for _, change := range changes {
+			got = append(got, change.ID+" "+change.Msg)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("%s: exp changes %q got %q", test.name, test.exp, got)
 		}
 	}
 }
@@ -1,7 +1,6 @@
 package apicompat
 
 import (
-	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
@@ -10,7 +9,6 @@ import (
 	"reflect"
 	"sort"
 	"strconv"
-	"strings"
 )
 
 // The different declaration messages the package can generate.
@@ -92,6 +90,18 @@ func (c DeclChecker) Check(before, after ast.Decl) (DeclChange, error) {
 			// type struct/interface/aliased
 			aspec := a.Specs[0].(*ast.TypeSpec)
 
+			if !bspec.Assign.IsValid() && aspec.Assign.IsValid() {
+				// Replaced with an alias of an identical type, such as after
+				// renaming the type
+				bobj, aobj := c.binfo.ObjectOf(bspec.Name), c.ainfo.ObjectOf(aspec.Name)
+				if bobj != nil && aobj != nil && types.TypeString(bobj.Type().Underlying(), nil) == types.TypeString(aobj.Type().Underlying(), nil) {
+					if !hasMethods(aobj.Type(), bobj.Type()) {
+						return breaking("changed to an alias of a type missing methods", aspec.Pos()), nil
+					}
+					return nonBreaking("changed to an alias of an identical type", aspec.Pos()), nil
+				}
+			}
+
 			if reflect.TypeOf(bspec.Type) != reflect.TypeOf(aspec.Type) {
 				// Spec change, such as from StructType to InterfaceType or different aliased types
 				return breaking("changed type of value spec", aspec.Pos()), nil
@@ -152,10 +162,10 @@ func (c DeclChecker) checkInterface(before, after *ast.InterfaceType, allowRemov
 	// Resolving embedded interfaces to their signatures skips false positives
 	// when switching between an embedded type to their equivalent non embedded
 	// eg, from embedded Reader to Read(p []byte) (n int, err error)
-	if err := resolveInterface(c.binfo.Uses, before); err != nil {
+	if err := resolveInterface(c.binfo, before); err != nil {
 		return none(), err
 	}
-	if err := resolveInterface(c.ainfo.Uses, after); err != nil {
+	if err := resolveInterface(c.ainfo, after); err != nil {
 		return none(), err
 	}
 
@@ -179,25 +189,25 @@ func (c DeclChecker) checkInterface(before, after *ast.InterfaceType, allowRemov
 // resolveInterface resolves and rewrites an interfaces embedded members.
 // i.e. given an io.ReadCloser, it will return Read(p []byte) (int, error) and
 // Close() error
-func resolveInterface(uses map[*ast.Ident]types.Object, iface *ast.InterfaceType) error {
-	var rmi []int
-	for i, m := range iface.Methods.List {
+func resolveInterface(info *types.Info, iface *ast.InterfaceType) error {
+	var list []*ast.Field
+	for _, m := range iface.Methods.List {
 		if len(m.Names) > 0 {
+			list = append(list, m)
+			continue
+		}
+		if t := info.TypeOf(m.Type); t == nil || !types.IsInterface(t) {
+			// a type constraint's union or type term
+			list = append(list, m)
 			continue
 		}
-		newIface, err := exprInterfaceType(uses, m.Type)
+		methods, err := interfaceMethods(info, m.Type)
 		if err != nil {
 			return err
 		}
-		iface.Methods.List = append(iface.Methods.List, newIface.Methods.List...)
-		rmi = append(rmi, i)
+		list = append(list, methods...)
 	}
-
-	// After adding the signatures, remove the embedded interface
-	for i := len(rmi) - 1; i >= 0; i-- {
-		iface.Methods.List = append(iface.Methods.List[:i], iface.Methods.List[i+1:]...)
-	}
-
+	iface.Methods.List = list
 	return nil
 }
 
@@ -312,14 +322,16 @@ func (d *diffResult) RemoveInterfaceCompatible(chkr DeclChecker) (msg string, er
 		before, after := mod[0].Type, mod[1].Type
 		btype, atype := chkr.binfo.TypeOf(before), chkr.ainfo.TypeOf(after)
 		if btype != nil && atype != nil && types.IsInterface(btype) && types.IsInterface(atype) {
-			bint, err := exprInterfaceType(chkr.binfo.Uses, before)
+			bmethods, err := interfaceMethods(chkr.binfo, before)
 			if err != nil {
 				return msg, err
 			}
-			aint, err := exprInterfaceType(chkr.ainfo.Uses, after)
+			amethods, err := interfaceMethods(chkr.ainfo, after)
 			if err != nil {
 				return msg, err
 			}
+			bint := &ast.InterfaceType{Methods: &ast.FieldList{List: bmethods}}
+			aint := &ast.InterfaceType{Methods: &ast.FieldList{List: amethods}}
 
 			change, err := chkr.checkInterface(bint, aint, allowRemoval)
 			if err != nil {
@@ -392,9 +404,11 @@ func (c DeclChecker) diffFields(keyOn keyOn, before, after []*ast.Field) diffRes
 		r.removed = append(r.removed, bfield)
 	}
 
-	// What's left in afterMembers has added
-	for _, afield := range AfterMembers {
-		r.added = append(r.added, afield)
+	// What's left in afterMembers has added, in order of after
+	for i, afield := range after {
+		if _, ok := AfterMembers[fieldKey(keyOn, afield, i)]; ok {
+			r.added = append(r.added, afield)
+		}
 	}
 
 	return r
@@ -460,54 +474,74 @@ func (c DeclChecker) exprEqual(before, after ast.Expr) bool {
 	btype := c.binfo.TypeOf(before)
 	atype := c.ainfo.TypeOf(after)
 	if btype == nil || atype == nil {
-		// Maybe nil when using exprInterfaceType which converts ast to string
-		// and back to ast, without type checker knowing.
+		// Maybe nil when using interfaceMethods which converts types to
+		// strings and back to ast, without type checker knowing.
 		return types.ExprString(before) == types.ExprString(after)
 	}
 	return types.TypeString(btype, nil) == types.TypeString(atype, nil)
 }
 
-// exprInterfaceType returns a *ast.InterfaceType given an interface type using
-// the worst possible method. It's used to determine whether two interfaces
-// are compatible based on function parameters/results.
-func exprInterfaceType(uses map[*ast.Ident]types.Object, expr ast.Expr) (*ast.InterfaceType, error) {
-	var sel *ast.Ident
-	switch etype := expr.(type) {
-	case *ast.StarExpr:
-		switch estar := etype.X.(type) {
-		case *ast.SelectorExpr:
-			sel = estar.Sel
-		case *ast.Ident:
-			sel = estar
-		}
-	case *ast.SelectorExpr:
-		sel = etype.Sel
-	case *ast.Ident:
-		sel = etype
-	default:
-		return nil, errors.New("unknown interface type")
+// interfaceMethods returns the methods of the interface type of expr,
+// including those of embedded interfaces from any package, as fields parsed
+// from their signatures. It's used to determine whether two interfaces are
+// compatible based on function parameters/results.
+func interfaceMethods(info *types.Info, expr ast.Expr) ([]*ast.Field, error) {
+	t := info.TypeOf(expr)
+	if t == nil {
+		return nil, fmt.Errorf("could not find type of interface %s", types.ExprString(expr))
 	}
-
-	obj, ok := uses[sel]
+	iface, ok := t.Underlying().(*types.Interface)
 	if !ok {
-		return nil, errors.New("could not find interface in uses")
+		return nil, fmt.Errorf("%s is not an interface", t)
 	}
 
-	// obj is: *types.TypeName, string: type io.Writer interface{Write(p []byte) (n int, err error)}
+	// Qualify types by package name, as they would be written in source
+	self := infoPackage(info)
+	qualifier := func(pkg *types.Package) string {
+		if pkg == self {
+			return ""
+		}
+		return pkg.Name()
+	}
 
-	// Remove the package name from the source in order to parse valid program,
-	// this could be io (for io.Writer) or golang.org/x/net/context, if it's in
-	// universe scope, it's nil
-	src := obj.String()
-	if obj.Pkg() != nil {
-		src = strings.Replace(src, fmt.Sprintf("type %s.", obj.Pkg().Path()), "type ", 1)
+	var methods []*ast.Field
+	for i := 0; i < iface.NumMethods(); i++ {
+		m := iface.Method(i)
+		src := types.TypeString(m.Type(), qualifier)
+		ftype, err := parser.ParseExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s parsing: %s", err, src)
+		}
+		methods = append(methods, &ast.Field{Names: []*ast.Ident{ast.NewIdent(m.Name())}, Type: ftype})
 	}
-	src = fmt.Sprintf("package expr\n%s", src)
+	return methods, nil
+}
 
-	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, "", src, 0)
-	if err != nil {
-		return nil, fmt.Errorf("%s parsing: %s", err, src)
+// infoPackage returns the package whose declarations are in info, or nil.
+func infoPackage(info *types.Info) *types.Package {
+	for _, obj := range info.Defs {
+		if obj != nil && obj.Pkg() != nil {
+			return obj.Pkg()
+		}
+	}
+	return nil
+}
+
+// hasMethods returns true if the method sets of t and *t contain every
+// exported method of u and *u respectively, with the same signature.
+func hasMethods(t, u types.Type) bool {
+	for _, pair := range [][2]types.Type{{t, u}, {types.NewPointer(t), types.NewPointer(u)}} {
+		tset, uset := types.NewMethodSet(pair[0]), types.NewMethodSet(pair[1])
+		for i := 0; i < uset.Len(); i++ {
+			umethod := uset.At(i).Obj()
+			if !umethod.Exported() {
+				continue
+			}
+			sel := tset.Lookup(umethod.Pkg(), umethod.Name())
+			if sel == nil || types.TypeString(sel.Obj().Type(), nil) != types.TypeString(umethod.Type(), nil) {
+				return false
+			}
+		}
 	}
-	return file.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Type.(*ast.InterfaceType), nil
+	return true
 }
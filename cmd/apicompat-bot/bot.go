@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+// statusContext identifies the bot's commit statuses.
+const statusContext = "apicompat"
+
+// commentMarker identifies the bot's comment, which is updated by later
+// events for the same pull request.
+const commentMarker = "<!-- apicompat -->"
+
+// reportTimeout is the maximum duration to report a check's result to the
+// forge, which isn't limited by the check's timeout.
+const reportTimeout = 30 * time.Second
+
+var (
+	// validFullName matches a repository's full name, owner/name.
+	validFullName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*/[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+	// validSHA matches a full SHA-1 or SHA-256 object name.
+	validSHA = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+	// validRefChars matches the characters permitted in a branch name.
+	validRefChars = regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
+)
+
+// config configures a bot.
+type config struct {
+	forge    forge
+	secret   string        // webhook secret
+	insecure bool          // accept unsigned webhooks if secret is unset
+	gitURL   string        // https URL of the forge's git host, repositories are fetched from gitURL/owner/name.git
+	workdir  string        // GOPATH containing the clones
+	timeout  time.Duration // maximum duration to check a pull request
+}
+
+// pullRequestEvent is the subset of a GitHub or Gitea pull_request webhook
+// payload used by the bot.
+type pullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Base struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"base"`
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// bot is an http.Handler receiving pull request webhooks, checking each pull
+// request in the background.
+type bot struct {
+	config
+	wg sync.WaitGroup // running checks
+
+	mu    sync.Mutex
+	repos map[string]*sync.Mutex // clone directory -> held while the clone is used
+}
+
+// newBot returns a bot.
+func newBot(cfg config) *bot {
+	return &bot{config: cfg, repos: make(map[string]*sync.Mutex)}
+}
+
+// wait waits for running checks to finish.
+func (b *bot) wait() {
+	b.wg.Wait()
+}
+
+// ServeHTTP implements http.Handler.
+func (b *bot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 25<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !b.validSignature(r, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event == "" {
+		event = r.Header.Get("X-Gitea-Event")
+	}
+	if event != "pull_request" {
+		// Such as ping, acknowledge but ignore
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var pr pullRequestEvent
+	if err := json.Unmarshal(body, &pr); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	switch pr.Action {
+	case "opened", "reopened", "synchronize", "synchronized":
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := validEvent(pr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.process(pr)
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validEvent returns an error if pr is missing the pull request or
+// repository, or any of the names used to fetch and check it out are invalid.
+func validEvent(pr pullRequestEvent) error {
+	switch {
+	case pr.Number < 1:
+		return errors.New("payload missing pull request number")
+	case !validFullName.MatchString(pr.Repository.FullName) || strings.Contains(pr.Repository.FullName, ".."):
+		return fmt.Errorf("invalid repository full name %q", pr.Repository.FullName)
+	case !validBranch(pr.PullRequest.Base.Ref):
+		return fmt.Errorf("invalid base ref %q", pr.PullRequest.Base.Ref)
+	case !validSHA.MatchString(pr.PullRequest.Base.SHA):
+		return fmt.Errorf("invalid base sha %q", pr.PullRequest.Base.SHA)
+	case !validSHA.MatchString(pr.PullRequest.Head.SHA):
+		return fmt.Errorf("invalid head sha %q", pr.PullRequest.Head.SHA)
+	}
+	return nil
+}
+
+// validBranch returns true if name is a branch name which can be used in a
+// refspec, a subset of the names git check-ref-format --branch accepts.
+func validBranch(name string) bool {
+	if !validRefChars.MatchString(name) || strings.HasPrefix(name, "-") || strings.HasSuffix(name, ".lock") {
+		return false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == "" || strings.HasPrefix(elem, ".") || strings.HasSuffix(elem, ".") || strings.Contains(elem, "..") {
+			return false
+		}
+	}
+	return true
+}
+
+// validSignature returns true if the request's HMAC-SHA256 signature of body
+// matches the secret, or no secret is set and the bot is insecure. GitHub sends the signature in
+// X-Hub-Signature-256 prefixed with sha256=, Gitea in X-Gitea-Signature.
+func (b *bot) validSignature(r *http.Request, body []byte) bool {
+	if b.secret == "" {
+		return b.insecure
+	}
+	sig := strings.TrimPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if sig == "" {
+		sig = r.Header.Get("X-Gitea-Signature")
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(b.secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// process checks a pull request and reports the result to the forge. The
+// result is reported even if the check timed out.
+func (b *bot) process(pr pullRequestEvent) {
+	var (
+		repo = pr.Repository.FullName
+		sha  = pr.PullRequest.Head.SHA
+	)
+	if err := b.setStatus(repo, sha, status{State: statePending, Description: "Checking API compatibility", Context: statusContext}); err != nil {
+		log.Printf("%s#%d: could not set pending status: %v", repo, pr.Number, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	changes, err := b.check(ctx, pr)
+	cancel()
+	if err != nil {
+		log.Printf("%s#%d: check failed: %v", repo, pr.Number, err)
+		if err := b.setStatus(repo, sha, status{State: stateError, Description: "Could not check API compatibility", Context: statusContext}); err != nil {
+			log.Printf("%s#%d: could not set error status: %v", repo, pr.Number, err)
+		}
+		return
+	}
+
+	var breaking, nonBreaking []apicompat.Change
+	for _, change := range changes {
+		switch change.Change {
+		case apicompat.Breaking:
+			breaking = append(breaking, change)
+		case apicompat.NonBreaking:
+			nonBreaking = append(nonBreaking, change)
+		}
+	}
+
+	st := status{State: stateSuccess, Description: "No breaking API changes", Context: statusContext}
+	if len(breaking) > 0 {
+		st = status{State: stateFailure, Description: fmt.Sprintf("%d breaking API changes", len(breaking)), Context: statusContext}
+	}
+	if err := b.comment(repo, pr.Number, summary(breaking, nonBreaking)); err != nil {
+		log.Printf("%s#%d: could not comment: %v", repo, pr.Number, err)
+	}
+	if err := b.setStatus(repo, sha, st); err != nil {
+		log.Printf("%s#%d: could not set status: %v", repo, pr.Number, err)
+	}
+}
+
+// setStatus sets the status of a commit, within reportTimeout.
+func (b *bot) setStatus(repo, sha string, st status) error {
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+	return b.forge.SetStatus(ctx, repo, sha, st)
+}
+
+// comment updates the bot's existing comment on a pull request, or creates one
+// if there is none, within reportTimeout. Only comments by the authenticated
+// user are updated, so other users can't direct the bot to edit their comments.
+func (b *bot) comment(repo string, number int, body string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+	login, err := b.forge.User(ctx)
+	if err != nil {
+		return err
+	}
+	comments, err := b.forge.Comments(ctx, repo, number)
+	if err != nil {
+		return err
+	}
+	for i := len(comments) - 1; i >= 0; i-- {
+		c := comments[i]
+		if c.User.Login == login && strings.HasPrefix(c.Body, commentMarker) {
+			return b.forge.EditComment(ctx, repo, c.ID, body)
+		}
+	}
+	return b.forge.CreateComment(ctx, repo, number, body)
+}
+
+// check fetches the pull request into a local clone and checks it between
+// the merge base and head.
+func (b *bot) check(ctx context.Context, pr pullRequestEvent) ([]apicompat.Change, error) {
+	remote, importPath, err := repoURL(b.gitURL, pr.Repository.FullName)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(b.workdir, "src", filepath.FromSlash(importPath))
+
+	// Events for the same repository share a clone, so are checked one at a
+	// time
+	b.mu.Lock()
+	mu, ok := b.repos[dir]
+	if !ok {
+		mu = new(sync.Mutex)
+		b.repos[dir] = mu
+	}
+	b.mu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if _, err := git(ctx, dir, "init"); err != nil {
+			return nil, err
+		}
+	}
+	base := pr.PullRequest.Base
+	// Only permit https, even if the forge redirects or the repository has
+	// submodules
+	if _, err := git(ctx, dir, "-c", "protocol.allow=never", "-c", "protocol.https.allow=always",
+		"fetch", "--force", "--no-recurse-submodules", "--end-of-options", remote,
+		fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", base.Ref, base.Ref),
+		fmt.Sprintf("+refs/pull/%d/head:refs/remotes/pull/%d", pr.Number, pr.Number),
+	); err != nil {
+		return nil, err
+	}
+	head := pr.PullRequest.Head.SHA
+	mergeBase, err := git(ctx, dir, "merge-base", base.SHA, head)
+	if err != nil {
+		return nil, err
+	}
+	// Packages are found on the file system before being read at each
+	// revision, so check out head
+	if _, err := git(ctx, dir, "checkout", "--force", "--detach", head); err != nil {
+		return nil, err
+	}
+
+	vcs, err := apicompat.NewGit(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer vcs.Close()
+	bctx := build.Default
+	gopath := b.workdir
+	if bctx.GOPATH != "" {
+		gopath += string(filepath.ListSeparator) + bctx.GOPATH
+	}
+	bctx.GOPATH = gopath
+	checker := apicompat.New(apicompat.SetVCS(vcs), apicompat.SetBuildContext(bctx))
+	return checker.CheckPackages(ctx, apicompat.CheckRequest{
+		Patterns: []string{filepath.Join(dir, "...")},
+		Before:   mergeBase,
+		After:    head,
+	})
+}
+
+// git runs git with args in dir, returning its trimmed output.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("error running %v: %v output: %q", cmd.Args, err, out)
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// parseGitURL returns the https URL of a forge's git host, without a trailing
+// slash, or an error if it isn't an https URL.
+func parseGitURL(gitURL string) (string, error) {
+	u, err := url.Parse(gitURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("git URL %q must be an https URL without credentials, query or fragment", gitURL)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// gitURLFromAPI returns the git host of the forge whose REST API root is api,
+// github.com for api.github.com, otherwise the API's scheme and host.
+func gitURLFromAPI(api string) string {
+	u, err := url.Parse(api)
+	if err != nil {
+		return ""
+	}
+	if u.Host == "api.github.com" {
+		return "https://github.com"
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+}
+
+// repoURL returns the URL to fetch the repository fullName from gitURL, and
+// the import path of the repository's root, the git host's name and fullName.
+func repoURL(gitURL, fullName string) (remote, importPath string, err error) {
+	u, err := url.Parse(gitURL)
+	if err != nil {
+		return "", "", err
+	}
+	importPath = path.Join(u.Hostname(), fullName)
+	if path.Clean(importPath) != importPath || strings.Count(importPath, "/") != 2 {
+		return "", "", fmt.Errorf("invalid repository import path %q", importPath)
+	}
+	return gitURL + "/" + fullName + ".git", importPath, nil
+}
+
+// summary returns a markdown comment summarising the changes.
+func summary(breaking, nonBreaking []apicompat.Change) string {
+	var buf bytes.Buffer
+	if len(breaking) == 0 {
+		fmt.Fprintln(&buf, commentMarker)
+		fmt.Fprintln(&buf, "**apicompat** found no breaking API changes.")
+	} else {
+		fmt.Fprintln(&buf, commentMarker)
+		fmt.Fprintf(&buf, "**apicompat** found %d breaking API changes:\n\n", len(breaking))
+		for _, change := range breaking {
+			id := change.ID
+			if id == "" {
+				id = change.Pkg
+			} else {
+				id = change.Pkg + "." + id
+			}
+			fmt.Fprintf(&buf, "- `%s`: %s", id, change.Description())
+			if change.AfterPos.IsValid() {
+				fmt.Fprintf(&buf, " (%s)", change.AfterPos)
+			} else if change.BeforePos.IsValid() {
+				fmt.Fprintf(&buf, " (%s)", change.BeforePos)
+			}
+			fmt.Fprintln(&buf)
+		}
+	}
+	if len(nonBreaking) > 0 {
+		fmt.Fprintf(&buf, "\n%d non-breaking API changes were also found.\n", len(nonBreaking))
+	}
+	return buf.String()
+}
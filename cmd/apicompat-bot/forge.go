@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Commit status states, common to GitHub and Gitea.
+const (
+	statePending = "pending"
+	stateSuccess = "success"
+	stateFailure = "failure"
+	stateError   = "error"
+)
+
+// status is a commit status.
+type status struct {
+	State       string `json:"state"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+}
+
+// comment is a comment on a pull request.
+type comment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+// forge posts results to a code hosting service.
+type forge interface {
+	// User returns the login of the authenticated user.
+	User(ctx context.Context) (string, error)
+	// Comments returns the comments on pull request number in repo, repo is
+	// the repository's full name such as owner/name.
+	Comments(ctx context.Context, repo string, number int) ([]comment, error)
+	// CreateComment comments on pull request number in repo.
+	CreateComment(ctx context.Context, repo string, number int, body string) error
+	// EditComment replaces the body of the comment id in repo.
+	EditComment(ctx context.Context, repo string, id int64, body string) error
+	// SetStatus sets the status of commit sha in repo.
+	SetStatus(ctx context.Context, repo, sha string, st status) error
+}
+
+// restForge is a forge using the REST API shared by GitHub and Gitea.
+type restForge struct {
+	baseURL string // API root, such as https://api.github.com or https://gitea.example.com/api/v1
+	token   string
+	client  *http.Client
+}
+
+// newRESTForge returns a forge using the REST API at baseURL, authenticating
+// with token if set.
+func newRESTForge(baseURL, token string) *restForge {
+	return &restForge{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		client:  http.DefaultClient,
+	}
+}
+
+// User implements forge.
+func (f *restForge) User(ctx context.Context) (string, error) {
+	var user struct {
+		Login string `json:"login"`
+	}
+	if _, err := f.do(ctx, http.MethodGet, f.baseURL+"/user", nil, &user); err != nil {
+		return "", err
+	}
+	return user.Login, nil
+}
+
+// Comments implements forge, following the Link header's next page.
+func (f *restForge) Comments(ctx context.Context, repo string, number int) ([]comment, error) {
+	var (
+		comments []comment
+		next     = fmt.Sprintf("%s/repos/%s/issues/%d/comments?per_page=100", f.baseURL, repo, number)
+	)
+	for next != "" {
+		var page []comment
+		header, err := f.do(ctx, http.MethodGet, next, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, page...)
+		next = nextLink(header.Get("Link"))
+		if next != "" && !strings.HasPrefix(next, f.baseURL+"/") {
+			return nil, fmt.Errorf("next page %q is outside the API root", next)
+		}
+	}
+	return comments, nil
+}
+
+// CreateComment implements forge.
+func (f *restForge) CreateComment(ctx context.Context, repo string, number int, body string) error {
+	_, err := f.do(ctx, http.MethodPost, fmt.Sprintf("%s/repos/%s/issues/%d/comments", f.baseURL, repo, number), struct {
+		Body string `json:"body"`
+	}{body}, nil)
+	return err
+}
+
+// EditComment implements forge.
+func (f *restForge) EditComment(ctx context.Context, repo string, id int64, body string) error {
+	_, err := f.do(ctx, http.MethodPatch, fmt.Sprintf("%s/repos/%s/issues/comments/%d", f.baseURL, repo, id), struct {
+		Body string `json:"body"`
+	}{body}, nil)
+	return err
+}
+
+// SetStatus implements forge.
+func (f *restForge) SetStatus(ctx context.Context, repo, sha string, st status) error {
+	_, err := f.do(ctx, http.MethodPost, fmt.Sprintf("%s/repos/%s/statuses/%s", f.baseURL, repo, sha), st, nil)
+	return err
+}
+
+// do sends in, if not nil, as JSON to url and decodes the response into out,
+// if not nil, returning the response's header.
+func (f *restForge) do(ctx context.Context, method, url string, in, out interface{}) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if f.token != "" {
+		req.Header.Set("Authorization", "token "+f.token)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s %s: unexpected status %s: %s", method, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(out); err != nil {
+			return nil, fmt.Errorf("%s %s: could not decode response: %v", method, req.URL.Path, err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// nextLink returns the URL of the rel="next" link in a Link header, or an
+// empty string if there is none.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
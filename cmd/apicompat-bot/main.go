@@ -0,0 +1,54 @@
+// Command apicompat-bot receives GitHub or Gitea pull request webhooks, checks
+// each pull request for API changes between its merge base and head, and
+// reports the result as a comment and commit status.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "Address to listen on for webhooks")
+	api := flag.String("api", "https://api.github.com", "Forge REST API root, such as https://gitea.example.com/api/v1")
+	gitURL := flag.String("git", "", "https URL of the forge's git host to fetch repositories from (default derived from -api)")
+	secret := flag.String("secret", os.Getenv("APICOMPAT_WEBHOOK_SECRET"), "Webhook secret, defaults to $APICOMPAT_WEBHOOK_SECRET")
+	insecure := flag.Bool("insecure", false, "Accept unsigned webhooks if no secret is set")
+	token := flag.String("token", os.Getenv("APICOMPAT_TOKEN"), "Forge API token, defaults to $APICOMPAT_TOKEN")
+	workdir := flag.String("workdir", "", "GOPATH to clone repositories into, checked with $GOPATH (required)")
+	timeout := flag.Duration("timeout", 5*time.Minute, "Maximum duration to check a pull request, after which the check is abandoned")
+	flag.Parse()
+
+	if *workdir == "" {
+		log.Fatal("-workdir is required")
+	}
+	if *secret == "" && !*insecure {
+		log.Fatal("-secret is required, or -insecure to accept unsigned webhooks")
+	}
+	if *gitURL == "" {
+		*gitURL = gitURLFromAPI(*api)
+	}
+	git, err := parseGitURL(*gitURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	abs, err := filepath.Abs(*workdir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b := newBot(config{
+		forge:    newRESTForge(*api, *token),
+		secret:   *secret,
+		insecure: *insecure,
+		gitURL:   git,
+		workdir:  abs,
+		timeout:  *timeout,
+	})
+	log.Printf("listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, b))
+}
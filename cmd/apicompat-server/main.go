@@ -0,0 +1,44 @@
+// Command apicompat-server exposes apicompat as a JSON HTTP API, checking
+// local clones or uploaded repository tarballs in a queue of jobs.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "Address to listen on")
+	workers := flag.Int("workers", 2, "Maximum jobs to check concurrently")
+	queue := flag.Int("queue", 16, "Maximum jobs waiting to be checked, further jobs are rejected")
+	timeout := flag.Duration("timeout", 2*time.Minute, "Maximum duration of a job, after which the job is abandoned")
+	retain := flag.Duration("retain", time.Hour, "Duration to keep finished jobs' results")
+	repos := flag.String("repos", "", "Directory containing local clones which may be checked, unset disables local clones")
+	uploads := flag.String("uploads", "", "Directory to extract uploaded tarballs in, each into its own GOPATH (default a temporary directory)")
+	maxUpload := flag.Int64("max-upload", 100<<20, "Maximum size in bytes of an uploaded tarball, 0 disables uploads")
+	flag.Parse()
+
+	if *maxUpload > 0 && *uploads == "" {
+		dir, err := ioutil.TempDir("", "apicompat-server")
+		if err != nil {
+			log.Fatal(err)
+		}
+		*uploads = dir
+	}
+
+	srv := newServer(config{
+		workers:   *workers,
+		queue:     *queue,
+		timeout:   *timeout,
+		retain:    *retain,
+		repos:     *repos,
+		uploads:   *uploads,
+		maxUpload: *maxUpload,
+	})
+
+	log.Printf("listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, srv))
+}
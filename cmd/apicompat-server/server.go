@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+// Job statuses.
+const (
+	statusQueued  = "queued"
+	statusRunning = "running"
+	statusDone    = "done"
+	statusFailed  = "failed"
+)
+
+// config configures a server.
+type config struct {
+	workers   int           // maximum jobs to check concurrently
+	queue     int           // maximum jobs waiting to be checked
+	timeout   time.Duration // maximum duration of a job
+	retain    time.Duration // duration to keep finished jobs
+	repos     string        // directory containing local clones, empty disables local clones
+	uploads   string        // GOPATH uploaded tarballs are extracted into
+	maxUpload int64         // maximum tarball size, 0 disables uploads
+}
+
+// request is the body of a POST /jobs request, uploads set the same fields
+// with query parameters.
+type request struct {
+	Repo       string   `json:"repo,omitempty"`        // Repo is the path to a local clone, within the repos directory
+	ImportPath string   `json:"import_path,omitempty"` // ImportPath is the import path of an uploaded repository's root
+	Before     string   `json:"before,omitempty"`      // Before is the before revision, unset for the VCS default
+	After      string   `json:"after,omitempty"`       // After is the after revision, unset for the VCS default
+	Patterns   []string `json:"patterns,omitempty"`    // Patterns are relative to the repository, default ./...
+	Platforms  []string `json:"platforms,omitempty"`   // Platforms are GOOS/GOARCH pairs, default the server's platform
+	All        bool     `json:"all,omitempty"`         // All reports non-breaking changes as well as breaking
+	Deps       bool     `json:"deps,omitempty"`        // Deps compares dependency types reachable from the API
+	Lenient    bool     `json:"lenient,omitempty"`     // Lenient tolerates packages which fail to type check
+}
+
+// job is a queued request and, once finished, its result.
+type job struct {
+	ID          string       `json:"id"`
+	Status      string       `json:"status"`
+	Error       string       `json:"error,omitempty"`
+	Changes     []change     `json:"changes,omitempty"`
+	Diagnostics []diagnostic `json:"diagnostics,omitempty"`
+
+	req      request
+	upload   string    // path to the uploaded tarball, if any
+	finished time.Time // zero until the job is done or failed
+}
+
+// change is the JSON representation of an apicompat.Change.
+type change struct {
+	Pkg           string `json:"pkg"`
+	ID            string `json:"id,omitempty"`
+	Change        string `json:"change"`
+	Msg           string `json:"msg"`
+	Detail        string `json:"detail,omitempty"` // Detail is Msg with the names it omits, such as a renamed declaration's new name
+	Before        string `json:"before,omitempty"` // Before is the position in the before revision
+	After         string `json:"after,omitempty"`  // After is the position in the after revision
+	LowConfidence bool   `json:"low_confidence,omitempty"`
+}
+
+// diagnostic is the JSON representation of an apicompat.Diagnostic.
+type diagnostic struct {
+	Pkg string `json:"pkg"`
+	Rev string `json:"rev"`
+	Pos string `json:"pos,omitempty"`
+	Msg string `json:"msg"`
+}
+
+// server is an http.Handler queueing jobs to check repositories.
+type server struct {
+	config
+	ctx    context.Context // cancelled by close to stop running jobs
+	cancel context.CancelFunc
+	queue  chan *job
+	wg     sync.WaitGroup // running workers
+
+	mu     sync.Mutex
+	closed bool
+	jobs   map[string]*job
+}
+
+// newServer returns a server and starts its workers, close stops them.
+func newServer(cfg config) *server {
+	if cfg.workers < 1 {
+		cfg.workers = 1
+	}
+	s := &server{
+		config: cfg,
+		queue:  make(chan *job, cfg.queue),
+		jobs:   make(map[string]*job),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for i := 0; i < cfg.workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for j := range s.queue {
+				s.run(j)
+			}
+		}()
+	}
+	return s
+}
+
+// close cancels running jobs, fails queued jobs and waits for the workers to
+// stop.
+func (s *server) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cancel()
+	close(s.queue)
+	s.wg.Wait()
+}
+
+// ServeHTTP implements http.Handler.
+//
+//	POST /jobs      queue a job, responding 202 Accepted with the job
+//	GET  /jobs/{id} get a job's status and result
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/jobs" && r.Method == http.MethodPost:
+		s.submit(w, r)
+	case strings.HasPrefix(r.URL.Path, "/jobs/") && r.Method == http.MethodGet:
+		s.get(w, strings.TrimPrefix(r.URL.Path, "/jobs/"))
+	case r.URL.Path == "/jobs" || strings.HasPrefix(r.URL.Path, "/jobs/"):
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// submit queues a job for a local clone, described by a JSON body, or an
+// uploaded tarball, described by query parameters.
+func (s *server) submit(w http.ResponseWriter, r *http.Request) {
+	j := &job{Status: statusQueued}
+
+	var err error
+	switch ct := r.Header.Get("Content-Type"); {
+	case ct == "application/gzip" || ct == "application/x-gzip" || ct == "application/x-tar":
+		j.req, err = queryRequest(r)
+		if err == nil {
+			j.upload, err = s.saveUpload(w, r)
+		}
+	default:
+		err = json.NewDecoder(r.Body).Decode(&j.req)
+		if err == nil {
+			err = s.validateRepo(j.req.Repo)
+		}
+	}
+	if err == nil {
+		err = validatePatterns(j.req.Patterns)
+	}
+	if err == nil {
+		err = validateRevisions(j.req.Before, j.req.After)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		s.discard(j)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	j.ID = id
+
+	s.mu.Lock()
+	s.prune()
+	if s.closed {
+		s.mu.Unlock()
+		s.discard(j)
+		writeError(w, http.StatusServiceUnavailable, errors.New("server is shutting down"))
+		return
+	}
+	select {
+	case s.queue <- j:
+		s.jobs[j.ID] = j
+	default:
+		s.mu.Unlock()
+		s.discard(j)
+		writeError(w, http.StatusServiceUnavailable, errors.New("job queue is full"))
+		return
+	}
+	resp := *j
+	s.mu.Unlock()
+
+	w.Header().Set("Location", "/jobs/"+resp.ID)
+	writeJSON(w, http.StatusAccepted, resp)
+}
+
+// get writes the job with id.
+func (s *server) get(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	s.prune()
+	j, ok := s.jobs[id]
+	var resp job
+	if ok {
+		resp = *j
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %q not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// prune removes jobs finished longer than the retain duration ago. s.mu must
+// be held.
+func (s *server) prune() {
+	for id, j := range s.jobs {
+		if !j.finished.IsZero() && time.Since(j.finished) > s.retain {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// run checks a job, recording its result.
+func (s *server) run(j *job) {
+	s.mu.Lock()
+	j.Status = statusRunning
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	report, err := s.check(ctx, j)
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("job exceeded timeout of %v", s.timeout)
+	}
+	cancel()
+	s.discard(j)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.finished = time.Now()
+	if err != nil {
+		log.Printf("job %s failed: %v", j.ID, err)
+		j.Status, j.Error = statusFailed, err.Error()
+		return
+	}
+	j.Status = statusDone
+	for _, c := range report.Changes {
+		if c.Change != apicompat.Breaking && !j.req.All {
+			continue
+		}
+		jc := change{Pkg: c.Pkg, ID: c.ID, Change: c.Change, Msg: c.Msg, Detail: c.Detail, LowConfidence: c.LowConfidence}
+		if c.BeforePos.IsValid() {
+			jc.Before = c.BeforePos.String()
+		}
+		if c.AfterPos.IsValid() {
+			jc.After = c.AfterPos.String()
+		}
+		j.Changes = append(j.Changes, jc)
+	}
+	for _, d := range report.Diagnostics {
+		jd := diagnostic{Pkg: d.Pkg, Rev: d.Rev, Msg: d.Msg}
+		if d.Pos.IsValid() {
+			jd.Pos = d.Pos.String()
+		}
+		j.Diagnostics = append(j.Diagnostics, jd)
+	}
+}
+
+// check checks a job's repository, extracting it first if it was uploaded.
+func (s *server) check(ctx context.Context, j *job) (apicompat.Report, error) {
+	if err := ctx.Err(); err != nil {
+		return apicompat.Report{}, err
+	}
+
+	var (
+		dir     = j.req.Repo
+		options []func(*apicompat.Checker)
+	)
+	if j.upload != "" {
+		// Each upload is extracted into its own GOPATH, so uploads of the
+		// same or overlapping import paths don't share directories
+		gopath, err := ioutil.TempDir(s.uploads, "gopath")
+		if err != nil {
+			return apicompat.Report{}, err
+		}
+		defer os.RemoveAll(gopath)
+
+		dir = filepath.Join(gopath, "src", filepath.FromSlash(j.req.ImportPath))
+		if err := extractTar(j.upload, dir, 10*s.maxUpload); err != nil {
+			return apicompat.Report{}, fmt.Errorf("could not extract upload: %v", err)
+		}
+		if fi, err := os.Lstat(filepath.Join(dir, ".git")); err != nil || !fi.IsDir() {
+			return apicompat.Report{}, errors.New("upload must contain a .git directory")
+		}
+		bctx := build.Default
+		if bctx.GOPATH != "" {
+			gopath += string(filepath.ListSeparator) + bctx.GOPATH
+		}
+		bctx.GOPATH = gopath
+		options = append(options, apicompat.SetBuildContext(bctx))
+	}
+
+	var (
+		vcs apicompat.VCS
+		err error
+	)
+	if _, lerr := exec.LookPath("git"); lerr == nil && j.upload == "" {
+		if err := verifyRevisions(ctx, dir, j.req.Before, j.req.After); err != nil {
+			return apicompat.Report{}, err
+		}
+		vcs, err = apicompat.NewGit(dir)
+	} else {
+		// git runs commands configured by the repository, such as hooks and
+		// core.fsmonitor, so uploads are only read natively
+		vcs, err = apicompat.NewNativeGit(dir)
+	}
+	if err != nil {
+		return apicompat.Report{}, err
+	}
+	if closer, ok := vcs.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	req := apicompat.CheckRequest{
+		Patterns:  []string{filepath.Join(dir, "...")},
+		Before:    j.req.Before,
+		After:     j.req.After,
+		Platforms: j.req.Platforms,
+	}
+	if len(j.req.Patterns) > 0 {
+		req.Patterns = nil
+		for _, pattern := range j.req.Patterns {
+			req.Patterns = append(req.Patterns, filepath.Join(dir, filepath.FromSlash(pattern)))
+		}
+	}
+	options = append(options,
+		apicompat.SetVCS(vcs),
+		apicompat.SetCheckDependencies(j.req.Deps),
+		apicompat.SetLenient(j.req.Lenient),
+	)
+	checker := apicompat.New(options...)
+	return checker.CheckReport(ctx, req)
+}
+
+// discard removes a job's uploaded tarball, if any.
+func (s *server) discard(j *job) {
+	if j.upload != "" {
+		os.Remove(j.upload)
+	}
+}
+
+// validateRepo returns an error if repo is not a directory within the repos
+// directory.
+func (s *server) validateRepo(repo string) error {
+	if s.repos == "" {
+		return errors.New("local repositories are disabled, upload a tarball instead")
+	}
+	if repo == "" {
+		return errors.New("repo is required")
+	}
+	root, err := filepath.EvalSymlinks(s.repos)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.EvalSymlinks(repo)
+	if err != nil {
+		return fmt.Errorf("repo %q not found", repo)
+	}
+	if rel, err := filepath.Rel(root, dir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("repo %q is not within the repos directory", repo)
+	}
+	return nil
+}
+
+// saveUpload saves the request's body to a temporary file in the uploads
+// directory, returning its path.
+func (s *server) saveUpload(w http.ResponseWriter, r *http.Request) (string, error) {
+	if s.maxUpload <= 0 {
+		return "", errors.New("uploads are disabled")
+	}
+	f, err := ioutil.TempFile(s.uploads, "upload")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, http.MaxBytesReader(w, r.Body, s.maxUpload))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("could not save upload: %v", err)
+	}
+	return f.Name(), nil
+}
+
+// queryRequest returns the request described by an upload's query
+// parameters, patterns and platforms may be repeated.
+func queryRequest(r *http.Request) (request, error) {
+	q := r.URL.Query()
+	req := request{
+		ImportPath: q.Get("import_path"),
+		Before:     q.Get("before"),
+		After:      q.Get("after"),
+		Patterns:   q["pattern"],
+		Platforms:  q["platform"],
+	}
+	for name, v := range map[string]*bool{"all": &req.All, "deps": &req.Deps, "lenient": &req.Lenient} {
+		if q.Get(name) == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(q.Get(name))
+		if err != nil {
+			return req, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		*v = b
+	}
+
+	// The import path must have at least one element, as it's extracted
+	// into its directory
+	clean := path.Clean(req.ImportPath)
+	if req.ImportPath == "" || clean != req.ImportPath || path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return req, fmt.Errorf("invalid import_path %q", req.ImportPath)
+	}
+	return req, nil
+}
+
+// validatePatterns returns an error if a pattern is not within the
+// repository.
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		clean := path.Clean(pattern)
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("pattern %q must be relative to the repository", pattern)
+		}
+	}
+	return nil
+}
+
+// validateRevisions returns an error if a revision could be mistaken for an
+// option by git.
+func validateRevisions(revs ...string) error {
+	for _, rev := range revs {
+		if strings.HasPrefix(rev, "-") {
+			return fmt.Errorf("invalid revision %q", rev)
+		}
+	}
+	return nil
+}
+
+// verifyRevisions returns an error if a revision, other than the default or
+// the working tree, isn't a commit in the repository in dir.
+func verifyRevisions(ctx context.Context, dir string, revs ...string) error {
+	for _, rev := range revs {
+		if rev == "" || rev == "." {
+			continue
+		}
+		cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "--quiet", "--end-of-options", rev+"^{commit}")
+		cmd.Dir = dir
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("unknown revision %q", rev)
+		}
+	}
+	return nil
+}
+
+// newID returns a random job ID.
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writeJSON writes v as the response's body.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("could not write response: %v", err)
+	}
+}
+
+// writeError writes err as a JSON response.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
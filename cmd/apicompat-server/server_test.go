@@ -0,0 +1,236 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// tarGz returns a gzipped tarball of the files in dir.
+func tarGz(t *testing.T, dir string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestServer submits jobs for a local clone and an uploaded tarball, and
+// polls for their results.
+func TestServer(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+	uploads := filepath.Join(gopath, "uploads")
+	if err := os.Mkdir(uploads, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := filepath.Join(gopath, "src", "example.com", "srv")
+	testenv.GitCommit(t, repo, map[string]string{"p/p.go": "package p\n\nconst A = 1\n\nconst B = 1\n"})
+	testenv.GitCommit(t, repo, map[string]string{"p/p.go": "package p\n\nconst B = 1\n\nconst C = 1\n"})
+	upload := tarGz(t, repo)
+
+	// An upload whose git configuration runs a command, which must not be run
+	marker := filepath.Join(gopath, "fsmonitor-ran")
+	hostile := filepath.Join(testenv.TempDir(t), "hostile")
+	testenv.CopyDir(t, hostile, repo)
+	testenv.Git(t, hostile, "config", "core.fsmonitor", "touch "+marker)
+	testenv.WriteFiles(t, hostile, map[string]string{"p/p.go": "package p\n\nconst C = 1\n"})
+	hostileUpload := tarGz(t, hostile)
+
+	srv := newServer(config{
+		workers: 2, queue: 4, timeout: time.Minute, retain: time.Hour,
+		repos: filepath.Join(gopath, "src"), uploads: uploads, maxUpload: 1 << 20,
+	})
+	defer srv.close()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		url         string
+		contentType string
+		body        []byte
+		expCode     int
+		expIDs      string // IDs of the changes, comma separated
+		expErr      string // error of a failed job
+	}{
+		{
+			name:        "local",
+			url:         "/jobs",
+			contentType: "application/json",
+			body:        []byte(`{"repo": "` + repo + `", "before": "HEAD~1", "after": "HEAD", "patterns": ["./..."]}`),
+			expCode:     http.StatusAccepted,
+			expIDs:      "A",
+		},
+		{
+			name:        "upload all",
+			url:         "/jobs?import_path=example.com/up&before=HEAD~1&after=HEAD&all=true",
+			contentType: "application/gzip",
+			body:        upload,
+			expCode:     http.StatusAccepted,
+			expIDs:      "A,C",
+		},
+		{
+			name:        "upload overlapping import path",
+			url:         "/jobs?import_path=example.com/up/sub&before=HEAD~1&after=HEAD&all=true",
+			contentType: "application/gzip",
+			body:        upload,
+			expCode:     http.StatusAccepted,
+			expIDs:      "A,C",
+		},
+		{
+			name:        "upload with git configuration",
+			url:         "/jobs?import_path=example.com/hostile",
+			contentType: "application/gzip",
+			body:        hostileUpload,
+			expCode:     http.StatusAccepted,
+			expIDs:      "B",
+		},
+		{
+			name:        "outside repos",
+			url:         "/jobs",
+			contentType: "application/json",
+			body:        []byte(`{"repo": "` + uploads + `"}`),
+			expCode:     http.StatusBadRequest,
+		},
+		{
+			name:        "invalid import path",
+			url:         "/jobs?import_path=../up",
+			contentType: "application/gzip",
+			body:        upload,
+			expCode:     http.StatusBadRequest,
+		},
+		{
+			name:        "empty import path element",
+			url:         "/jobs?import_path=.",
+			contentType: "application/gzip",
+			body:        upload,
+			expCode:     http.StatusBadRequest,
+		},
+		{
+			name:        "upload option revision",
+			url:         "/jobs?import_path=example.com/up&before=--output=x",
+			contentType: "application/gzip",
+			body:        upload,
+			expCode:     http.StatusBadRequest,
+		},
+		{
+			name:        "local option revision",
+			url:         "/jobs",
+			contentType: "application/json",
+			body:        []byte(`{"repo": "` + repo + `", "after": "-p"}`),
+			expCode:     http.StatusBadRequest,
+		},
+		{
+			name:        "local unknown revision",
+			url:         "/jobs",
+			contentType: "application/json",
+			body:        []byte(`{"repo": "` + repo + `", "before": "missing", "after": "HEAD"}`),
+			expCode:     http.StatusAccepted,
+			expErr:      `unknown revision "missing"`,
+		},
+	}
+
+	for _, test := range tests {
+		resp, err := http.Post(ts.URL+test.url, test.contentType, bytes.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var j job
+		err = json.NewDecoder(resp.Body).Decode(&j)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: could not decode response: %v", test.name, err)
+		}
+		if resp.StatusCode != test.expCode {
+			t.Errorf("%s: exp status %d got %d", test.name, test.expCode, resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode != http.StatusAccepted {
+			continue
+		}
+
+		// Poll until the job's finished
+		deadline := time.Now().Add(time.Minute)
+		for (j.Status == statusQueued || j.Status == statusRunning) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			resp, err := http.Get(ts.URL + "/jobs/" + j.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = json.NewDecoder(resp.Body).Decode(&j)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("%s: could not decode job: %v", test.name, err)
+			}
+		}
+		if test.expErr != "" {
+			if j.Status != statusFailed || j.Error != test.expErr {
+				t.Errorf("%s: exp status %s error %q got %s error %q", test.name, statusFailed, test.expErr, j.Status, j.Error)
+			}
+			continue
+		}
+		if j.Status != statusDone {
+			t.Errorf("%s: exp status %s got %s error %q", test.name, statusDone, j.Status, j.Error)
+			continue
+		}
+
+		var ids []string
+		for _, c := range j.Changes {
+			ids = append(ids, c.ID)
+		}
+		if got := strings.Join(ids, ","); got != test.expIDs {
+			t.Errorf("%s: exp changes to %s got %s", test.name, test.expIDs, got)
+		}
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("exp the upload's git configuration not to be used")
+	}
+}
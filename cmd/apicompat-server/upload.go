@@ -0,0 +1,85 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// extractTar extracts the tarball, optionally gzipped, at name into dir. The
+// tarball's root must be the repository's root, including .git. Only
+// directories and regular files are extracted, and at most limit bytes.
+func extractTar(name, dir string, limit int64) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, err := r.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		clean := path.Clean(hdr.Name)
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("tarball contains path %q outside of its root", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(clean))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if limit -= hdr.Size; limit < 0 {
+				return fmt.Errorf("tarball exceeds extracted size limit")
+			}
+			if err := extractFile(tr, target, hdr.Size); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// extractFile writes size bytes from r to path, creating its parent
+// directories.
+func extractFile(r io.Reader, path string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(f, r, size)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+// changePosition returns the position to annotate for a change, which is the
+// after position, or the before position if the declaration was removed.
+func changePosition(change apicompat.Change) token.Position {
+	if change.AfterPos.IsValid() {
+		return change.AfterPos
+	}
+	return change.BeforePos
+}
+
+// changeTitle returns a short single line description of a change.
+func changeTitle(change apicompat.Change) string {
+	if change.ID == "" {
+		return fmt.Sprintf("%s: %s", change.Pkg, change.Description())
+	}
+	return fmt.Sprintf("%s.%s: %s", change.Pkg, change.ID, change.Description())
+}
+
+// writeGitHub writes the changes as GitHub Actions workflow commands, which
+// are shown as annotations on a pull request. Breaking changes are errors and
+// all other changes are notices.
+func writeGitHub(w io.Writer, changes []apicompat.Change) error {
+	for _, change := range changes {
+		command := "notice"
+		if change.Change == apicompat.Breaking {
+			command = "error"
+		}
+
+		var props []string
+		if pos := changePosition(change); pos.IsValid() {
+			props = append(props,
+				"file="+githubEscapeProperty(filepath.ToSlash(pos.Filename)),
+				fmt.Sprintf("line=%d", pos.Line),
+			)
+		}
+		props = append(props, "title="+githubEscapeProperty(changeTitle(change)))
+
+		msg := change.Change + " " + change.Description()
+		if change.ID != "" {
+			msg = change.ID + ": " + msg
+		}
+		if _, err := fmt.Fprintf(w, "::%s %s::%s\n", command, strings.Join(props, ","), githubEscapeData(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// githubEscapeData escapes the message of a workflow command.
+func githubEscapeData(s string) string {
+	s = strings.Replace(s, "%", "%25", -1)
+	s = strings.Replace(s, "\r", "%0D", -1)
+	return strings.Replace(s, "\n", "%0A", -1)
+}
+
+// githubEscapeProperty escapes a property value of a workflow command.
+func githubEscapeProperty(s string) string {
+	s = githubEscapeData(s)
+	s = strings.Replace(s, ":", "%3A", -1)
+	return strings.Replace(s, ",", "%2C", -1)
+}
+
+// codeQualityIssue is an issue in a GitLab Code Quality report.
+type codeQualityIssue struct {
+	Description string              `json:"description"`
+	CheckName   string              `json:"check_name"`
+	Fingerprint string              `json:"fingerprint"`
+	Severity    string              `json:"severity"`
+	Location    codeQualityLocation `json:"location"`
+}
+
+type codeQualityLocation struct {
+	Path  string           `json:"path"`
+	Lines codeQualityLines `json:"lines"`
+}
+
+type codeQualityLines struct {
+	Begin int `json:"begin"`
+}
+
+// writeGitLabCodeQuality writes the changes as a GitLab Code Quality report.
+// Breaking changes are major issues and all other changes are info.
+func writeGitLabCodeQuality(w io.Writer, changes []apicompat.Change) error {
+	issues := []codeQualityIssue{}
+	for _, change := range changes {
+		severity := "info"
+		if change.Change == apicompat.Breaking {
+			severity = "major"
+		}
+
+		// Packages that were removed have no position, so report them
+		// against the package itself
+		path, line := change.Pkg, 1
+		if pos := changePosition(change); pos.IsValid() {
+			path, line = filepath.ToSlash(pos.Filename), pos.Line
+		}
+
+		// Fingerprint must be stable between runs to track an issue
+		sum := sha1.Sum([]byte(change.Pkg + "\x00" + change.ID + "\x00" + change.Msg))
+
+		issues = append(issues, codeQualityIssue{
+			Description: changeTitle(change),
+			CheckName:   sarifRuleID(change.Msg),
+			Fingerprint: hex.EncodeToString(sum[:]),
+			Severity:    severity,
+			Location:    codeQualityLocation{Path: path, Lines: codeQualityLines{Begin: line}},
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(issues)
+}
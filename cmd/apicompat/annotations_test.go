@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// annotationChanges are changes whose messages and paths contain characters
+// which must be escaped by the annotation formats.
+var annotationChanges = []apicompat.Change{
+	{
+		Pkg: "example.com/lib", ID: "A", Change: apicompat.Breaking, Msg: "changed type from int to map[string]int, 100% breaking",
+		BeforePos: token.Position{Filename: "a:b,c.go", Line: 3},
+		AfterPos:  token.Position{Filename: "a:b,c.go", Line: 4},
+	},
+	{
+		Pkg: "example.com/lib", ID: "B", Change: apicompat.NonBreaking, Msg: "multi\r\nline: message",
+		AfterPos: token.Position{Filename: "dir/50%.go", Line: 7},
+	},
+	{
+		Pkg: "example.com/lib", ID: "C", Change: apicompat.Breaking, Msg: "declaration removed",
+		BeforePos: token.Position{Filename: "lib.go", Line: 5},
+	},
+	{Pkg: "example.com/lib/b", Change: apicompat.Breaking, Msg: "package removed"},
+}
+
+// golden compares got to the golden file testdata/name, or updates the file
+// if the -update flag is set.
+func golden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	exp, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, exp) {
+		t.Errorf("%s: exp:\n%s\ngot:\n%s", name, exp, got)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	tests := []struct {
+		golden string
+		write  func(io.Writer, []apicompat.Change) error
+	}{
+		{"github.golden", writeGitHub},
+		{"gitlab-codequality.golden", writeGitLabCodeQuality},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := test.write(&buf, annotationChanges); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.golden, err)
+		}
+		golden(t, test.golden, buf.Bytes())
+	}
+}
+
+func TestGitHubEscape(t *testing.T) {
+	tests := []struct {
+		in, data, property string
+	}{
+		{"plain", "plain", "plain"},
+		{"100%", "100%25", "100%25"},
+		{"a\r\nb", "a%0D%0Ab", "a%0D%0Ab"},
+		{"a:b,c", "a:b,c", "a%3Ab%2Cc"},
+		{"%0A", "%250A", "%250A"},
+	}
+	for _, test := range tests {
+		if got := githubEscapeData(test.in); got != test.data {
+			t.Errorf("githubEscapeData(%q): exp %q got %q", test.in, test.data, got)
+		}
+		if got := githubEscapeProperty(test.in); got != test.property {
+			t.Errorf("githubEscapeProperty(%q): exp %q got %q", test.in, test.property, got)
+		}
+	}
+}
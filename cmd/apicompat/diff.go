@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+// diffKind is the kind of a line in a diff.
+type diffKind int
+
+const (
+	diffEqual  diffKind = iota // line is in both before and after
+	diffDelete                 // line is only in before
+	diffInsert                 // line is only in after
+)
+
+// diffLine is a single line of a line based diff, the line numbers are zero
+// based indexes into the before and after lines, and are -1 when the line
+// is not present on that side.
+type diffLine struct {
+	kind   diffKind
+	bline  int
+	aline  int
+	before string
+	after  string
+}
+
+// splitLines splits s into lines, ignoring a trailing new line.
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffLines returns the line based diff between before and after, using the
+// longest common subsequence of lines. Declarations are small, so the
+// quadratic space isn't a concern.
+func diffLines(before, after []string) []diffLine {
+	// lcs[i][j] is the length of the longest common subsequence of
+	// before[i:] and after[j:]
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			switch {
+			case before[i] == after[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var (
+		diff []diffLine
+		i, j int
+	)
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			diff = append(diff, diffLine{kind: diffEqual, bline: i, aline: j, before: before[i], after: after[j]})
+			i++
+			j++
+		case j == len(after) || (i < len(before) && lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, diffLine{kind: diffDelete, bline: i, aline: -1, before: before[i]})
+			i++
+		default:
+			diff = append(diff, diffLine{kind: diffInsert, bline: -1, aline: j, after: after[j]})
+			j++
+		}
+	}
+	return diff
+}
+
+// ANSI escape sequences used to colour the unified diff.
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiCyan  = "\x1b[36m"
+)
+
+// unifiedDiff writes changes as a unified diff of the formatted before and
+// after declarations.
+type unifiedDiff outputOptions
+
+// write writes each change's description followed by the unified diff of its
+// declarations.
+func (u unifiedDiff) write(w io.Writer, changes []apicompat.Change) error {
+	var buf bytes.Buffer
+	for _, change := range changes {
+		header := fmt.Sprintf("%s: %s %s", change.Pos, change.Change, change.Description())
+		switch {
+		case change.Change == apicompat.Breaking:
+			u.line(&buf, ansiBold+ansiRed, header)
+		default:
+			u.line(&buf, ansiBold, header)
+		}
+
+		before, after := change.Source()
+		if before == "" && after == "" {
+			continue
+		}
+		u.line(&buf, ansiBold, "--- "+diffName("before", change.BeforePos))
+		u.line(&buf, ansiBold, "+++ "+diffName("after", change.AfterPos))
+
+		for _, hunk := range diffHunks(diffLines(splitLines(before), splitLines(after)), u.context) {
+			u.line(&buf, ansiCyan, hunk.header())
+			for _, line := range hunk.lines {
+				switch line.kind {
+				case diffDelete:
+					u.line(&buf, ansiRed, "-"+line.before)
+				case diffInsert:
+					u.line(&buf, ansiGreen, "+"+line.after)
+				default:
+					u.line(&buf, "", " "+line.before)
+				}
+			}
+		}
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// line writes a single line, coloured if enabled.
+func (u unifiedDiff) line(buf *bytes.Buffer, color, text string) {
+	if u.color && color != "" {
+		text = color + text + ansiReset
+	}
+	buf.WriteString(text + "\n")
+}
+
+// diffName returns the file name used in a diff's header.
+func diffName(side string, pos token.Position) string {
+	if !pos.IsValid() {
+		return "/dev/null"
+	}
+	return fmt.Sprintf("%s/%s:%d", side, filepath.ToSlash(pos.Filename), pos.Line)
+}
+
+// diffHunk is a group of changed lines with their surrounding context.
+type diffHunk struct {
+	bstart, astart int // zero based first line of the hunk
+	lines          []diffLine
+}
+
+// header returns the hunk's header, such as "@@ -1,3 +1,4 @@".
+func (h diffHunk) header() string {
+	var blines, alines int
+	for _, line := range h.lines {
+		if line.kind != diffInsert {
+			blines++
+		}
+		if line.kind != diffDelete {
+			alines++
+		}
+	}
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.bstart, blines), hunkRange(h.astart, alines))
+}
+
+// hunkRange returns the range of a hunk, where start is zero based.
+func hunkRange(start, lines int) string {
+	if lines == 0 {
+		// empty ranges refer to the line before
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, lines)
+}
+
+// diffHunks groups a diff into hunks, with context unchanged lines around
+// each change. Hunks with overlapping context are merged.
+func diffHunks(diff []diffLine, context int) []diffHunk {
+	if context < 0 {
+		context = 0
+	}
+	var (
+		hunks      []diffHunk
+		start, end = -1, -1 // range of diff lines in the current hunk
+	)
+	flush := func() {
+		if start < 0 {
+			return
+		}
+		h := diffHunk{lines: diff[start:end]}
+		// line numbers are counted from the lines before the hunk
+		for _, line := range diff[:start] {
+			if line.kind != diffInsert {
+				h.bstart++
+			}
+			if line.kind != diffDelete {
+				h.astart++
+			}
+		}
+		hunks = append(hunks, h)
+		start, end = -1, -1
+	}
+	for i, line := range diff {
+		if line.kind == diffEqual {
+			continue
+		}
+		if start >= 0 && i-context > end {
+			flush()
+		}
+		if start < 0 {
+			start = i - context
+			if start < 0 {
+				start = 0
+			}
+		}
+		end = i + context + 1
+		if end > len(diff) {
+			end = len(diff)
+		}
+	}
+	flush()
+	return hunks
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+func TestDiffHunks(t *testing.T) {
+	tests := []struct {
+		before, after string
+		context       int
+		exp           []string // hunk headers
+	}{
+		{"a\nb\nc", "a\nb\nc", 3, nil},
+		{"a\nb\nc", "a\nB\nc", 3, []string{"@@ -1,3 +1,3 @@"}},
+		{"a\nb\nc", "a\nB\nc", 0, []string{"@@ -2,1 +2,1 @@"}},
+		{"1\n2\n3\n4\n5\n6\n7", "0\n2\n3\n4\n5\n6\n8", 1, []string{"@@ -1,2 +1,2 @@", "@@ -6,2 +6,2 @@"}},
+		{"1\n2\n3\n4\n5\n6\n7", "0\n2\n3\n4\n5\n6\n8", 3, []string{"@@ -1,7 +1,7 @@"}},
+		{"", "a\nb", 3, []string{"@@ -0,0 +1,2 @@"}},
+		{"a\nb", "", 3, []string{"@@ -1,2 +0,0 @@"}},
+	}
+
+	for _, test := range tests {
+		hunks := diffHunks(diffLines(splitLines(test.before), splitLines(test.after)), test.context)
+		if len(hunks) != len(test.exp) {
+			t.Errorf("%q -> %q: exp %d hunks got %d", test.before, test.after, len(test.exp), len(hunks))
+			continue
+		}
+		for i, hunk := range hunks {
+			if hunk.header() != test.exp[i] {
+				t.Errorf("%q -> %q: exp hunk %d header %q got %q", test.before, test.after, i, test.exp[i], hunk.header())
+			}
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	changes := []apicompat.Change{{
+		Pos: "rev:a.go:1", Change: apicompat.Breaking, Msg: "declaration removed",
+		BeforePos: token.Position{Filename: "a.go", Line: 1},
+	}}
+
+	var buf bytes.Buffer
+	if err := writeText(&buf, changes, outputOptions{context: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Before is nil, so only the description is written
+	exp := "rev:a.go:1: breaking change declaration removed\n"
+	if buf.String() != exp {
+		t.Errorf("exp %q got %q", exp, buf.String())
+	}
+}
+
+func TestTextDiff(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", "package p\n\ntype T struct {\n\tA int\n\tB int\n}\n\ntype T struct {\n\tA int\n\tB string\n}\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := []apicompat.Change{{
+		Pos: "rev:a.go:3", Change: apicompat.Breaking, Msg: "members changed types",
+		Before: file.Decls[0], After: file.Decls[1], BeforeFset: fset, AfterFset: fset,
+		BeforePos: token.Position{Filename: "a.go", Line: 3}, AfterPos: token.Position{Filename: "a.go", Line: 3},
+	}}
+
+	tests := []struct {
+		opts outputOptions
+		exp  string
+	}{
+		{
+			outputOptions{context: 1},
+			"rev:a.go:3: breaking change members changed types\n" +
+				"--- before/a.go:3\n" +
+				"+++ after/a.go:3\n" +
+				"@@ -2,3 +2,3 @@\n" +
+				" \tA int\n" +
+				"-\tB int\n" +
+				"+\tB string\n" +
+				" }\n",
+		},
+		{
+			outputOptions{context: 0, color: true},
+			"\x1b[1m\x1b[31mrev:a.go:3: breaking change members changed types\x1b[0m\n" +
+				"\x1b[1m--- before/a.go:3\x1b[0m\n" +
+				"\x1b[1m+++ after/a.go:3\x1b[0m\n" +
+				"\x1b[36m@@ -3,1 +3,1 @@\x1b[0m\n" +
+				"\x1b[31m-\tB int\x1b[0m\n" +
+				"\x1b[32m+\tB string\x1b[0m\n",
+		},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := formats["text"].write(&buf, changes, test.opts); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.exp {
+			t.Errorf("%+v: exp:\n%q\ngot:\n%q", test.opts, test.exp, buf.String())
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	gofmt "go/format"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+// applyFixes applies the first suggested fix of each change to the files in
+// the working tree, which must be the after revision, logging each to w.
+// Edited files are formatted if they're still valid Go.
+func applyFixes(w io.Writer, changes []apicompat.Change) error {
+	edits := make(map[string][]apicompat.TextEdit)
+	for _, change := range changes {
+		if len(change.Fixes) == 0 {
+			continue
+		}
+		fix := change.Fixes[0]
+		for _, edit := range fix.Edits {
+			edits[edit.Filename] = append(edits[edit.Filename], edit)
+		}
+		fmt.Fprintf(w, "%s: %s\n", change.Pkg, fix.Msg)
+	}
+
+	var files []string
+	for file := range edits {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		src, err = apicompat.ApplyEdits(src, edits[file])
+		if err != nil {
+			return err
+		}
+		if formatted, err := gofmt.Source(src); err == nil {
+			src = formatted
+		}
+		if err := ioutil.WriteFile(file, src, fi.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
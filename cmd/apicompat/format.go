@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+// outputOptions are the settings of the text format's unified diff.
+type outputOptions struct {
+	context int  // lines of context around changed lines
+	color   bool // colour the output with ANSI escape sequences
+}
+
+// format writes changes in a particular output format.
+type format struct {
+	write func(io.Writer, []apicompat.Change, outputOptions) error
+	// unchanged is true if the format reports every compared declaration,
+	// not just those that changed.
+	unchanged bool
+}
+
+// formats maps the -format flag's values to their format.
+var formats = map[string]format{
+	"text":               {write: writeText},
+	"sarif":              {write: withoutOptions(writeSARIF)},
+	"github":             {write: withoutOptions(writeGitHub)},
+	"gitlab-codequality": {write: withoutOptions(writeGitLabCodeQuality)},
+	"junit":              {write: withoutOptions(writeJUnit), unchanged: true},
+	"html":               {write: withoutOptions(writeHTML)},
+}
+
+// withoutOptions adapts a format which has no options.
+func withoutOptions(write func(io.Writer, []apicompat.Change) error) func(io.Writer, []apicompat.Change, outputOptions) error {
+	return func(w io.Writer, changes []apicompat.Change, _ outputOptions) error {
+		return write(w, changes)
+	}
+}
+
+// formatNames returns a sorted, comma separated list of supported formats.
+func formatNames() string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// writeText writes each change's description followed by a unified diff of
+// its formatted declarations.
+func writeText(w io.Writer, changes []apicompat.Change, opts outputOptions) error {
+	return unifiedDiff(opts).write(w, changes)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+// htmlPackage is a package's section in the HTML report.
+type htmlPackage struct {
+	Name        string
+	Anchor      string
+	Breaking    int
+	NonBreaking int
+	Changes     []htmlChange
+}
+
+// htmlChange is a single change in the HTML report.
+type htmlChange struct {
+	ID     string
+	Msg    string
+	Change string
+	Class  string // css class for the severity
+	Pos    string
+	Rows   []htmlRow
+}
+
+// htmlRow is a single row of the side-by-side diff, line numbers are 1 based
+// and 0 if the line isn't present on that side.
+type htmlRow struct {
+	BLine, ALine int
+	Before       template.HTML
+	After        template.HTML
+	Changed      bool
+}
+
+// writeHTML writes the changes as a self contained HTML report, with a table
+// of contents per package and a side-by-side diff of each declaration.
+func writeHTML(w io.Writer, changes []apicompat.Change) error {
+	pkgs := make(map[string]*htmlPackage)
+	var names []string
+	for _, change := range changes {
+		p, ok := pkgs[change.Pkg]
+		if !ok {
+			p = &htmlPackage{Name: change.Pkg, Anchor: htmlAnchor(change.Pkg)}
+			pkgs[change.Pkg] = p
+			names = append(names, change.Pkg)
+		}
+
+		hc := htmlChange{ID: change.ID, Msg: change.Description(), Change: change.Change, Pos: change.Pos}
+		switch change.Change {
+		case apicompat.Breaking:
+			hc.Class = "breaking"
+			p.Breaking++
+		case apicompat.NonBreaking:
+			hc.Class = "nonbreaking"
+			p.NonBreaking++
+		default:
+			hc.Class = "none"
+		}
+
+		before, after := change.Source()
+		hc.Rows = htmlRows(before, after)
+		p.Changes = append(p.Changes, hc)
+	}
+	sort.Strings(names)
+
+	var report []*htmlPackage
+	for _, name := range names {
+		report = append(report, pkgs[name])
+	}
+	return htmlReport.Execute(w, report)
+}
+
+// htmlAnchor returns an anchor name for an import path.
+func htmlAnchor(path string) string {
+	return "pkg-" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, path)
+}
+
+// htmlRows aligns the highlighted before and after source into rows of a
+// side-by-side diff. Consecutive removed and added lines are paired, and the
+// tokens which differ between a pair are highlighted.
+func htmlRows(before, after string) []htmlRow {
+	btoks, atoks := goTokens(before), goTokens(after)
+
+	var (
+		rows              []htmlRow
+		deleted, inserted []diffLine
+	)
+	flush := func() {
+		for i := 0; i < len(deleted) || i < len(inserted); i++ {
+			row := htmlRow{Changed: true}
+			var b, a []htmlToken
+			if i < len(deleted) {
+				b = btoks[deleted[i].bline]
+				row.BLine = deleted[i].bline + 1
+			}
+			if i < len(inserted) {
+				a = atoks[inserted[i].aline]
+				row.ALine = inserted[i].aline + 1
+			}
+			var bchanged, achanged []bool
+			if row.BLine > 0 && row.ALine > 0 {
+				bchanged, achanged = changedTokens(b, a)
+			}
+			if row.BLine > 0 {
+				row.Before = renderTokens(b, bchanged)
+			}
+			if row.ALine > 0 {
+				row.After = renderTokens(a, achanged)
+			}
+			rows = append(rows, row)
+		}
+		deleted, inserted = nil, nil
+	}
+	for _, line := range diffLines(splitLines(before), splitLines(after)) {
+		switch line.kind {
+		case diffDelete:
+			deleted = append(deleted, line)
+		case diffInsert:
+			inserted = append(inserted, line)
+		default:
+			flush()
+			rows = append(rows, htmlRow{
+				BLine: line.bline + 1, Before: renderTokens(btoks[line.bline], nil),
+				ALine: line.aline + 1, After: renderTokens(atoks[line.aline], nil),
+			})
+		}
+	}
+	flush()
+	return rows
+}
+
+// htmlToken is a token of Go source, or the text between tokens, and the css
+// class highlighting it.
+type htmlToken struct {
+	text  string
+	class string
+}
+
+// space returns true if the token is only white space.
+func (t htmlToken) space() bool {
+	return strings.TrimSpace(t.text) == ""
+}
+
+// changedTokens returns which of the tokens of a removed line b and an added
+// line a differ, using the longest common subsequence of the tokens which
+// aren't white space, as alignment may change without changing the source.
+func changedTokens(b, a []htmlToken) (bchanged, achanged []bool) {
+	bchanged, achanged = make([]bool, len(b)), make([]bool, len(a))
+	var (
+		btexts, atexts []string
+		bidx, aidx     []int // index of each text in b and a
+	)
+	for i, tok := range b {
+		if !tok.space() {
+			btexts, bidx = append(btexts, tok.text), append(bidx, i)
+		}
+	}
+	for i, tok := range a {
+		if !tok.space() {
+			atexts, aidx = append(atexts, tok.text), append(aidx, i)
+		}
+	}
+	for _, line := range diffLines(btexts, atexts) {
+		switch line.kind {
+		case diffDelete:
+			bchanged[bidx[line.bline]] = true
+		case diffInsert:
+			achanged[aidx[line.aline]] = true
+		}
+	}
+	return bchanged, achanged
+}
+
+// renderTokens returns the HTML of a line's tokens, wrapping each in a span if
+// it has a class, and in a span classed chg if it's changed. changed may be
+// nil if no tokens changed.
+func renderTokens(toks []htmlToken, changed []bool) template.HTML {
+	var buf bytes.Buffer
+	for i, tok := range toks {
+		class := tok.class
+		if i < len(changed) && changed[i] {
+			class = strings.TrimSpace(class + " chg")
+		}
+		if class != "" {
+			buf.WriteString(`<span class="` + class + `">`)
+		}
+		template.HTMLEscape(&buf, []byte(tok.text))
+		if class != "" {
+			buf.WriteString("</span>")
+		}
+	}
+	return template.HTML(buf.String())
+}
+
+// goTokens returns the tokens of each line of Go source src, classed by the
+// kind of token, tokens spanning multiple lines are split into each line.
+func goTokens(src string) [][]htmlToken {
+	var (
+		lines [][]htmlToken
+		line  []htmlToken
+	)
+	// emit adds text to the current line, splitting lines on new lines.
+	emit := func(text, class string) {
+		for i, part := range strings.Split(text, "\n") {
+			if i > 0 {
+				lines = append(lines, line)
+				line = nil
+			}
+			if part != "" {
+				line = append(line, htmlToken{text: part, class: class})
+			}
+		}
+	}
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, []byte(src), nil, scanner.ScanComments)
+
+	offset := 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON && lit == "\n" {
+			// automatically inserted semicolon
+			continue
+		}
+		text := lit
+		if text == "" {
+			text = tok.String()
+		}
+		start := file.Offset(pos)
+		if start < offset || start+len(text) > len(src) {
+			continue
+		}
+
+		var class string
+		switch {
+		case tok.IsKeyword():
+			class = "kw"
+		case tok == token.STRING || tok == token.CHAR:
+			class = "str"
+		case tok == token.INT || tok == token.FLOAT || tok == token.IMAG:
+			class = "num"
+		case tok == token.COMMENT:
+			class = "com"
+		case tok == token.IDENT:
+			if _, ok := types.Universe.Lookup(lit).(*types.TypeName); ok {
+				class = "typ"
+			}
+		}
+		emit(src[offset:start], "")
+		emit(src[start:start+len(text)], class)
+		offset = start + len(text)
+	}
+	emit(strings.TrimSuffix(src[offset:], "\n"), "")
+	if len(line) > 0 || src != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>apicompat report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1, h2 { font-weight: normal; }
+.change { margin: 1em 0 2em 0; border-left: 4px solid #aaa; padding-left: 1em; }
+.change.breaking { border-color: #c62828; }
+.change.nonbreaking { border-color: #2e7d32; }
+.severity { font-weight: bold; }
+.breaking .severity, .count.breaking { color: #c62828; }
+.nonbreaking .severity, .count.nonbreaking { color: #2e7d32; }
+.pos { color: #777; font-size: small; }
+table.diff { border-collapse: collapse; width: 100%; table-layout: fixed; font-family: monospace; font-size: 13px; }
+table.diff td { padding: 0 0.5em; white-space: pre; vertical-align: top; overflow: hidden; }
+table.diff td.num { width: 3em; color: #999; text-align: right; }
+table.diff tr.changed td.before { background: #ffebee; }
+table.diff tr.changed td.after { background: #e8f5e9; }
+table.diff td.after { border-left: 1px solid #ddd; }
+table.diff td.before .chg { background: #ffcdd2; }
+table.diff td.after .chg { background: #c8e6c9; }
+.kw { color: #0d47a1; font-weight: bold; }
+.typ { color: #00796b; }
+.str { color: #b71c1c; }
+.num { color: #6a1b9a; }
+.com { color: #777; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>apicompat report</h1>
+{{- if not .}}
+<p>No changes detected.</p>
+{{- else}}
+<h2>Packages</h2>
+<ul>
+{{- range .}}
+<li><a href="#{{.Anchor}}">{{.Name}}</a>
+<span class="count breaking">{{.Breaking}} breaking</span>,
+<span class="count nonbreaking">{{.NonBreaking}} non-breaking</span></li>
+{{- end}}
+</ul>
+{{- range .}}
+<h2 id="{{.Anchor}}">{{.Name}}</h2>
+{{- range .Changes}}
+<div class="change {{.Class}}">
+<p><code>{{if .ID}}{{.ID}}{{else}}package{{end}}</code>: <span class="severity">{{.Change}}</span> {{.Msg}} <span class="pos">{{.Pos}}</span></p>
+<table class="diff">
+{{- range .Rows}}
+<tr{{if .Changed}} class="changed"{{end}}><td class="num">{{if .BLine}}{{.BLine}}{{end}}</td><td class="before">{{.Before}}</td><td class="num">{{if .ALine}}{{.ALine}}{{end}}</td><td class="after">{{.After}}</td></tr>
+{{- end}}
+</table>
+</div>
+{{- end}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"html/template"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+func TestGoTokens(t *testing.T) {
+	src := "type T struct {\n\t/* a\n\tb */ A int `json:\"a\"`\n}\n"
+	var got [][]htmlToken
+	for _, line := range goTokens(src) {
+		var toks []htmlToken
+		for _, tok := range line {
+			if !tok.space() {
+				toks = append(toks, tok)
+			}
+		}
+		got = append(got, toks)
+	}
+	exp := [][]htmlToken{
+		{{"type", "kw"}, {"T", ""}, {"struct", "kw"}, {"{", ""}},
+		{{"/* a", "com"}},
+		{{"\tb */", "com"}, {"A", ""}, {"int", "typ"}, {"`json:\"a\"`", "str"}},
+		{{"}", ""}},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("exp tokens:\n%v\ngot:\n%v", exp, got)
+	}
+}
+
+func TestHTMLRows(t *testing.T) {
+	before := "type T struct {\n\tA int\n\tB string\n}"
+	after := "type T struct {\n\tA    uint\n\tBeta string\n\tC    bool\n}"
+
+	type row struct {
+		bline, aline  int
+		before, after template.HTML
+		changed       bool
+	}
+	var got []row
+	for _, r := range htmlRows(before, after) {
+		got = append(got, row{r.BLine, r.ALine, r.Before, r.After, r.Changed})
+	}
+	exp := []row{
+		{1, 1, `<span class="kw">type</span> T <span class="kw">struct</span> {`, `<span class="kw">type</span> T <span class="kw">struct</span> {`, false},
+		// only the changed tokens are highlighted, not the realigned space
+		{2, 2, `	A <span class="typ chg">int</span>`, `	A    <span class="typ chg">uint</span>`, true},
+		{3, 3, `	<span class="chg">B</span> <span class="typ">string</span>`, `	<span class="chg">Beta</span> <span class="typ">string</span>`, true},
+		// added lines aren't paired, so have no changed tokens
+		{0, 4, "", `	C    <span class="typ">bool</span>`, true},
+		{4, 5, "}", "}", false},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("exp rows:\n%v\ngot:\n%v", exp, got)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	fset := token.NewFileSet()
+	decl := func(src string) ast.Decl {
+		file, err := parser.ParseFile(fset, "", "package p\n"+src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file.Decls[0]
+	}
+	changes := []apicompat.Change{
+		{
+			Pkg: "example.com/b", ID: "F", Change: apicompat.Breaking, Msg: "parameter types changed", Pos: "b.go:3",
+			Before: decl("func F(a int)"), After: decl("func F(a <-chan int)"), BeforeFset: fset, AfterFset: fset,
+		},
+		{
+			Pkg: "example.com/a", ID: "V", Change: apicompat.NonBreaking, Msg: "declaration added", Pos: "a.go:1",
+			After: decl("var V = 1"), AfterFset: fset,
+		},
+		{Pkg: "example.com/b", Change: apicompat.Breaking, Msg: "package removed"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeHTML(&buf, changes); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, exp := range []string{
+		// table of contents, sorted by package
+		`<li><a href="#pkg-example-com-a">example.com/a</a>`,
+		`<span class="count breaking">2 breaking</span>`,
+		`<h2 id="pkg-example-com-b">example.com/b</h2>`,
+		`<div class="change breaking">`,
+		`<div class="change nonbreaking">`,
+		// source is escaped
+		`<span class="chg">&lt;-</span>`,
+		`<code>package</code>: <span class="severity">breaking change</span> package removed`,
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("exp output to contain %q", exp)
+		}
+	}
+	if strings.Index(out, "example.com/a") > strings.Index(out, "example.com/b") {
+		t.Errorf("exp packages sorted by name")
+	}
+	if strings.Contains(out, "<-chan") {
+		t.Errorf("exp source to be escaped")
+	}
+
+	buf.Reset()
+	if err := writeHTML(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "No changes detected.") {
+		t.Errorf("exp no changes message got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+// impactMain runs the impact subcommand with args, returning the exit code.
+// Consumers which break are reported, exiting with exitCodeBreaking.
+func impactMain(args []string) int {
+	fs := flag.NewFlagSet("impact", flag.ExitOnError)
+	before := fs.String("before", "", "Compare revision before, leave unset for the VCS default")
+	after := fs.String("after", "", "Compare revision after, leave unset for the VCS default")
+	consumers := fs.String("consumers", "", "Comma separated directories of consumer packages, a directory ending in /... includes subdirectories (required)")
+	platforms := fs.String("platforms", "", "Comma separated GOOS/GOARCH pairs to check consumers on, such as linux/amd64,windows/amd64 (default current platform)")
+	vcsName := fs.String("vcs", "auto", "Version control system to use, one of: auto, git, native (git without the git binary)")
+	verbose := fs.Bool("v", false, "Enable verbose logging")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: apicompat impact -consumers dir[,dir/...] [flags] [packages]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *consumers == "" {
+		fs.Usage()
+		return exitCodeInternalError
+	}
+
+	// The VCS is found from the first package
+	rel, _, err := apicompat.RelativePathToTarget(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeInternalError
+	}
+	vcs, err := newVCS(*vcsName, rel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeInternalError
+	}
+
+	opts := []func(*apicompat.Checker){apicompat.SetVCS(vcs)}
+	if *verbose {
+		opts = append(opts, apicompat.SetVLog(os.Stdout))
+	}
+
+	// Stop any git processes on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	req := apicompat.CheckRequest{Patterns: fs.Args(), Before: *before, After: *after}
+	if *platforms != "" {
+		req.Platforms = strings.Split(*platforms, ",")
+	}
+	impacts, err := apicompat.New(opts...).Impact(ctx, req, strings.Split(*consumers, ","))
+	stop()
+	if cerr := closeVCS(vcs); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeInternalError
+	}
+
+	for _, impact := range impacts {
+		fmt.Println(impact)
+	}
+	if len(impacts) > 0 {
+		return exitCodeBreaking
+	}
+	return exitCodeNoError
+}
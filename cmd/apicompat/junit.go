@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut *junitOutput  `xml:"system-out,omitempty"`
+}
+
+type junitOutput struct {
+	Body string `xml:",cdata"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",cdata"`
+}
+
+// writeJUnit writes the changes as a JUnit XML report, each package is a test
+// suite and each compared declaration is a test case, which fails if the
+// declaration has a breaking change.
+func writeJUnit(w io.Writer, changes []apicompat.Change) error {
+	suites := make(map[string]*junitTestSuite)
+	var names []string
+	for _, change := range changes {
+		suite, ok := suites[change.Pkg]
+		if !ok {
+			suite = &junitTestSuite{Name: change.Pkg}
+			suites[change.Pkg] = suite
+			names = append(names, change.Pkg)
+		}
+
+		tc := junitTestCase{ClassName: change.Pkg, Name: change.ID}
+		if tc.Name == "" {
+			// package was added or removed
+			tc.Name = change.Pkg
+		}
+		switch change.Change {
+		case apicompat.Breaking:
+			tc.Failure = &junitFailure{Message: change.Description(), Type: change.Change, Body: change.String()}
+			suite.Failures++
+		case apicompat.None:
+			// passed without any output
+		default:
+			tc.SystemOut = &junitOutput{Body: change.String()}
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+	sort.Strings(names)
+
+	report := junitTestSuites{Name: "apicompat"}
+	for _, name := range names {
+		report.Tests += suites[name].Tests
+		report.Failures += suites[name].Failures
+		report.Suites = append(report.Suites, *suites[name])
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
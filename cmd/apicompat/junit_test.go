@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat"
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestJUnit checks a package with unchanged, breaking and non-breaking
+// declarations, as reported by SetReportUnchanged for the junit format, and
+// decodes the report.
+func TestJUnit(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	// the package's directory must exist, its files are read from the VCS
+	dir := filepath.Join(gopath, "src", "example.com", "junit")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var vcs apicompat.StrVCS
+	vcs.SetFile("rev1", "a.go", []byte("package junit\n\nfunc Same() {}\n\nfunc Removed() {}\n"))
+	vcs.SetFile("rev2", "a.go", []byte("package junit\n\nfunc Same() {}\n\nfunc Added() {}\n"))
+
+	checker := apicompat.New(apicompat.SetVCS(vcs), apicompat.SetReportUnchanged(formats["junit"].unchanged))
+	changes, err := checker.CheckPackages(context.Background(), apicompat.CheckRequest{Patterns: []string{dir}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeJUnit(&buf, changes); err != nil {
+		t.Fatal(err)
+	}
+	var report junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("cannot decode junit output: %v\n%s", err, buf.Bytes())
+	}
+
+	if report.Tests != 3 || report.Failures != 1 {
+		t.Errorf("exp 3 tests and 1 failure got %d tests and %d failures", report.Tests, report.Failures)
+	}
+	if len(report.Suites) != 1 {
+		t.Fatalf("exp 1 suite got %d", len(report.Suites))
+	}
+	suite := report.Suites[0]
+	if suite.Name != "example.com/junit" || suite.Tests != 3 || suite.Failures != 1 {
+		t.Errorf("unexpected suite %q with %d tests and %d failures", suite.Name, suite.Tests, suite.Failures)
+	}
+
+	cases := make(map[string]junitTestCase)
+	for _, tc := range suite.Cases {
+		if tc.ClassName != suite.Name {
+			t.Errorf("%s: exp classname %q got %q", tc.Name, suite.Name, tc.ClassName)
+		}
+		cases[tc.Name] = tc
+	}
+	if tc, ok := cases["Same"]; !ok || tc.Failure != nil || tc.SystemOut != nil {
+		t.Errorf("exp passing test case for unchanged Same got %+v", tc)
+	}
+	if tc, ok := cases["Removed"]; !ok || tc.Failure == nil || tc.Failure.Type != apicompat.Breaking || tc.Failure.Message != "declaration removed" {
+		t.Errorf("exp failure for removed Removed got %+v", tc)
+	}
+	if tc, ok := cases["Added"]; !ok || tc.Failure != nil || tc.SystemOut == nil {
+		t.Errorf("exp passing test case with output for added Added got %+v", tc)
+	}
+}
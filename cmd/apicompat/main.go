@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
 
 	"github.com/bradleyfalzon/apicompat"
 )
@@ -15,55 +20,195 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "impact" {
+		os.Exit(impactMain(os.Args[2:]))
+	}
+
 	// TODO print CLI arguments, note that it does support GOARCH, GOOS, GOPATH etc, ./... works too
 	before := flag.String("before", "", "Compare revision before, leave unset for the VCS default or . to bypass VCS and use filesystem version")
 	after := flag.String("after", "", "Compare revision after, leave unset for the VCS default or . to bypass VCS and use filesystem version")
 	excludeFile := flag.String("exclude-file", "", "Exclude files based on regexp pattern")
 	excludeDir := flag.String("exclude-dir", "", "Exclude directory based on regexp pattern")
+	excludeSegments := flag.String("exclude-segments", "testdata,vendor", "Comma separated directory names whose packages, and packages beneath them, aren't checked")
+	internalConsumers := flag.String("internal-consumers", "", "Comma separated import paths, or prefixes ending in /..., of packages such as sibling modules, whose importable internal packages are also checked")
 	allChanges := flag.Bool("all", false, "Show all changes, not just breaking")
 	verbose := flag.Bool("v", false, "Enable verbose logging")
+	vcsName := flag.String("vcs", "auto", "Version control system to use, one of: auto, git, native (git without the git binary)")
+	concurrency := flag.Int("concurrency", 0, "Maximum packages to parse concurrently, 0 uses GOMAXPROCS")
+	platforms := flag.String("platforms", "", "Comma separated GOOS/GOARCH pairs to check, such as linux/amd64,windows/amd64 (default current platform)")
+	deps := flag.Bool("deps", false, "Also compare dependency types reachable from the API, such as after upgrading go.mod")
+	lenient := flag.Bool("lenient", false, "Tolerate packages which fail to type check, reporting their changes as low confidence")
+	usage := flag.String("usage", "", "Comma separated directories of dependents, such as a module cache, to count references to each change in, breaking changes are sorted by usage")
+	fix := flag.Bool("fix", false, "Apply suggested fixes for breaking changes, such as restoring removed declarations as deprecated, to the working tree, requires the after revision to be the working tree, .")
+	format := flag.String("format", "text", "Output format, one of: "+formatNames())
+	diffContext := flag.Int("context", 3, "Lines of context in the text format's diffs")
+	color := flag.String("color", "auto", "Colour the text format's diffs, one of: auto, always, never")
 	flag.Parse()
-	path := flag.Arg(0)
-	rel, rec, err := apicompat.RelativePathToTarget(path)
+
+	opts := outputOptions{context: *diffContext}
+	switch *color {
+	case "auto":
+		opts.color = isTerminal(os.Stdout)
+	case "always":
+		opts.color = true
+	case "never":
+		opts.color = false
+	default:
+		fmt.Fprintf(os.Stderr, "unknown color %q, must be one of: auto, always, never\n", *color)
+		os.Exit(exitCodeInternalError)
+	}
+
+	outFormat, ok := formats[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q, must be one of: %s\n", *format, formatNames())
+		os.Exit(exitCodeInternalError)
+	}
+
+	// The VCS is found from the first package
+	rel, _, err := apicompat.RelativePathToTarget(flag.Arg(0))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(exitCodeInternalError)
 	}
 
-	// TODO make it auto discover
-	git, err := apicompat.NewGit(rel)
+	vcs, err := newVCS(*vcsName, rel)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(exitCodeInternalError)
 	}
+	if *fix {
+		// The default after revision is the working tree if it has changes
+		afterRev := *after
+		if afterRev == "" {
+			_, afterRev = vcs.DefaultRevision()
+		}
+		if afterRev != "." {
+			closeVCS(vcs)
+			fmt.Fprintf(os.Stderr, "-fix edits the working tree, so requires the after revision to be ., not %q\n", afterRev)
+			os.Exit(exitCodeInternalError)
+		}
+	}
 
-	args := []func(*apicompat.Checker){apicompat.SetVCS(git)}
+	args := []func(*apicompat.Checker){apicompat.SetVCS(vcs), apicompat.SetConcurrency(*concurrency)}
 	if *verbose {
 		args = append(args, apicompat.SetVLog(os.Stdout))
 	}
-	if *excludeFile != "" {
-		args = append(args, apicompat.SetExcludeFile(*excludeFile))
+	if outFormat.unchanged {
+		args = append(args, apicompat.SetReportUnchanged(true))
+	}
+	if *deps {
+		args = append(args, apicompat.SetCheckDependencies(true))
+	}
+	if *lenient {
+		args = append(args, apicompat.SetLenient(true))
 	}
-	if *excludeDir != "" {
-		args = append(args, apicompat.SetExcludeDir(*excludeDir))
+	if *usage != "" {
+		args = append(args, apicompat.SetUsageCorpus(strings.Split(*usage, ",")...))
+	}
+	args = append(args, apicompat.SetExcludeSegments(splitList(*excludeSegments)...))
+	if *internalConsumers != "" {
+		args = append(args, apicompat.SetInternalConsumers(splitList(*internalConsumers)...))
 	}
 
+	// Stop any git processes on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	req := apicompat.CheckRequest{
+		Patterns:    flag.Args(),
+		Before:      *before,
+		After:       *after,
+		ExcludeFile: *excludeFile,
+		ExcludeDir:  *excludeDir,
+	}
+	if *platforms != "" {
+		req.Platforms = strings.Split(*platforms, ",")
+	}
 	checker := apicompat.New(args...)
-	changes, err := checker.Check(rel, rec, *before, *after)
+	result, err := checker.CheckReport(ctx, req)
+	stop()
+	if cerr := closeVCS(vcs); cerr != nil && err == nil {
+		err = cerr
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(exitCodeInternalError)
 	}
+	for _, diag := range result.Diagnostics {
+		fmt.Fprintln(os.Stderr, diag)
+	}
 
-	exitCode := exitCodeNoError
-	for _, change := range changes {
+	var (
+		exitCode = exitCodeNoError
+		report   []apicompat.Change
+	)
+	for _, change := range result.Changes {
 		switch {
 		case change.Change == apicompat.Breaking:
 			exitCode = exitCodeBreaking
-			fmt.Print(change)
-		case *allChanges:
-			fmt.Print(change)
+			report = append(report, change)
+		case *allChanges, outFormat.unchanged:
+			report = append(report, change)
+		}
+	}
+
+	if *usage != "" {
+		apicompat.SortByUsage(report)
+	}
+
+	if err := outFormat.write(os.Stdout, report, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCodeInternalError)
+	}
+	if *fix {
+		if err := applyFixes(os.Stderr, report); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCodeInternalError)
 		}
 	}
 	os.Exit(exitCode)
 }
+
+// newVCS returns the VCS by name, auto uses git if the git binary is available
+// otherwise native.
+func newVCS(name, path string) (apicompat.VCS, error) {
+	if name == "auto" {
+		name = "native"
+		if _, err := exec.LookPath("git"); err == nil {
+			name = "git"
+		}
+	}
+	switch name {
+	case "git":
+		return apicompat.NewGit(path)
+	case "native":
+		return apicompat.NewNativeGit(path)
+	}
+	return nil, fmt.Errorf("unknown vcs %q, must be one of: auto, git, native", name)
+}
+
+// closeVCS closes vcs if it holds resources, such as git processes.
+func closeVCS(vcs apicompat.VCS) error {
+	if closer, ok := vcs.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// splitList returns the non-empty elements of the comma separated list s.
+func splitList(s string) []string {
+	var list []string
+	for _, elem := range strings.Split(s, ",") {
+		if elem != "" {
+			list = append(list, elem)
+		}
+	}
+	return list
+}
+
+// isTerminal returns true if f is a character device, such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
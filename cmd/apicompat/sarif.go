@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+// SARIF 2.1.0 subset, see https://docs.oasis-open.org/sarif/sarif/v2.1.0/
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://docs.oasis-open.org/sarif/sarif/v2.1.0/errata01/os/schemas/sarif-schema-2.1.0.json"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	RuleIndex        int             `json:"ruleIndex"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations,omitempty"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	ID               *int                   `json:"id,omitempty"`
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+	Message          *sarifMessage          `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+}
+
+// writeSARIF writes the changes as a SARIF 2.1.0 log, with one rule per kind
+// of change.
+func writeSARIF(w io.Writer, changes []apicompat.Change) error {
+	// Rules are the unique messages, sorted so the rule indexes are stable
+	ruleIndex := make(map[string]int)
+	var msgs []string
+	for _, change := range changes {
+		if _, ok := ruleIndex[change.Msg]; !ok {
+			ruleIndex[change.Msg] = 0
+			msgs = append(msgs, change.Msg)
+		}
+	}
+	sort.Strings(msgs)
+
+	driver := sarifDriver{
+		Name:           "apicompat",
+		InformationURI: "https://github.com/bradleyfalzon/apicompat",
+		Rules:          []sarifRule{},
+	}
+	for i, msg := range msgs {
+		ruleIndex[msg] = i
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:               sarifRuleID(msg),
+			ShortDescription: sarifMessage{Text: msg},
+		})
+	}
+
+	results := []sarifResult{}
+	for _, change := range changes {
+		result := sarifResult{
+			RuleID:    sarifRuleID(change.Msg),
+			RuleIndex: ruleIndex[change.Msg],
+			Level:     sarifLevel(change.Change),
+			Message:   sarifMessage{Text: fmt.Sprintf("%s: %s %s", change.ID, change.Change, change.Description())},
+		}
+
+		loc := sarifLocation{LogicalLocations: []sarifLogicalLocation{{FullyQualifiedName: change.Pkg}}}
+		if change.ID != "" {
+			loc.LogicalLocations[0].FullyQualifiedName += "." + change.ID
+		}
+		switch {
+		case change.AfterPos.IsValid():
+			loc.PhysicalLocation = sarifPhysical(change.AfterPos)
+		case change.BeforePos.IsValid():
+			// removed declarations only have a before position
+			loc.PhysicalLocation = sarifPhysical(change.BeforePos)
+		}
+		result.Locations = []sarifLocation{loc}
+
+		if change.AfterPos.IsValid() && change.BeforePos.IsValid() {
+			id := 1
+			result.RelatedLocations = []sarifLocation{{
+				ID:               &id,
+				PhysicalLocation: sarifPhysical(change.BeforePos),
+				Message:          &sarifMessage{Text: "declaration before the change"},
+			}}
+		}
+		results = append(results, result)
+	}
+
+	log := sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+// sarifRuleID converts a change's message, such as "members added", into a
+// rule ID, such as "members-added".
+func sarifRuleID(msg string) string {
+	return strings.Replace(msg, " ", "-", -1)
+}
+
+// sarifLevel maps a change's classification to a SARIF result level.
+func sarifLevel(change string) string {
+	switch change {
+	case apicompat.Breaking:
+		return "error"
+	case apicompat.NonBreaking:
+		return "note"
+	}
+	return "none"
+}
+
+// sarifPhysical returns the physical location of a position.
+func sarifPhysical(pos token.Position) *sarifPhysicalLocation {
+	return &sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(pos.Filename)},
+		Region:           sarifRegion{StartLine: pos.Line, StartColumn: pos.Column},
+	}
+}
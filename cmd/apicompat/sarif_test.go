@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat"
+)
+
+// TestSARIF validates the SARIF output against the vendored SARIF 2.1.0
+// schema testdata/sarif-schema-2.1.0.json.
+func TestSARIF(t *testing.T) {
+	changes := []apicompat.Change{
+		{
+			Pkg: "example.com/lib", ID: "A", Change: apicompat.Breaking, Msg: "changed type",
+			BeforePos: token.Position{Filename: "lib.go", Line: 3, Column: 7},
+			AfterPos:  token.Position{Filename: "lib.go", Line: 4, Column: 7},
+		},
+		{
+			Pkg: "example.com/lib", ID: "B", Change: apicompat.Breaking, Msg: "declaration removed",
+			BeforePos: token.Position{Filename: "lib.go", Line: 5, Column: 7},
+		},
+		{
+			Pkg: "example.com/lib", ID: "C", Change: apicompat.NonBreaking, Msg: "declaration added",
+			AfterPos: token.Position{Filename: "lib.go", Line: 6, Column: 7},
+		},
+		{Pkg: "example.com/lib/b", Change: apicompat.Breaking, Msg: "package removed"},
+		{Pkg: "example.com/lib", ID: "D", Change: apicompat.Breaking, Msg: "declaration renamed", Detail: "renamed D → E"},
+		{Pkg: "example.com/lib", ID: "F", Change: apicompat.Breaking, Msg: "declaration renamed", Detail: "renamed F → G"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeSARIF(&buf, changes); err != nil {
+		t.Fatal(err)
+	}
+
+	schemaJSON, err := ioutil.ReadFile("testdata/sarif-schema-2.1.0.json")
+	if err != nil {
+		t.Fatal("cannot load sarif schema:", err)
+	}
+	var schema, doc map[string]interface{}
+	if err := json.Unmarshal(schemaJSON, &schema); err != nil {
+		t.Fatal("cannot decode sarif schema:", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal("cannot decode sarif output:", err)
+	}
+
+	v := schemaValidator{root: schema}
+	for _, err := range v.validate(schema, doc, "") {
+		t.Error(err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	results := log.Runs[0].Results
+	if len(results) != len(changes) {
+		t.Fatalf("exp %d results got %d", len(changes), len(results))
+	}
+	if exp := "error"; results[0].Level != exp {
+		t.Errorf("exp level %q got %q", exp, results[0].Level)
+	}
+	if exp := "note"; results[2].Level != exp {
+		t.Errorf("exp level %q got %q", exp, results[2].Level)
+	}
+	if exp := 4; results[0].Locations[0].PhysicalLocation.Region.StartLine != exp {
+		t.Errorf("exp after position line %d got %d", exp, results[0].Locations[0].PhysicalLocation.Region.StartLine)
+	}
+	if exp := 3; results[0].RelatedLocations[0].PhysicalLocation.Region.StartLine != exp {
+		t.Errorf("exp before position line %d got %d", exp, results[0].RelatedLocations[0].PhysicalLocation.Region.StartLine)
+	}
+	rule := log.Runs[0].Tool.Driver.Rules[results[1].RuleIndex]
+	if rule.ID != results[1].RuleID || rule.ID != "declaration-removed" {
+		t.Errorf("unexpected rule %q for result rule %q", rule.ID, results[1].RuleID)
+	}
+	// Renames share a rule, and their messages include the new name
+	if results[4].RuleID != "declaration-renamed" || results[5].RuleID != results[4].RuleID || len(log.Runs[0].Tool.Driver.Rules) != 5 {
+		t.Errorf("exp renames to share a rule got %q and %q of %d rules", results[4].RuleID, results[5].RuleID, len(log.Runs[0].Tool.Driver.Rules))
+	}
+	if exp := "F: breaking change renamed F → G"; results[5].Message.Text != exp {
+		t.Errorf("exp message %q got %q", exp, results[5].Message.Text)
+	}
+}
+
+// schemaValidator validates a decoded JSON document against the subset of
+// JSON Schema draft 4 used by the SARIF schema.
+type schemaValidator struct {
+	root map[string]interface{}
+}
+
+func (v schemaValidator) validate(schema map[string]interface{}, doc interface{}, path string) (errs []error) {
+	if ref, ok := schema["$ref"].(string); ok {
+		// only local references are used, such as #/definitions/run
+		def := v.root
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			def = def[part].(map[string]interface{})
+		}
+		return v.validate(def, doc, path)
+	}
+
+	if typ, ok := schema["type"].(string); ok && !jsonTypeIs(doc, typ) {
+		return []error{fmt.Errorf("%s: expected type %s got %T", path, typ, doc)}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || reflect.DeepEqual(e, doc)
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("%s: %v not in enum %v", path, doc, enum))
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, s := range anyOf {
+			matched = matched || len(v.validate(s.(map[string]interface{}), doc, path)) == 0
+		}
+		if !matched {
+			errs = append(errs, fmt.Errorf("%s: does not match anyOf", path))
+		}
+	}
+
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		props, _ := schema["properties"].(map[string]interface{})
+		for _, req := range asSlice(schema["required"]) {
+			if _, ok := d[req.(string)]; !ok {
+				errs = append(errs, fmt.Errorf("%s: missing required property %q", path, req))
+			}
+		}
+		for name, value := range d {
+			if prop, ok := props[name]; ok {
+				errs = append(errs, v.validate(prop.(map[string]interface{}), value, path+"/"+name)...)
+				continue
+			}
+			switch add := schema["additionalProperties"].(type) {
+			case bool:
+				if !add {
+					errs = append(errs, fmt.Errorf("%s: additional property %q not allowed", path, name))
+				}
+			case map[string]interface{}:
+				errs = append(errs, v.validate(add, value, path+"/"+name)...)
+			}
+		}
+	case []interface{}:
+		if min, ok := schema["minItems"].(float64); ok && float64(len(d)) < min {
+			errs = append(errs, fmt.Errorf("%s: expected at least %v items", path, min))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range d {
+				errs = append(errs, v.validate(items, item, fmt.Sprintf("%s/%d", path, i))...)
+			}
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && d < min {
+			errs = append(errs, fmt.Errorf("%s: %v is less than minimum %v", path, d, min))
+		}
+	case string:
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(d) {
+			errs = append(errs, fmt.Errorf("%s: %q does not match pattern %q", path, d, pattern))
+		}
+	}
+	return errs
+}
+
+func asSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
+
+// jsonTypeIs reports whether the decoded JSON value v is of JSON Schema type typ.
+func jsonTypeIs(v interface{}, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package apicompat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestConcurrency checks several packages, which import each other, parsed
+// concurrently and serially, and expects the same changes in the same order.
+func TestConcurrency(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "conc")
+	before, after := make(map[string]string), make(map[string]string)
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("p%d", i)
+		imp, use := "", ""
+		if i > 0 {
+			// each package's API depends on the previous package
+			imp = fmt.Sprintf("import \"example.com/conc/p%d\"\n\n", i-1)
+			use = fmt.Sprintf("var Prev p%d.T\n\n", i-1)
+		}
+		before[name+"/a.go"] = fmt.Sprintf("package %s\n\n%stype T struct{ A int }\n\n%sfunc F(a int) {}\n\nfunc Removed() {}\n", name, imp, use)
+		after[name+"/a.go"] = fmt.Sprintf("package %s\n\n%stype T struct{ A uint }\n\n%sfunc F(a int) {}\n\nfunc Added() {}\n", name, imp, use)
+	}
+	testenv.GitCommit(t, repo, before)
+	testenv.GitCommit(t, repo, after)
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+
+	type result struct {
+		pkg, id, change, msg, pos string
+	}
+	check := func(concurrency int) []result {
+		var vlog bytes.Buffer
+		checker := New(SetVCS(vcs), SetConcurrency(concurrency), SetVLog(&vlog), SetReportUnchanged(true))
+		changes, err := checker.CheckPackages(context.Background(), CheckRequest{
+			Patterns: []string{repo + "/..."}, Before: "HEAD~1", After: "HEAD",
+		})
+		if err != nil {
+			t.Fatalf("concurrency %d: unexpected error: %v", concurrency, err)
+		}
+		var results []result
+		for _, change := range changes {
+			results = append(results, result{change.Pkg, change.ID, change.Change, change.Msg, change.Pos})
+		}
+		return results
+	}
+
+	// each package's T, F, Removed, Added and Prev, except the first's Prev
+	exp := check(1)
+	if len(exp) != 8*5-1 {
+		t.Fatalf("exp %d changes got %d: %v", 8*5-1, len(exp), exp)
+	}
+	for i := 0; i < 5; i++ {
+		if got := check(4); !reflect.DeepEqual(got, exp) {
+			t.Fatalf("run %d: exp changes:\n%v\ngot:\n%v", i, exp, got)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package apicompat
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// depChange compares the structure of types from other packages which are
+// reachable from a declaration, such as a function returning dep.Thing. The
+// declaration's source may be unchanged while a dependency upgrade changes
+// dep.Thing, which still changes the declaration's API.
+//
+// Only types from external dependencies are compared, not from the standard
+// library, the package's own module or GOPATH repository, or packages checked
+// is true for. Matching types are found by their package path and name, and
+// compared by diffNamed. The change's Msg omits the dependency type's name,
+// which is included in detail.
+func depChange(bpkg, apkg pkg, bDecl, aDecl ast.Decl, checked func(path string) bool) (change DeclChange, detail string) {
+	var (
+		bobj = declObject(bpkg.info, bDecl)
+		aobj = declObject(apkg.info, aDecl)
+	)
+	if bobj == nil || aobj == nil {
+		return none(), ""
+	}
+
+	btypes := make(map[string]*types.Named)
+	reachable(btypes, bpkg.local(checked), declType(bobj))
+	atypes := make(map[string]*types.Named)
+	reachable(atypes, apkg.local(checked), declType(aobj))
+
+	// Compare in a consistent order, so the reported change is deterministic
+	var names []string
+	for name := range atypes {
+		if _, ok := btypes[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	change = none()
+	for _, name := range names {
+		dchange := diffNamed(btypes[name], atypes[name])
+		if severity(dchange.Change) > severity(change.Change) {
+			change = dchange
+			change.Msg = "dependency type " + dchange.Msg
+			detail = fmt.Sprintf("dependency type %s %s", name, dchange.Msg)
+		}
+	}
+	change.Pos = declPos(aDecl)
+	return change, detail
+}
+
+// severity orders the change types, from None to Breaking.
+func severity(change string) int {
+	switch change {
+	case NonBreaking:
+		return 1
+	case Breaking:
+		return 2
+	}
+	return 0
+}
+
+// qualifier qualifies types with their full package path, so types from
+// different type checks of the same package have equal strings.
+func qualifier(p *types.Package) string { return p.Path() }
+
+// isStdPath returns true if path is in the standard library, which has no dot
+// in its first path element.
+func isStdPath(path string) bool {
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		path = path[:i]
+	}
+	return !strings.Contains(path, ".")
+}
+
+// local returns a function reporting whether an import path isn't an
+// external dependency of p: p itself, a package in p's module or GOPATH
+// repository, or a package checked is true for.
+func (p pkg) local(checked func(path string) bool) func(path string) bool {
+	return func(path string) bool {
+		switch {
+		case path == p.importPath, checked(path):
+			return true
+		case p.module != "" && (path == p.module || strings.HasPrefix(path, p.module+"/")):
+			return true
+		}
+		return false
+	}
+}
+
+// reachable adds the named types outside of the standard library and the
+// packages local is true for, which are reachable from t, to found. Types in
+// local packages are not followed, as they are checked as their own
+// declarations.
+func reachable(found map[string]*types.Named, local func(path string) bool, t types.Type) {
+	walkType(t, func(named *types.Named) bool {
+		obj := named.Obj()
+		if obj.Pkg() == nil || local(obj.Pkg().Path()) || isStdPath(obj.Pkg().Path()) {
+			return false
+		}
+		name := types.TypeString(named, qualifier)
+		if _, ok := found[name]; ok {
+			return false
+		}
+		found[name] = named
+		return true
+	})
+}
+
+// diffNamed compares the structure of a dependency's named type between two
+// versions. There's no source for a dependency's types, so they can't be
+// compared by DeclChecker, instead their members are compared by type string.
+// The change messages match DeclChecker's, but the rules are simpler: struct
+// fields and method sets may only be added to, interface methods must be
+// unchanged, and any other change to the underlying type is breaking, without
+// DeclChecker's exceptions such as for variadic parameters or channel
+// directions.
+func diffNamed(before, after *types.Named) DeclChange {
+	switch b := before.Underlying().(type) {
+	case *types.Struct:
+		if a, ok := after.Underlying().(*types.Struct); ok {
+			if change := diffMembers(structFields(b), structFields(a), false); change.Change != None {
+				return change
+			}
+			return diffMembers(methods(before), methods(after), false)
+		}
+	case *types.Interface:
+		if a, ok := after.Underlying().(*types.Interface); ok {
+			return diffMembers(ifaceMethods(b), ifaceMethods(a), true)
+		}
+	default:
+		if types.TypeString(b, qualifier) == types.TypeString(after.Underlying(), qualifier) {
+			return diffMembers(methods(before), methods(after), false)
+		}
+	}
+	return breaking("changed underlying type", 0)
+}
+
+// structFields returns the exported fields of a struct by name.
+func structFields(s *types.Struct) map[string]string {
+	fields := make(map[string]string)
+	for i := 0; i < s.NumFields(); i++ {
+		if f := s.Field(i); f.Exported() {
+			fields[f.Name()] = types.TypeString(f.Type(), qualifier)
+		}
+	}
+	return fields
+}
+
+// methods returns the exported methods of a named type and its pointer by
+// name.
+func methods(t *types.Named) map[string]string {
+	methods := make(map[string]string)
+	mset := types.NewMethodSet(types.NewPointer(t))
+	for i := 0; i < mset.Len(); i++ {
+		if obj := mset.At(i).Obj(); obj.Exported() {
+			methods[obj.Name()] = types.TypeString(obj.Type(), qualifier)
+		}
+	}
+	return methods
+}
+
+// ifaceMethods returns the methods of an interface, including embedded
+// interfaces, by name.
+func ifaceMethods(iface *types.Interface) map[string]string {
+	methods := make(map[string]string)
+	for i := 0; i < iface.NumMethods(); i++ {
+		methods[iface.Method(i).Name()] = types.TypeString(iface.Method(i).Type(), qualifier)
+	}
+	return methods
+}
+
+// diffMembers compares members by name and type. Removed or changed members
+// are breaking, added members are breaking only if addBreaks is true, such as
+// for interfaces which would no longer be satisfied by existing types.
+func diffMembers(before, after map[string]string, addBreaks bool) DeclChange {
+	var added bool
+	for name, atype := range after {
+		btype, ok := before[name]
+		if !ok {
+			added = true
+			continue
+		}
+		if btype != atype {
+			return breaking("members changed types", 0)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			return breaking("members removed", 0)
+		}
+	}
+	switch {
+	case added && addBreaks:
+		return breaking("members added", 0)
+	case added:
+		return nonBreaking("members added", 0)
+	}
+	return none()
+}
@@ -0,0 +1,69 @@
+package apicompat
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestCheckDependencies upgrades a dependency in go.mod without changing the
+// package's source, the dependency's types are only compared when enabled.
+// Types from another package in the same module aren't dependencies.
+func TestCheckDependencies(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	testenv.WriteFiles(t, filepath.Join(gopath, "pkg", "mod"), map[string]string{
+		"example.com/dep@v1.0.0/dep.go": "package dep\n\ntype Thing struct{ A, B int }\n\ntype Doer interface{ Do() }\n\nfunc (Thing) M() {}\n",
+		"example.com/dep@v1.1.0/dep.go": "package dep\n\ntype Thing struct{ A int }\n\ntype Doer interface{ Do(); Undo() }\n\nfunc (Thing) M() {}\n\nfunc (Thing) N() {}\n",
+	})
+
+	repo := filepath.Join(gopath, "src", "example.com", "imp")
+	testenv.GitCommit(t, repo, map[string]string{
+		"go.mod":     "module example.com/imp\n\nrequire example.com/dep v1.0.0\n",
+		"d/d.go":     "package d\n\nimport (\n\t\"example.com/dep\"\n\t\"example.com/imp/sib\"\n)\n\nfunc F() *dep.Thing { return nil }\n\ntype S struct{ D dep.Doer }\n\nvar V []int\n\nfunc G() sib.T { return sib.T{} }\n",
+		"sib/sib.go": "package sib\n\ntype T struct{ A, B int }\n",
+	})
+	testenv.GitCommit(t, repo, map[string]string{
+		"go.mod":     "module example.com/imp\n\nrequire example.com/dep v1.1.0\n",
+		"sib/sib.go": "package sib\n\ntype T struct{ A int }\n",
+	})
+
+	for _, deps := range []bool{false, true} {
+		vcs, err := NewGit(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes, err := New(SetVCS(vcs), SetCheckDependencies(deps)).CheckPackages(context.Background(), CheckRequest{
+			Patterns: []string{filepath.Join(repo, "d")}, Before: "HEAD~1", After: "HEAD",
+		})
+		if cerr := vcs.Close(); cerr != nil {
+			t.Errorf("deps %v: unexpected error closing: %v", deps, cerr)
+		}
+		if err != nil {
+			t.Fatalf("deps %v: unexpected error: %v", deps, err)
+		}
+
+		exp := map[string]string{}
+		if deps {
+			exp = map[string]string{
+				"F": "dependency type example.com/dep.Thing members removed",
+				"S": "dependency type example.com/dep.Doer members added",
+			}
+		}
+		if len(changes) != len(exp) {
+			t.Errorf("deps %v: exp %d changes got %d: %v", deps, len(exp), len(changes), changes)
+		}
+		for _, change := range changes {
+			if change.Change != Breaking || change.Detail != exp[change.ID] {
+				t.Errorf("deps %v: %s: exp breaking change %q got %s %q", deps, change.ID, exp[change.ID], change.Change, change.Detail)
+			}
+			// The type's name is only in the detail, so the changes share a message
+			if !strings.HasPrefix(change.Msg, "dependency type members ") {
+				t.Errorf("deps %v: %s: unexpected message %q", deps, change.ID, change.Msg)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package apicompat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+)
+
+// ParseError is returned when a package cannot be found, read or parsed at a
+// revision.
+type ParseError struct {
+	Rev  string         // Rev is the revision being parsed
+	Pkg  string         // Pkg is the import path or directory of the package
+	File string         // File is the file's name, if the error is within a file
+	Pos  token.Position // Pos is the position of a syntax error, without the revision prefix
+	Err  error          // Err is the underlying error, such as a *VCSError
+}
+
+func (e *ParseError) Error() string {
+	switch {
+	case e.Pos.IsValid():
+		return fmt.Sprintf("could not parse %s at revision %q: %v", e.Pos, e.Rev, e.Err)
+	case e.File != "":
+		return fmt.Sprintf("could not parse file %q of %s at revision %q: %v", e.File, e.Pkg, e.Rev, e.Err)
+	}
+	return fmt.Sprintf("could not parse %s at revision %q: %v", e.Pkg, e.Rev, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// diagnostic returns the error as a Diagnostic, for lenient mode.
+func (e *ParseError) diagnostic() Diagnostic {
+	msg := e.Err.Error()
+	if e.File != "" && !e.Pos.IsValid() {
+		msg = fmt.Sprintf("file %q: %s", e.File, msg)
+	}
+	return Diagnostic{Pkg: e.Pkg, Rev: e.Rev, Pos: e.Pos, Msg: msg, Err: e}
+}
+
+// TypeCheckError is returned when a package fails to type check at a
+// revision.
+type TypeCheckError struct {
+	Rev  string         // Rev is the revision being type checked
+	Pkg  string         // Pkg is the import path of the package
+	File string         // File is the file containing the error, without the revision prefix
+	Pos  token.Position // Pos is the position of the error, without the revision prefix
+	Msg  string         // Msg is the type checker's message
+	Err  error          // Err is the underlying error, usually a types.Error
+}
+
+func (e *TypeCheckError) Error() string {
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("could not type check %s at revision %q: %s: %s", e.Pkg, e.Rev, e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("could not type check %s at revision %q: %s", e.Pkg, e.Rev, e.Msg)
+}
+
+// Unwrap returns the underlying error.
+func (e *TypeCheckError) Unwrap() error { return e.Err }
+
+// VCSError is returned when a VCS fails, such as the git binary not being
+// found or a revision not existing. A file not existing at a revision is
+// reported as an error satisfying os.IsNotExist instead.
+type VCSError struct {
+	VCS  string // VCS is the name of the version control system, such as git
+	Op   string // Op is the operation, such as open or read dir
+	Rev  string // Rev is the revision, if any
+	Path string // Path is the path operated on
+	Err  error  // Err is the underlying error
+}
+
+func (e *VCSError) Error() string {
+	if e.Rev != "" {
+		return fmt.Sprintf("%s %s %s at revision %q: %v", e.VCS, e.Op, e.Path, e.Rev, e.Err)
+	}
+	return fmt.Sprintf("%s %s %s: %v", e.VCS, e.Op, e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *VCSError) Unwrap() error { return e.Err }
+
+// vcsError wraps err in a *VCSError, unless it's nil, the file did not exist
+// or the context was done.
+func vcsError(vcs, op, rev, path string, err error) error {
+	if err == nil || os.IsNotExist(err) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &VCSError{VCS: vcs, Op: op, Rev: rev, Path: path, Err: err}
+}
+
+// CompareError is returned when a declaration could not be compared between
+// revisions, this is a bug in the checker. The declarations are written to
+// the verbose log.
+type CompareError struct {
+	Pkg    string   // Pkg is the import path of the package
+	ID     string   // ID is the declaration's identifier
+	Before ast.Decl // Before is the declaration in the before revision
+	After  ast.Decl // After is the declaration in the after revision
+	Err    error    // Err is the underlying error
+}
+
+func (e *CompareError) Error() string {
+	return fmt.Sprintf("error comparing declaration %s in %s: %v", e.ID, e.Pkg, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *CompareError) Unwrap() error { return e.Err }
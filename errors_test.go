@@ -0,0 +1,93 @@
+package apicompat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"path/filepath"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestErrors checks errors from Check can be inspected with errors.As.
+func TestErrors(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "errs")
+	testenv.GitCommit(t, repo, map[string]string{
+		"parse/p.go": "package parse\n\nconst A = 1\n",
+		"types/t.go": "package types\n\nconst A = 1\n",
+	})
+	testenv.GitCommit(t, repo, map[string]string{
+		"parse/p.go": "package parse\n\nconst A = \n",
+		"types/t.go": "package types\n\nconst A = undefined\n",
+	})
+
+	if _, err := NewGit(gopath); !errors.As(err, new(*VCSError)) {
+		t.Errorf("NewGit outside a repository: exp *VCSError got %T %v", err, err)
+	}
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	checker := New(SetVCS(vcs))
+	check := func(pkg, after string) error {
+		_, err := checker.CheckPackages(context.Background(), CheckRequest{
+			Patterns: []string{filepath.Join(repo, pkg)}, Before: "HEAD~1", After: after,
+		})
+		return err
+	}
+
+	var perr *ParseError
+	if err := check("parse", "HEAD"); !errors.As(err, &perr) {
+		t.Errorf("parse: exp *ParseError got %T %v", err, err)
+	} else if perr.Rev != "HEAD" || perr.File != "p.go" || perr.Pos.Line != 3 {
+		t.Errorf("parse: exp revision HEAD file p.go line 3 got %q %q %d", perr.Rev, perr.File, perr.Pos.Line)
+	}
+
+	var terr *TypeCheckError
+	if err := check("types", "HEAD"); !errors.As(err, &terr) {
+		t.Errorf("types: exp *TypeCheckError got %T %v", err, err)
+	} else if terr.Rev != "HEAD" || terr.Pkg != "example.com/errs/types" || terr.Pos.Line != 3 {
+		t.Errorf("types: exp revision HEAD package example.com/errs/types line 3 got %q %q %d", terr.Rev, terr.Pkg, terr.Pos.Line)
+	}
+
+	var verr *VCSError
+	if err := check("types", "missing"); !errors.As(err, &verr) {
+		t.Errorf("missing revision: exp *VCSError got %T %v", err, err)
+	} else if verr.Rev != "missing" {
+		t.Errorf("missing revision: exp revision missing got %q", verr.Rev)
+	}
+}
+
+// TestCompareError checks a declaration DeclChecker doesn't support is
+// returned as a *CompareError.
+func TestCompareError(t *testing.T) {
+	before := &Package{p: pkg{importPath: "example.com/compare", decls: map[string]ast.Decl{"F": &ast.BadDecl{}}}}
+	after := &Package{p: pkg{importPath: "example.com/compare", decls: map[string]ast.Decl{"F": &ast.BadDecl{}}}}
+
+	_, err := New().ComparePackages(context.Background(), before, after)
+	var cerr *CompareError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("exp *CompareError got %T %v", err, err)
+	}
+	if cerr.Pkg != "example.com/compare" || cerr.ID != "F" || cerr.Before == nil || cerr.After == nil || cerr.Err == nil {
+		t.Errorf("exp package example.com/compare declaration F got %+v", cerr)
+	}
+}
+
+func TestVCSErrorContext(t *testing.T) {
+	wrapped := fmt.Errorf("reading: %w", context.DeadlineExceeded)
+	for _, err := range []error{context.Canceled, context.DeadlineExceeded, wrapped} {
+		if got := vcsError("git", "open", "HEAD", "a.go", err); got != err {
+			t.Errorf("exp context error %v unwrapped got %v", err, got)
+		}
+	}
+	if got := vcsError("git", "open", "HEAD", "a.go", errors.New("failed")); !errors.As(got, new(*VCSError)) {
+		t.Errorf("exp *VCSError got %T %v", got, got)
+	}
+}
@@ -0,0 +1,385 @@
+package apicompat
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TextEdit replaces the bytes from Offset to End of file Filename in the
+// after revision, without the revision prefix, with NewText. Offset and End
+// are equal for an insertion.
+type TextEdit struct {
+	Filename    string
+	Offset, End int
+	NewText     string
+}
+
+// SuggestedFix is a set of edits to the after revision which restores
+// compatibility, such as keeping a removed declaration as deprecated. Fixes
+// are mechanical and may not compile, such as when a restored function uses
+// removed declarations, so should be reviewed.
+type SuggestedFix struct {
+	Msg   string // Msg describes the fix
+	Edits []TextEdit
+}
+
+// ApplyEdits returns src with edits applied, edits are against src and must
+// not overlap, insertions at the same offset are applied in order. Identical
+// edits, such as an import added by several fixes, are applied once.
+func ApplyEdits(src []byte, edits []TextEdit) ([]byte, error) {
+	var (
+		unique []TextEdit
+		seen   = make(map[TextEdit]bool)
+	)
+	for _, edit := range edits {
+		if !seen[edit] {
+			seen[edit] = true
+			unique = append(unique, edit)
+		}
+	}
+	edits = unique
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].Offset < edits[j].Offset })
+
+	var (
+		buf  bytes.Buffer
+		last int
+	)
+	for _, edit := range edits {
+		if edit.Offset < last || edit.End < edit.Offset || edit.End > len(src) {
+			return nil, fmt.Errorf("invalid edit of %s at offset %d to %d", edit.Filename, edit.Offset, edit.End)
+		}
+		buf.Write(src[last:edit.Offset])
+		buf.WriteString(edit.NewText)
+		last = edit.End
+	}
+	buf.Write(src[last:])
+	return buf.Bytes(), nil
+}
+
+// suggestFixes returns fixes for a breaking change to declaration id, aDecl is
+// nil if it was removed.
+func suggestFixes(bpkg, apkg pkg, id string, bDecl, aDecl ast.Decl) []SuggestedFix {
+	if apkg.tpkg == nil {
+		return nil
+	}
+	var (
+		fix SuggestedFix
+		ok  bool
+	)
+	switch {
+	case aDecl != nil:
+		fix, ok = versionFix(bpkg, apkg, id, bDecl, aDecl)
+	default:
+		if fix, ok = aliasFix(bpkg, apkg, id, bDecl); !ok {
+			fix, ok = restoreFix(bpkg, apkg, id, bDecl)
+		}
+	}
+	if !ok {
+		return nil
+	}
+	return []SuggestedFix{fix}
+}
+
+// aliasFix returns a fix adding an alias for a removed type, if it was renamed
+// to a single added type with the same underlying type.
+func aliasFix(bpkg, apkg pkg, id string, bDecl ast.Decl) (SuggestedFix, bool) {
+	spec, ok := typeSpec(bDecl)
+	if !ok || spec.Assign.IsValid() {
+		return SuggestedFix{}, false
+	}
+	obj, ok := bpkg.info.Defs[spec.Name].(*types.TypeName)
+	if !ok {
+		return SuggestedFix{}, false
+	}
+	underlying := types.TypeString(obj.Type().Underlying(), qualifier)
+
+	var renamed string
+	for aid, aDecl := range apkg.decls {
+		if _, ok := bpkg.decls[aid]; ok {
+			continue
+		}
+		aspec, ok := typeSpec(aDecl)
+		if !ok || aspec.Assign.IsValid() {
+			continue
+		}
+		aobj, ok := apkg.info.Defs[aspec.Name].(*types.TypeName)
+		if !ok || types.TypeString(aobj.Type().Underlying(), qualifier) != underlying {
+			continue
+		}
+		if renamed != "" {
+			// ambiguous
+			return SuggestedFix{}, false
+		}
+		renamed = aid
+	}
+	if renamed == "" {
+		return SuggestedFix{}, false
+	}
+
+	text := deprecated(bpkg, bDecl, "Use "+renamed+" instead.") + fmt.Sprintf("type %s = %s\n", id, renamed)
+	edit, ok := appendEdit(bpkg, apkg, bDecl, text)
+	if !ok {
+		return SuggestedFix{}, false
+	}
+	return SuggestedFix{Msg: fmt.Sprintf("add deprecated alias %s = %s", id, renamed), Edits: []TextEdit{edit}}, true
+}
+
+// restoreFix returns a fix restoring a removed declaration as deprecated.
+func restoreFix(bpkg, apkg pkg, id string, bDecl ast.Decl) (SuggestedFix, bool) {
+	if !restorable(apkg, bDecl) {
+		return SuggestedFix{}, false
+	}
+	src, ok := declSource(bpkg, bDecl)
+	if !ok {
+		return SuggestedFix{}, false
+	}
+	text := deprecated(bpkg, bDecl, id+" is retained for compatibility.") + src + "\n"
+	edit, ok := appendEdit(bpkg, apkg, bDecl, text)
+	if !ok {
+		return SuggestedFix{}, false
+	}
+	return SuggestedFix{Msg: fmt.Sprintf("restore %s as deprecated", id), Edits: []TextEdit{edit}}, true
+}
+
+// versionFix returns a fix for a function whose signature changed, renaming
+// the new function with a version suffix, such as FV2, and restoring the
+// previous function as deprecated.
+func versionFix(bpkg, apkg pkg, id string, bDecl, aDecl ast.Decl) (SuggestedFix, bool) {
+	bfn, bok := bDecl.(*ast.FuncDecl)
+	afn, aok := aDecl.(*ast.FuncDecl)
+	if !bok || !aok || bfn.Recv != nil || afn.Recv != nil {
+		return SuggestedFix{}, false
+	}
+	src, ok := declSource(bpkg, bfn)
+	if !ok {
+		return SuggestedFix{}, false
+	}
+
+	name := id + "V2"
+	for v := 3; apkg.tpkg.Scope().Lookup(name) != nil; v++ {
+		name = id + "V" + strconv.Itoa(v)
+	}
+
+	end := afn.Type.End()
+	if body := apkg.bodies[afn]; body != nil {
+		end = body.End()
+	}
+	edits := []TextEdit{apkg.edit(afn.Name.Pos(), afn.Name.End(), name)}
+	if doc, pos := apkg.docSource(declPos(afn)); strings.HasPrefix(doc, "// "+id+" ") {
+		// doc comments begin with the function's name
+		pos += token.Pos(len("// "))
+		edits = append(edits, apkg.edit(pos, pos+token.Pos(len(id)), name))
+	}
+	text := "\n\n" + deprecated(bpkg, bfn, "Use "+name+" instead.") + src
+	edits = append(edits, apkg.edit(end, end, text))
+	return SuggestedFix{
+		Msg:   fmt.Sprintf("rename %s to %s and restore %s as deprecated", id, name, id),
+		Edits: edits,
+	}, true
+}
+
+// restorable returns true if the removed declaration bDecl can be added to the
+// after package without conflicting with its declarations.
+func restorable(apkg pkg, bDecl ast.Decl) bool {
+	fn, ok := bDecl.(*ast.FuncDecl)
+	if !ok || fn.Recv == nil || len(fn.Recv.List) == 0 {
+		for _, name := range declNames(bDecl) {
+			if apkg.tpkg.Scope().Lookup(name) != nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	// A method needs its receiver type, which must not already have a field
+	// or method with the same name
+	recv := fn.Recv.List[0].Type
+	if star, ok := recv.(*ast.StarExpr); ok {
+		recv = star.X
+	}
+	ident, ok := recv.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	tname, ok := apkg.tpkg.Scope().Lookup(ident.Name).(*types.TypeName)
+	if !ok || tname.IsAlias() {
+		return false
+	}
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(tname.Type()), false, apkg.tpkg, fn.Name.Name)
+	return obj == nil
+}
+
+// declNames returns the names declared by decl.
+func declNames(decl ast.Decl) []string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		return []string{d.Name.Name}
+	case *ast.GenDecl:
+		var names []string
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, name := range s.Names {
+					names = append(names, name.Name)
+				}
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// typeSpec returns the TypeSpec of decl, if it declares a type.
+func typeSpec(decl ast.Decl) (*ast.TypeSpec, bool) {
+	gen, ok := decl.(*ast.GenDecl)
+	if !ok || gen.Tok != token.TYPE || len(gen.Specs) != 1 {
+		return nil, false
+	}
+	spec, ok := gen.Specs[0].(*ast.TypeSpec)
+	return spec, ok
+}
+
+// declSource returns the source of decl, without its doc comment. Constants
+// and variables which depend on their position in a block, such as using
+// iota, have no source.
+func declSource(p pkg, decl ast.Decl) (string, bool) {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		end := d.Type.End()
+		if body := p.bodies[d]; body != nil {
+			end = body.End()
+		}
+		return p.source(d.Pos(), end)
+	case *ast.GenDecl:
+		if len(d.Specs) != 1 {
+			return "", false
+		}
+		switch s := d.Specs[0].(type) {
+		case *ast.TypeSpec:
+			src, ok := p.source(s.Pos(), s.End())
+			return "type " + src, ok
+		case *ast.ValueSpec:
+			if len(s.Values) == 0 && (d.Tok == token.CONST || s.Type == nil) || usesIota(s) {
+				return "", false
+			}
+			// value specs are split by pkgDecls, so print rather than slice
+			var buf bytes.Buffer
+			if err := format.Node(&buf, p.fset, &ast.GenDecl{Tok: d.Tok, Specs: []ast.Spec{&ast.ValueSpec{Names: s.Names, Type: s.Type, Values: s.Values}}}); err != nil {
+				return "", false
+			}
+			return buf.String(), true
+		}
+	}
+	return "", false
+}
+
+// usesIota returns true if spec's values refer to iota.
+func usesIota(spec *ast.ValueSpec) bool {
+	var found bool
+	for _, value := range spec.Values {
+		ast.Inspect(value, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok && ident.Name == "iota" {
+				found = true
+			}
+			return !found
+		})
+	}
+	return found
+}
+
+// deprecated returns decl's doc comment followed by a deprecation notice with
+// msg.
+func deprecated(p pkg, decl ast.Decl, msg string) string {
+	doc, _ := p.docSource(declPos(decl))
+	if doc != "" {
+		doc += "//\n"
+	}
+	return doc + "// Deprecated: " + msg + "\n"
+}
+
+// docSource returns the line comments immediately preceding the line of pos
+// and their position, as files are parsed without comments.
+func (p pkg) docSource(pos token.Pos) (string, token.Pos) {
+	file := p.fset.File(pos)
+	if file == nil {
+		return "", token.NoPos
+	}
+	src, ok := p.srcs[file.Name()]
+	if !ok {
+		return "", token.NoPos
+	}
+	var (
+		doc   string
+		start = token.NoPos
+	)
+	for line := file.Line(pos) - 1; line > 0; line-- {
+		text := string(src[file.Offset(file.LineStart(line)):file.Offset(file.LineStart(line+1))])
+		trimmed := strings.TrimSpace(text)
+		if !strings.HasPrefix(trimmed, "//") {
+			break
+		}
+		doc = trimmed + "\n" + doc
+		start = file.LineStart(line) + token.Pos(strings.Index(text, "//"))
+	}
+	return doc, start
+}
+
+// appendEdit returns an edit appending text to the after package's file with
+// the same name as bDecl's file, or its first file.
+func appendEdit(bpkg, apkg pkg, bDecl ast.Decl, text string) (TextEdit, bool) {
+	name := filepath.Base(bpkg.position(declPos(bDecl)).Filename)
+	var file *token.File
+	apkg.fset.Iterate(func(f *token.File) bool {
+		if file == nil || filepath.Base(strings.TrimPrefix(f.Name(), apkg.rev+":")) == name {
+			file = f
+		}
+		return true
+	})
+	if file == nil {
+		return TextEdit{}, false
+	}
+	end := file.Pos(file.Size())
+	return apkg.edit(end, end, "\n"+text), true
+}
+
+// source returns the source from start to end, if the file's contents are
+// known.
+func (p pkg) source(start, end token.Pos) (string, bool) {
+	file := p.fset.File(start)
+	if file == nil {
+		return "", false
+	}
+	src, ok := p.srcs[file.Name()]
+	if !ok {
+		return "", false
+	}
+	return string(src[file.Offset(start):file.Offset(end)]), true
+}
+
+// edit returns an edit replacing the source from start to end with text.
+func (p pkg) edit(start, end token.Pos, text string) TextEdit {
+	file := p.fset.File(start)
+	return TextEdit{Filename: p.position(start).Filename, Offset: file.Offset(start), End: file.Offset(end), NewText: text}
+}
+
+// saveBodies records the bodies of functions, which pkgDecls removes.
+func (p *pkg) saveBodies(files []*ast.File) {
+	p.bodies = make(map[*ast.FuncDecl]*ast.BlockStmt)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok {
+				p.bodies[fn] = fn.Body
+			}
+		}
+	}
+}
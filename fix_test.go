@@ -0,0 +1,109 @@
+package apicompat
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestFixes applies the suggested fixes for breaking changes and checks the
+// fixed package is compatible with the original.
+func TestFixes(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "fix")
+	testenv.GitCommit(t, repo, map[string]string{
+		"p.go": `package p
+
+// F returns a.
+func F(a int) int { return a }
+
+// Old is renamed.
+type Old struct{ A int }
+
+// Removed is removed.
+func Removed() string { return "removed" }
+
+const K = 1
+
+type T struct{}
+
+func (T) M() {}
+`,
+	})
+	testenv.GitCommit(t, repo, map[string]string{
+		"p.go": `package p
+
+// F returns a plus b.
+func F(a, b int) int { return a + b }
+
+type New struct{ A int }
+
+type T struct{}
+`,
+	})
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	checker := New(SetVCS(vcs))
+	changes, err := checker.CheckPackages(context.Background(), CheckRequest{Patterns: []string{repo}, Before: "HEAD~1", After: "HEAD"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var (
+		fixes []string
+		edits = make(map[string][]TextEdit)
+	)
+	for _, change := range changes {
+		for _, fix := range change.Fixes {
+			fixes = append(fixes, fix.Msg)
+			for _, edit := range fix.Edits {
+				edits[edit.Filename] = append(edits[edit.Filename], edit)
+			}
+		}
+	}
+	sort.Strings(fixes)
+	exp := []string{
+		"add deprecated alias Old = New",
+		"rename F to FV2 and restore F as deprecated",
+		"restore K as deprecated",
+		"restore Removed as deprecated",
+		"restore T.M as deprecated",
+	}
+	if !reflect.DeepEqual(fixes, exp) {
+		t.Fatalf("exp fixes %q got %q", exp, fixes)
+	}
+
+	for file, fileEdits := range edits {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err = ApplyEdits(src, fileEdits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes, err = checker.CheckPackages(context.Background(), CheckRequest{Patterns: []string{repo}, Before: "HEAD~1", After: "."})
+	if err != nil {
+		t.Fatalf("unexpected error checking fixed package: %v", err)
+	}
+	for _, change := range changes {
+		if change.Change == Breaking {
+			t.Errorf("unexpected breaking change after fixes: %v", change)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+package apicompat
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of Impact.
+const (
+	ImpactCall           = "call"           // a function is called
+	ImpactMethod         = "method"         // a method is called or referenced
+	ImpactField          = "field"          // a struct field is accessed
+	ImpactImplementation = "implementation" // a consumer's type implements an interface
+	ImpactImport         = "import"         // a package is imported
+	ImpactReference      = "reference"      // any other use of a declaration
+)
+
+// Impact is a use of a changed declaration by a consumer package, which type
+// checks against the before revision but not the after revision.
+type Impact struct {
+	Consumer string         // Consumer is the import path of the consumer package, or its directory if not in GOPATH
+	Pos      token.Position // Pos is the position of the type error in the consumer
+	Pkg      string         // Pkg is the changed package, empty if the error could not be attributed
+	ID       string         // ID is the Change.ID of the changed declaration, empty for a package or if unknown
+	Kind     string         // Kind is how the consumer uses the declaration, such as ImpactCall
+	Msg      string         // Msg is the type checker's error
+}
+
+func (i Impact) String() string {
+	id := i.Pkg
+	if i.ID != "" {
+		id += "." + i.ID
+	}
+	if id == "" {
+		id = "unknown"
+	}
+	return fmt.Sprintf("%s: %s %s of %s: %s", i.Pos, i.Consumer, i.Kind, id, i.Msg)
+}
+
+// Impact checks the packages in req for breaking changes, then type checks
+// the consumer packages, on the file system, against both revisions of the
+// checked packages. Each type error a consumer only has after the change is
+// reported, attributed to the breaking change used at its position.
+//
+// Consumers are directories, a consumer ending in /... also includes the
+// packages in all subdirectories. Consumers are checked for each of the
+// request's platforms, an impact found on multiple platforms is only reported
+// once.
+func (c *Checker) Impact(ctx context.Context, req CheckRequest, consumers []string) ([]Impact, error) {
+	changes, err := c.CheckPackages(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	breaking := make(map[string]map[string]bool) // pkg -> ID -> breaking
+	for _, change := range changes {
+		if change.Change != Breaking {
+			continue
+		}
+		if breaking[change.Pkg] == nil {
+			breaking[change.Pkg] = make(map[string]bool)
+		}
+		breaking[change.Pkg][change.ID] = true
+	}
+	if len(breaking) == 0 {
+		return nil, nil
+	}
+
+	dirs, err := consumerDirs(consumers)
+	if err != nil {
+		return nil, err
+	}
+
+	bctx := c.defaultBuild()
+	platforms := req.Platforms
+	if len(platforms) == 0 {
+		platforms = []string{bctx.GOOS + "/" + bctx.GOARCH}
+	}
+
+	beforeRev, afterRev := c.revisions(req)
+	vcs := withContext(ctx, c.vcs)
+	var (
+		impacts []Impact
+		seen    = make(map[Impact]bool)
+	)
+	for _, platform := range platforms {
+		var err error
+		bctx.GOOS, bctx.GOARCH, err = parsePlatform(platform)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			dimpacts, err := consumerImpact(vcs, bctx, dir, beforeRev, afterRev, breaking)
+			if err != nil {
+				return nil, err
+			}
+			for _, impact := range dimpacts {
+				if !seen[impact] {
+					seen[impact] = true
+					impacts = append(impacts, impact)
+				}
+			}
+		}
+	}
+	return impacts, nil
+}
+
+// revisions returns the before and after revisions of req, or the VCS's
+// default revisions if unset.
+func (c *Checker) revisions(req CheckRequest) (before, after string) {
+	before, after = c.vcs.DefaultRevision()
+	if req.Before != "" {
+		before = req.Before
+	}
+	if req.After != "" {
+		after = req.After
+	}
+	return before, after
+}
+
+// consumerDirs returns the directories of consumer packages, expanding any
+// ending in /... to include subdirectories, except testdata, vendor and
+// hidden directories.
+func consumerDirs(consumers []string) ([]string, error) {
+	var dirs []string
+	for _, consumer := range consumers {
+		dir := consumer
+		recurse := strings.HasSuffix(dir, string(os.PathSeparator)+"...")
+		if recurse {
+			dir = strings.TrimSuffix(dir, string(os.PathSeparator)+"...")
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !recurse {
+			dirs = append(dirs, dir)
+			continue
+		}
+		err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() {
+				return nil
+			}
+			if name := fi.Name(); path != dir && (name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			if files, err := filepath.Glob(filepath.Join(path, "*.go")); err == nil && len(files) > 0 {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
+
+// typeErr is a type checker error, identified by its position and message.
+type typeErr struct {
+	pos token.Pos
+	msg string
+}
+
+// consumerImpact type checks the consumer package in dir against both
+// revisions, attributing the errors only found after to the breaking
+// declarations. Packages are found using bctx, and files are selected for its
+// platform.
+func consumerImpact(vcs VCS, bctx build.Context, dir, beforeRev, afterRev string, breaking map[string]map[string]bool) ([]Impact, error) {
+	bpkg, err := bctx.ImportDir(dir, 0)
+	if err != nil {
+		if _, ok := err.(*build.NoGoError); ok {
+			return nil, nil
+		}
+		return nil, &ParseError{Rev: revisionFS, Pkg: dir, Err: err}
+	}
+	importPath := dir
+	if path, err := importPathTo(dir, bctx.GOPATH); err == nil {
+		importPath = filepath.ToSlash(path)
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range bpkg.GoFiles {
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, &ParseError{Rev: revisionFS, Pkg: importPath, File: name, Err: err}
+		}
+		files = append(files, file)
+	}
+
+	// The same files are checked against each revision, function bodies are
+	// checked as they contain most uses
+	check := func(rev string, info *types.Info) (*types.Package, map[typeErr]bool) {
+		errs := make(map[typeErr]bool)
+		imp := newVCSImporter(vcs, rev, bctx)
+		imp.lenient, imp.fs = true, true
+		conf := &types.Config{
+			Importer: imp.forDir(dir),
+			Error: func(err error) {
+				if terr, ok := err.(types.Error); ok && !terr.Soft {
+					errs[typeErr{pos: terr.Pos, msg: terr.Msg}] = true
+				}
+			},
+		}
+		pkg, _ := conf.Check(importPath, fset, files, info)
+		return pkg, errs
+	}
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	ainfo := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+	}
+	bpkgTypes, berrs := check(beforeRev, info)
+	apkgTypes, aerrs := check(afterRev, ainfo)
+	var (
+		bifaces = breakingInterfaces(bpkgTypes, breaking)
+		aifaces = breakingInterfaces(apkgTypes, breaking)
+	)
+
+	var impacts []Impact
+	for aerr := range aerrs {
+		if berrs[aerr] {
+			continue
+		}
+		impact := Impact{Consumer: importPath, Pos: fset.Position(aerr.pos), Kind: ImpactReference, Msg: aerr.msg}
+		for _, file := range files {
+			if file.Pos() <= aerr.pos && aerr.pos <= file.End() {
+				path := enclosing(file, aerr.pos)
+				if pkg, id, ok := implementationBreak(path, aerr.pos, info, ainfo, bifaces, aifaces); ok {
+					impact.Pkg, impact.ID, impact.Kind = pkg, id, ImpactImplementation
+				} else {
+					attributeImpact(&impact, path, info, aerr, breaking)
+				}
+				break
+			}
+		}
+		impacts = append(impacts, impact)
+	}
+	sort.Slice(impacts, func(i, j int) bool {
+		if impacts[i].Pos.Filename != impacts[j].Pos.Filename {
+			return impacts[i].Pos.Filename < impacts[j].Pos.Filename
+		}
+		if impacts[i].Pos.Line != impacts[j].Pos.Line {
+			return impacts[i].Pos.Line < impacts[j].Pos.Line
+		}
+		return impacts[i].Pos.Column < impacts[j].Pos.Column
+	})
+	return impacts, nil
+}
+
+// enclosing returns the nodes in file enclosing pos, outermost first.
+func enclosing(file *ast.File, pos token.Pos) []ast.Node {
+	var path []ast.Node
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil || n.Pos() > pos || pos > n.End() {
+			return false
+		}
+		path = append(path, n)
+		return true
+	})
+	return path
+}
+
+// ifaceKey identifies a breaking interface by its package and Change.ID.
+type ifaceKey struct {
+	pkg, id string
+}
+
+// breakingInterfaces returns the types of the breaking interfaces declared in
+// the packages imported, directly or indirectly, by pkg.
+func breakingInterfaces(pkg *types.Package, breaking map[string]map[string]bool) map[ifaceKey]types.Type {
+	ifaces := make(map[ifaceKey]types.Type)
+	if pkg == nil {
+		return ifaces
+	}
+	seen := make(map[*types.Package]bool)
+	var visit func(*types.Package)
+	visit = func(pkg *types.Package) {
+		if seen[pkg] {
+			return
+		}
+		seen[pkg] = true
+		for id := range breaking[pkg.Path()] {
+			if tname, ok := pkg.Scope().Lookup(id).(*types.TypeName); ok && types.IsInterface(tname.Type()) {
+				ifaces[ifaceKey{pkg: pkg.Path(), id: id}] = tname.Type()
+			}
+		}
+		for _, imp := range pkg.Imports() {
+			visit(imp)
+		}
+	}
+	visit(pkg)
+	return ifaces
+}
+
+// implementationBreak returns the breaking interface which an expression
+// starting at pos implements before but not after, using the expressions'
+// types in binfo and ainfo. If the expression no longer implements multiple
+// breaking interfaces, the interface it's assigned or passed to is returned.
+func implementationBreak(path []ast.Node, pos token.Pos, binfo, ainfo *types.Info, bifaces, aifaces map[ifaceKey]types.Type) (pkg, id string, ok bool) {
+	for i := len(path) - 1; i > 0; i-- {
+		expr, isExpr := path[i].(ast.Expr)
+		if !isExpr || expr.Pos() != pos {
+			continue
+		}
+		btv, bok := binfo.Types[expr]
+		atv, aok := ainfo.Types[expr]
+		if !bok || !aok || btv.Type == nil || atv.Type == nil {
+			continue
+		}
+		var broken []ifaceKey
+		for key, aiface := range aifaces {
+			biface, ok := bifaces[key]
+			if ok && types.Implements(btv.Type, biface.Underlying().(*types.Interface)) && !types.Implements(atv.Type, aiface.Underlying().(*types.Interface)) {
+				broken = append(broken, key)
+			}
+		}
+		if len(broken) == 0 {
+			continue
+		}
+		sort.Slice(broken, func(i, j int) bool {
+			if broken[i].pkg != broken[j].pkg {
+				return broken[i].pkg < broken[j].pkg
+			}
+			return broken[i].id < broken[j].id
+		})
+		if target := assignedType(path[:i], expr, ainfo); target != nil {
+			for _, key := range broken {
+				if types.Identical(target, aifaces[key]) {
+					return key.pkg, key.id, true
+				}
+			}
+		}
+		return broken[0].pkg, broken[0].id, true
+	}
+	return "", "", false
+}
+
+// assignedType returns the type expr is assigned, passed or returned as, or
+// nil if unknown, path are the nodes enclosing expr, outermost first.
+func assignedType(path []ast.Node, expr ast.Expr, info *types.Info) types.Type {
+	index := func(exprs []ast.Expr) int {
+		for i, e := range exprs {
+			if e == expr {
+				return i
+			}
+		}
+		return -1
+	}
+	switch parent := path[len(path)-1].(type) {
+	case *ast.ValueSpec:
+		if parent.Type != nil {
+			return info.Types[parent.Type].Type
+		}
+	case *ast.AssignStmt:
+		if i := index(parent.Rhs); i >= 0 && len(parent.Lhs) == len(parent.Rhs) {
+			return info.Types[parent.Lhs[i]].Type
+		}
+	case *ast.CallExpr:
+		sig, ok := info.Types[parent.Fun].Type.(*types.Signature)
+		if i := index(parent.Args); ok && i >= 0 {
+			params := sig.Params()
+			switch {
+			case sig.Variadic() && i >= params.Len()-1:
+				if s, ok := params.At(params.Len() - 1).Type().(*types.Slice); ok {
+					return s.Elem()
+				}
+			case i < params.Len():
+				return params.At(i).Type()
+			}
+		}
+	case *ast.ReturnStmt:
+		i := index(parent.Results)
+		for j := len(path) - 2; j >= 0 && i >= 0; j-- {
+			var sig *types.Signature
+			switch fn := path[j].(type) {
+			case *ast.FuncLit:
+				sig, _ = info.Types[fn].Type.(*types.Signature)
+			case *ast.FuncDecl:
+				if obj := info.Defs[fn.Name]; obj != nil {
+					sig, _ = obj.Type().(*types.Signature)
+				}
+			default:
+				continue
+			}
+			if sig != nil && i < sig.Results().Len() && len(parent.Results) == sig.Results().Len() {
+				return sig.Results().At(i).Type()
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// attributeImpact sets the impact's changed declaration to the breaking
+// declaration used closest to the error, within the innermost node of path
+// enclosing the error which uses any. A declaration is used if it's
+// referenced or it's a named type reachable from a referenced object's type,
+// such as a function's parameter.
+func attributeImpact(impact *Impact, path []ast.Node, info *types.Info, terr typeErr, breaking map[string]map[string]bool) {
+	for i := len(path) - 1; i >= 0; i-- {
+		if spec, ok := path[i].(*ast.ImportSpec); ok {
+			if ipath, err := strconv.Unquote(spec.Path.Value); err == nil && breaking[ipath] != nil {
+				impact.Pkg, impact.Kind = ipath, ImpactImport
+			}
+			return
+		}
+
+		var (
+			found    bool
+			distance token.Pos
+		)
+		for _, direct := range []bool{true, false} {
+			ast.Inspect(path[i], func(n ast.Node) bool {
+				ident, ok := n.(*ast.Ident)
+				if !ok {
+					return true
+				}
+				obj := info.Uses[ident]
+				if obj == nil || obj.Pkg() == nil {
+					return true
+				}
+				pkg, id, ok := breakingUse(obj, direct, breaking)
+				if !ok {
+					return true
+				}
+				d := ident.Pos() - terr.pos
+				if d < 0 {
+					d = -d
+				}
+				if !found || d < distance {
+					found, distance = true, d
+					impact.Pkg, impact.ID = pkg, id
+					if direct {
+						impact.Kind = useKind(obj, ident, path[i])
+					}
+				}
+				return true
+			})
+			if found {
+				return
+			}
+		}
+	}
+}
+
+// breakingUse returns the breaking declaration used by referencing obj. If
+// direct, obj must be the declaration, otherwise a named type reachable from
+// obj's type is used.
+func breakingUse(obj types.Object, direct bool, breaking map[string]map[string]bool) (pkg, id string, ok bool) {
+	if direct {
+		pkg, id = obj.Pkg().Path(), objectID(obj)
+		return pkg, id, id != "" && breaking[pkg][id]
+	}
+
+	seen := make(map[*types.Named]bool)
+	walkType(obj.Type(), func(named *types.Named) bool {
+		if ok || seen[named] || named.Obj().Pkg() == nil {
+			return false
+		}
+		seen[named] = true
+		if npkg, nid := named.Obj().Pkg().Path(), named.Obj().Name(); breaking[npkg][nid] {
+			pkg, id, ok = npkg, nid, true
+		}
+		return !ok
+	})
+	return pkg, id, ok
+}
+
+// objectID returns the declaration ID, as used by Change.ID, of a package
+// level object, method or struct field, or empty if it has none.
+func objectID(obj types.Object) string {
+	scope := obj.Pkg().Scope()
+	switch obj := obj.(type) {
+	case *types.Func:
+		sig, ok := obj.Type().(*types.Signature)
+		if !ok || sig.Recv() == nil {
+			break
+		}
+		recv := sig.Recv().Type()
+		if ptr, ok := recv.(*types.Pointer); ok {
+			recv = ptr.Elem()
+		}
+		named, ok := recv.(*types.Named)
+		if !ok {
+			return ""
+		}
+		if types.IsInterface(named) {
+			// interface methods are part of the interface's declaration
+			return named.Obj().Name()
+		}
+		return named.Obj().Name() + "." + obj.Name()
+	case *types.Var:
+		if !obj.IsField() {
+			break
+		}
+		// fields are part of the declaration of the struct type containing
+		// them
+		for _, name := range scope.Names() {
+			tname, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok {
+				continue
+			}
+			if s, ok := tname.Type().Underlying().(*types.Struct); ok && hasField(s, obj) {
+				return name
+			}
+		}
+		return ""
+	}
+	if obj.Parent() != scope {
+		return ""
+	}
+	return obj.Name()
+}
+
+// hasField returns true if field is declared in s or an anonymous struct
+// within it.
+func hasField(s *types.Struct, field *types.Var) bool {
+	for i := 0; i < s.NumFields(); i++ {
+		f := s.Field(i)
+		if f == field {
+			return true
+		}
+		if inner, ok := f.Type().(*types.Struct); ok && hasField(inner, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// useKind returns the kind of use of obj by ident, within node.
+func useKind(obj types.Object, ident *ast.Ident, node ast.Node) string {
+	switch obj := obj.(type) {
+	case *types.Var:
+		if obj.IsField() {
+			return ImpactField
+		}
+	case *types.Func:
+		if sig, ok := obj.Type().(*types.Signature); ok && sig.Recv() != nil {
+			return ImpactMethod
+		}
+		call := false
+		ast.Inspect(node, func(n ast.Node) bool {
+			if c, ok := n.(*ast.CallExpr); ok {
+				switch fun := c.Fun.(type) {
+				case *ast.Ident:
+					call = call || fun == ident
+				case *ast.SelectorExpr:
+					call = call || fun.Sel == ident
+				}
+			}
+			return !call
+		})
+		if call {
+			return ImpactCall
+		}
+	}
+	return ImpactReference
+}
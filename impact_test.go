@@ -0,0 +1,118 @@
+package apicompat
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestImpact checks a consumer, outside of the checked repository, is only
+// reported for the breaking changes it uses, on each platform.
+func TestImpact(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "lib")
+	testenv.GitCommit(t, repo, map[string]string{
+		"p/p.go": `package p
+
+func F(a int) {}
+
+type T struct{ X, Y int }
+
+func (T) M() {}
+
+type I interface{ M() }
+
+type J interface{ M() }
+
+const Unused = 1
+`,
+	})
+	testenv.GitCommit(t, repo, map[string]string{
+		"p/p.go": `package p
+
+func F(a, b int) {}
+
+type T struct{ Y int }
+
+type I interface {
+	M()
+	N()
+}
+
+type J interface{ M(int) }
+`,
+	})
+
+	// The consumer also imports a package on the file system
+	testenv.WriteFiles(t, filepath.Join(gopath, "src", "example.com"), map[string]string{
+		"util/util.go": "package util\n\nconst One = 1\n",
+		"app/app.go": `package app
+
+import (
+	"example.com/lib/p"
+	"example.com/util"
+)
+
+type impl struct{}
+
+func (impl) M() {}
+
+var _ p.I = impl{}
+
+func use() int {
+	p.F(util.One)
+	var t p.T
+	t.M()
+	return t.X + t.Y
+}
+
+func useJ(j p.J) {
+	j.M()
+}
+
+func takeI(i p.I) {}
+
+func pass() {
+	takeI(impl{})
+}
+`,
+		"win/win.go":         "package win\n",
+		"win/win_windows.go": "package win\n\nimport \"example.com/lib/p\"\n\nvar _ = p.Unused\n",
+	})
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	impacts, err := New(SetVCS(vcs)).Impact(context.Background(), CheckRequest{
+		Patterns:  []string{filepath.Join(repo, "p")},
+		Before:    "HEAD~1",
+		After:     "HEAD",
+		Platforms: []string{"linux/amd64", "windows/amd64"},
+	}, []string{
+		filepath.Join(gopath, "src", "example.com", "app", "..."),
+		filepath.Join(gopath, "src", "example.com", "win"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, impact := range impacts {
+		if (impact.Consumer != "example.com/app" && impact.Consumer != "example.com/win") || impact.Pkg != "example.com/lib/p" {
+			t.Errorf("unexpected impact %v", impact)
+		}
+		got = append(got, impact.ID+" "+impact.Kind)
+	}
+	sort.Strings(got)
+	exp := []string{"F call", "I implementation", "I implementation", "J method", "T field", "T.M method", "Unused reference"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("exp impacts %q got %q: %v", exp, got, impacts)
+	}
+}
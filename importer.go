@@ -0,0 +1,506 @@
+package apicompat
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// vcsImporter is a types.Importer which type checks imported packages from
+// source at a revision, so packages within the same repository are checked
+// as they were at that revision rather than as they are on disk. Packages
+// required by the revision's go.mod are read from the module cache. Any other
+// package, such as the standard library, uses the default importer.
+//
+// A vcsImporter is shared by all packages parsed at a revision and is safe
+// for concurrent use, each import path is resolved once while other paths are
+// resolved concurrently.
+type vcsImporter struct {
+	vcs     VCS
+	rev     string
+	build   build.Context // platform to import for
+	fset    *token.FileSet
+	lenient bool // return partially type checked packages
+	fs      bool // import packages outside the VCS from the file system
+
+	mu      sync.Mutex              // protects imports
+	imports map[string]*importEntry // import path -> import, done or in progress
+
+	modMu sync.Mutex        // protects mods
+	mods  map[string]*goMod // directory -> go.mod found from directory
+
+	fallbackMu sync.Mutex // the default importer isn't safe for concurrent use
+	fallback   types.Importer
+
+	diagMu sync.Mutex   // protects diags
+	diags  []Diagnostic // type errors tolerated in lenient mode
+}
+
+// importEntry is an import of a path, done is closed once pkg and err are set.
+type importEntry struct {
+	done  chan struct{}
+	pkg   *types.Package
+	err   error
+	owner *importChain // chain resolving the import
+}
+
+// importChain is a chain of nested imports, from a package being checked to
+// the import currently being resolved, used to detect import cycles.
+type importChain struct {
+	waiting *importEntry // import being waited on, protected by vcsImporter.mu
+}
+
+// errCgo is returned by importDir for packages using cgo, which are left to
+// the default importer.
+var errCgo = errors.New("package uses cgo")
+
+// newVCSImporter returns a vcsImporter for revision rev, files are selected
+// using the platform of bctx.
+func newVCSImporter(vcs VCS, rev string, bctx build.Context) *vcsImporter {
+	imp := &vcsImporter{
+		vcs:      vcs,
+		rev:      rev,
+		build:    bctx,
+		fset:     token.NewFileSet(),
+		imports:  make(map[string]*importEntry),
+		mods:     make(map[string]*goMod),
+		fallback: importer.Default(),
+	}
+	if bctx.GOOS != runtime.GOOS || bctx.GOARCH != runtime.GOARCH {
+		// The default importer reads the host's export data
+		imp.fallback = importer.ForCompiler(imp.fset, "gc", platformExports(bctx.GOOS, bctx.GOARCH))
+	}
+	return imp
+}
+
+// platformExports returns a lookup function for the gc importer, which opens
+// the export data of a package compiled for GOOS goos and GOARCH goarch.
+func platformExports(goos, goarch string) func(path string) (io.ReadCloser, error) {
+	return func(path string) (io.ReadCloser, error) {
+		cmd := exec.Command("go", "list", "-export", "-f", "{{.Export}}", path)
+		cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("could not find export data for %s on %s/%s: %v: %s", path, goos, goarch, err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		export := strings.TrimSpace(string(out))
+		if export == "" {
+			return nil, fmt.Errorf("no export data for %s on %s/%s", path, goos, goarch)
+		}
+		return os.Open(export)
+	}
+}
+
+// dirImporter is a types.ImporterFrom which resolves imports relative to the
+// directory of the package being checked, as go/types only knows the file
+// names which are prefixed with the revision.
+type dirImporter struct {
+	imp   *vcsImporter
+	dir   string
+	chain *importChain // nil for a package being checked
+}
+
+// Import implements types.Importer.
+func (d dirImporter) Import(path string) (*types.Package, error) {
+	return d.ImportFrom(path, d.dir, 0)
+}
+
+// ImportFrom implements types.ImporterFrom.
+func (d dirImporter) ImportFrom(path, _ string, _ types.ImportMode) (*types.Package, error) {
+	chain := d.chain
+	if chain == nil {
+		chain = &importChain{}
+	}
+	return d.imp.importFrom(path, d.dir, chain)
+}
+
+// forDir returns an importer for the package in directory dir.
+func (imp *vcsImporter) forDir(dir string) types.ImporterFrom {
+	return dirImporter{imp: imp, dir: dir}
+}
+
+// importFrom imports path for the package in directory dir, each path is only
+// imported once, concurrent imports of the same path wait for the first.
+// Failed imports aren't kept, so they're retried by later importers.
+func (imp *vcsImporter) importFrom(path, dir string, chain *importChain) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+
+	imp.mu.Lock()
+	if entry, ok := imp.imports[path]; ok {
+		// Waiting for an import which, directly or via other chains, is
+		// waiting for this chain would never finish
+		for owner := entry.owner; owner != nil; owner = owner.waiting.owner {
+			if owner == chain {
+				imp.mu.Unlock()
+				return nil, fmt.Errorf("import cycle via %s", path)
+			}
+			if owner.waiting == nil {
+				break
+			}
+		}
+		chain.waiting = entry
+		imp.mu.Unlock()
+
+		<-entry.done
+
+		imp.mu.Lock()
+		chain.waiting = nil
+		imp.mu.Unlock()
+		return entry.pkg, entry.err
+	}
+	entry := &importEntry{done: make(chan struct{}), owner: chain}
+	imp.imports[path] = entry
+	imp.mu.Unlock()
+
+	entry.pkg, entry.err = imp.resolve(path, dir, chain)
+
+	imp.mu.Lock()
+	entry.owner = nil
+	if entry.err != nil {
+		delete(imp.imports, path)
+	}
+	imp.mu.Unlock()
+	close(entry.done)
+	return entry.pkg, entry.err
+}
+
+// resolve finds and type checks an import path. Packages found in the VCS
+// return their errors, rather than falling back to a different version of the
+// package.
+func (imp *vcsImporter) resolve(path, dir string, chain *importChain) (*types.Package, error) {
+	// Module mode, the main module is read from the VCS and requirements from
+	// the module cache
+	if mod := imp.goMod(dir); mod != nil {
+		if pkgDir, rev, ok := mod.dir(path, imp.rev); ok {
+			pkg, err := imp.importDir(path, pkgDir, rev, chain)
+			if err == nil || (rev == imp.rev && err != errCgo) {
+				return pkg, err
+			}
+		}
+	}
+
+	// GOPATH mode, packages that exist at the revision are read from the VCS
+	ctx := imp.buildContext(imp.rev)
+	if bpkg, err := ctx.Import(path, dir, build.FindOnly|build.IgnoreVendor); err == nil && !bpkg.Goroot {
+		if files, err := imp.readDir(imp.rev, bpkg.Dir); err == nil && hasGoFiles(files) {
+			pkg, err := imp.importDir(path, bpkg.Dir, imp.rev, chain)
+			if err != errCgo {
+				return pkg, err
+			}
+		}
+	}
+
+	// Packages outside the VCS, such as the other imports of a consumer
+	if imp.fs && imp.rev != revisionFS {
+		fsctx := imp.buildContext(revisionFS)
+		if bpkg, err := fsctx.Import(path, dir, build.FindOnly); err == nil && !bpkg.Goroot {
+			if pkg, err := imp.importDir(path, bpkg.Dir, revisionFS, chain); err == nil {
+				return pkg, nil
+			}
+		}
+	}
+
+	imp.fallbackMu.Lock()
+	defer imp.fallbackMu.Unlock()
+	return imp.fallback.Import(path)
+}
+
+// diagnostics returns the type errors of imported packages, tolerated in
+// lenient mode.
+func (imp *vcsImporter) diagnostics() []Diagnostic {
+	imp.diagMu.Lock()
+	defer imp.diagMu.Unlock()
+	return append([]Diagnostic(nil), imp.diags...)
+}
+
+// hasGoFiles returns true if any of files is a Go source file.
+func hasGoFiles(files []os.FileInfo) bool {
+	for _, fi := range files {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".go") {
+			return true
+		}
+	}
+	return false
+}
+
+// readDir reads directory dir at rev, or from the file system for revisionFS.
+func (imp *vcsImporter) readDir(rev, dir string) ([]os.FileInfo, error) {
+	if rev == revisionFS {
+		return ioutil.ReadDir(dir)
+	}
+	return imp.vcs.ReadDir(rev, dir)
+}
+
+// openFile opens path at rev, or from the file system for revisionFS.
+func (imp *vcsImporter) openFile(rev, path string) (io.ReadCloser, error) {
+	if rev == revisionFS {
+		return os.Open(path)
+	}
+	return imp.vcs.OpenFile(rev, path)
+}
+
+// buildContext returns a go/build context reading files at rev.
+func (imp *vcsImporter) buildContext(rev string) build.Context {
+	ctx := imp.build
+	ctx.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		return imp.readDir(rev, dir)
+	}
+	ctx.OpenFile = func(path string) (io.ReadCloser, error) {
+		return imp.openFile(rev, path)
+	}
+	ctx.IsDir = func(path string) bool {
+		if files, err := imp.readDir(rev, path); err == nil && len(files) > 0 {
+			return true
+		}
+		fi, err := os.Stat(path)
+		return err == nil && fi.IsDir()
+	}
+	return ctx
+}
+
+// envGOPATH returns $GOPATH, which may have changed since go/build read it, or
+// the default GOPATH if it's unset.
+func envGOPATH() string {
+	if gopath := os.Getenv("GOPATH"); gopath != "" {
+		return gopath
+	}
+	return build.Default.GOPATH
+}
+
+// importDir type checks the package path from source in directory dir at
+// revision rev.
+func (imp *vcsImporter) importDir(path, dir, rev string, chain *importChain) (*types.Package, error) {
+	ctx := imp.buildContext(rev)
+	bpkg, err := ctx.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(bpkg.CgoFiles) > 0 {
+		// cgo requires preprocessing, leave it to the default importer
+		return nil, errCgo
+	}
+
+	var files []*ast.File
+	for _, name := range bpkg.GoFiles {
+		rc, err := imp.openFile(rev, filepath.Join(bpkg.Dir, name))
+		if err != nil {
+			return nil, err
+		}
+		file, err := parser.ParseFile(imp.fset, filepath.Join(bpkg.Dir, name), rc, 0)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	conf := &types.Config{
+		IgnoreFuncBodies:         true,
+		DisableUnusedImportCheck: true,
+		Importer:                 dirImporter{imp: imp, dir: bpkg.Dir, chain: chain},
+	}
+	if imp.lenient {
+		// the errors are recorded as diagnostics, and packages importing this
+		// one, directly or transitively, are low confidence
+		conf.Error = func(err error) {
+			terr := &TypeCheckError{Rev: rev, Pkg: path, Msg: err.Error(), Err: err}
+			if e, ok := err.(types.Error); ok {
+				terr.Pos = imp.fset.Position(e.Pos)
+				terr.File = terr.Pos.Filename
+				terr.Msg = e.Msg
+			}
+			imp.diagMu.Lock()
+			imp.diags = append(imp.diags, Diagnostic{Pkg: path, Rev: rev, Pos: terr.Pos, Msg: terr.Msg, Err: terr})
+			imp.diagMu.Unlock()
+		}
+		pkg, _ := conf.Check(path, imp.fset, files, nil)
+		return pkg, nil
+	}
+	return conf.Check(path, imp.fset, files, nil)
+}
+
+// goMod returns the go.mod at rev in dir or its closest parent, or nil if
+// there is none.
+func (imp *vcsImporter) goMod(dir string) *goMod {
+	imp.modMu.Lock()
+	defer imp.modMu.Unlock()
+	return imp.findGoMod(dir)
+}
+
+// findGoMod implements goMod, imp.modMu must be held.
+func (imp *vcsImporter) findGoMod(dir string) *goMod {
+	mod, ok := imp.mods[dir]
+	if ok {
+		return mod
+	}
+
+	if rc, err := imp.vcs.OpenFile(imp.rev, filepath.Join(dir, "go.mod")); err == nil {
+		mod, err = parseGoMod(rc)
+		rc.Close()
+		if err == nil {
+			mod.root = dir
+		}
+	} else if parent := filepath.Dir(dir); parent != dir {
+		mod = imp.findGoMod(parent)
+	}
+
+	imp.mods[dir] = mod
+	return mod
+}
+
+// goMod is the subset of a go.mod file used to locate imports.
+type goMod struct {
+	root     string            // directory containing go.mod
+	path     string            // module path
+	require  map[string]string // module path -> version
+	replace  map[string]string // module path -> replacement, "path@version" or a directory
+	modCache string
+}
+
+// parseGoMod parses the module, require and replace directives of a go.mod.
+func parseGoMod(r io.Reader) (*goMod, error) {
+	mod := &goMod{
+		require:  make(map[string]string),
+		replace:  make(map[string]string),
+		modCache: moduleCache(),
+	}
+
+	var block string // directive of the current ( ) block
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		directive := block
+		switch {
+		case fields[0] == ")":
+			block = ""
+			continue
+		case block == "" && len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+			continue
+		case block == "":
+			directive, fields = fields[0], fields[1:]
+		}
+
+		switch directive {
+		case "module":
+			if len(fields) > 0 {
+				mod.path = strings.Trim(fields[0], `"`)
+			}
+		case "require":
+			if len(fields) >= 2 {
+				mod.require[fields[0]] = fields[1]
+			}
+		case "replace":
+			// old [version] => new [version]
+			for i, f := range fields {
+				if f != "=>" || i+1 >= len(fields) {
+					continue
+				}
+				replacement := fields[i+1]
+				if i+2 < len(fields) {
+					replacement += "@" + fields[i+2]
+				}
+				mod.replace[fields[0]] = replacement
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if mod.path == "" {
+		return nil, fmt.Errorf("go.mod has no module directive")
+	}
+	return mod, nil
+}
+
+// dir returns the directory and revision to read import path from, the main
+// module is read at rev, while other modules are read from the module cache
+// on the file system.
+func (m *goMod) dir(path, rev string) (dir, dirRev string, ok bool) {
+	if path == m.path || strings.HasPrefix(path, m.path+"/") {
+		return filepath.Join(m.root, filepath.FromSlash(strings.TrimPrefix(path, m.path))), rev, true
+	}
+
+	// Longest required module which contains path
+	var modPath string
+	for req := range m.require {
+		if (path == req || strings.HasPrefix(path, req+"/")) && len(req) > len(modPath) {
+			modPath = req
+		}
+	}
+	if modPath == "" {
+		return "", "", false
+	}
+	sub := filepath.FromSlash(strings.TrimPrefix(path, modPath))
+
+	modVersion := modPath + "@" + m.require[modPath]
+	if replacement, ok := m.replace[modPath]; ok {
+		if strings.HasPrefix(replacement, ".") || filepath.IsAbs(replacement) {
+			// directory replacement, relative to the main module
+			if !filepath.IsAbs(replacement) {
+				replacement = filepath.Join(m.root, replacement)
+			}
+			return filepath.Join(replacement, sub), rev, true
+		}
+		modVersion = replacement
+	}
+	if m.modCache == "" {
+		return "", "", false
+	}
+	return filepath.Join(m.modCache, escapeModulePath(modVersion), sub), revisionFS, true
+}
+
+// moduleCache returns the module cache directory, GOMODCACHE or the first
+// GOPATH's pkg/mod.
+func moduleCache() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	gopaths := filepath.SplitList(build.Default.GOPATH)
+	if len(gopaths) == 0 {
+		return ""
+	}
+	return filepath.Join(gopaths[0], "pkg", "mod")
+}
+
+// escapeModulePath escapes a module path for the module cache, by replacing
+// upper case letters with an exclamation mark followed by the lower case.
+func escapeModulePath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return filepath.FromSlash(b.String())
+}
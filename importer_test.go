@@ -0,0 +1,162 @@
+package apicompat
+
+import (
+	"context"
+	"go/build"
+	"go/types"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestImporter checks a package whose API depends on the type of a constant
+// declared in another package, which changes between revisions. The change
+// is only detected if the dependency is type checked at each revision.
+func TestImporter(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after map[string]string // files committed to example.com/imp
+		modCache      map[string]string // files in the module cache
+	}{
+		{
+			name: "gopath",
+			before: map[string]string{
+				"d/d.go": "package d\n\nimport \"example.com/imp/e\"\n\nvar V = e.C\n",
+				"e/e.go": "package e\n\nconst C int = 1\n",
+			},
+			after: map[string]string{
+				"e/e.go": "package e\n\nconst C uint = 1\n",
+			},
+		},
+		{
+			name: "module",
+			before: map[string]string{
+				"go.mod": "module example.com/imp\n\nrequire (\n\texample.com/Dep v1.0.0\n)\n",
+				"d/d.go": "package d\n\nimport \"example.com/Dep/sub\"\n\nvar V = sub.C\n",
+			},
+			after: map[string]string{
+				"go.mod": "module example.com/imp\n\nrequire example.com/Dep v1.1.0 // indirect\n",
+			},
+			modCache: map[string]string{
+				"example.com/!dep@v1.0.0/sub/sub.go": "package sub\n\nconst C int = 1\n",
+				"example.com/!dep@v1.1.0/sub/sub.go": "package sub\n\nconst C uint = 1\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		gopath := testenv.GOPATH(t)
+		testenv.WriteFiles(t, filepath.Join(gopath, "pkg", "mod"), test.modCache)
+
+		repo := filepath.Join(gopath, "src", "example.com", "imp")
+		testenv.GitCommit(t, repo, test.before)
+		testenv.GitCommit(t, repo, test.after)
+
+		for name, newVCS := range map[string]func(string) (VCS, error){
+			"git":    func(path string) (VCS, error) { return NewGit(path) },
+			"native": func(path string) (VCS, error) { return NewNativeGit(path) },
+		} {
+			vcs, err := newVCS(repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes, err := New(SetVCS(vcs)).CheckPackages(context.Background(), CheckRequest{
+				Patterns: []string{filepath.Join(repo, "d")}, Before: "HEAD~1", After: "HEAD",
+			})
+			if cerr := vcs.(io.Closer).Close(); cerr != nil {
+				t.Errorf("%s %s: unexpected error closing: %v", test.name, name, cerr)
+			}
+			if err != nil {
+				t.Errorf("%s %s: unexpected error: %v", test.name, name, err)
+				continue
+			}
+			if len(changes) != 1 || changes[0].Change != Breaking || changes[0].ID != "V" {
+				t.Errorf("%s %s: exp 1 breaking change to V got %v", test.name, name, changes)
+			}
+		}
+	}
+}
+
+// TestImporterErrors checks concurrent imports share each package, and that
+// type errors and import cycles in the VCS are returned rather than falling
+// back to the default importer.
+func TestImporterErrors(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+	repo := filepath.Join(gopath, "src", "example.com", "imperr")
+	testenv.GitCommit(t, repo, map[string]string{
+		"ok/ok.go":     "package ok\n\nimport \"example.com/imperr/shared\"\n\nvar V = shared.C\n",
+		"shared/s.go":  "package shared\n\nconst C = 1\n",
+		"broken/b.go":  "package broken\n\nvar V int = \"string\"\n",
+		"cycle/a/a.go": "package a\n\nimport \"example.com/imperr/cycle/b\"\n\nvar V = b.V\n",
+		"cycle/b/b.go": "package b\n\nimport \"example.com/imperr/cycle/a\"\n\nvar V = a.V\n",
+	})
+
+	vcs, err := NewNativeGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	ctx := build.Default
+	ctx.GOPATH = gopath
+	imp := newVCSImporter(vcs, "HEAD", ctx).forDir(repo)
+
+	var wg sync.WaitGroup
+	pkgs := make([]*types.Package, 8)
+	for i := range pkgs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pkg, err := imp.ImportFrom("example.com/imperr/ok", repo, 0)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			pkgs[i] = pkg.Imports()[0]
+		}(i)
+	}
+	wg.Wait()
+	for _, pkg := range pkgs[1:] {
+		if pkg != pkgs[0] {
+			t.Errorf("exp the shared package to be imported once")
+		}
+	}
+
+	tests := []struct {
+		path string
+		exp  string
+	}{
+		{"example.com/imperr/broken", "cannot use"},
+		{"example.com/imperr/cycle/a", "import cycle"},
+	}
+	for _, test := range tests {
+		if _, err := imp.ImportFrom(test.path, repo, 0); err == nil || !strings.Contains(err.Error(), test.exp) {
+			t.Errorf("%s: exp error containing %q got %v", test.path, test.exp, err)
+		}
+	}
+}
+
+// TestImporterPlatform checks the standard library is imported for the
+// platform being checked, rather than the host's.
+func TestImporterPlatform(t *testing.T) {
+	ctx := build.Default
+	ctx.GOOS, ctx.GOARCH = "windows", "amd64"
+	if runtime.GOOS == ctx.GOOS {
+		ctx.GOOS = "linux"
+	}
+
+	imp := newVCSImporter(&StrVCS{}, "rev1", ctx).forDir("")
+	pkg, err := imp.ImportFrom("syscall", "", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// declared by only one of the platforms
+	exp := map[string]string{"windows": "UTF16FromString", "linux": "Getpgrp"}[ctx.GOOS]
+	if pkg.Scope().Lookup(exp) == nil {
+		t.Errorf("exp syscall.%s for %s/%s", exp, ctx.GOOS, ctx.GOARCH)
+	}
+}
@@ -0,0 +1,126 @@
+// Package testenv provides helpers for tests which check repositories
+// committed to a temporary GOPATH.
+package testenv
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Setenv sets the environment variable key to value until the test and its
+// cleanups finish, then restores it, unsetting it if it was unset.
+func Setenv(t testing.TB, key, value string) {
+	t.Helper()
+	old, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// TempDir returns a temporary directory, removed when the test finishes.
+func TempDir(t testing.TB) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "apicompat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// GOPATH sets GOPATH to a temporary directory, and GOMODCACHE to its module
+// cache, until the test finishes and returns the directory.
+func GOPATH(t testing.TB) string {
+	t.Helper()
+	gopath := TempDir(t)
+	Setenv(t, "GOPATH", gopath)
+	Setenv(t, "GOMODCACHE", filepath.Join(gopath, "pkg", "mod"))
+	return gopath
+}
+
+// WriteFiles writes files, by path relative to dir, creating any parent
+// directories.
+func WriteFiles(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// CopyDir copies the files and directories in src to dst, creating dst.
+func CopyDir(t testing.TB, dst, src string) {
+	t.Helper()
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, contents, info.Mode().Perm())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Git runs git with args in dir, returning its trimmed output.
+func Git(t testing.TB, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v output: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// GitInit initialises a repository in dir, creating dir if required.
+func GitInit(t testing.TB, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	Git(t, dir, "init")
+	Git(t, dir, "config", "--local", "user.name", "testdata")
+	Git(t, dir, "config", "--local", "user.email", "testdata@example.com")
+}
+
+// GitCommit writes files into the repository in dir and commits them,
+// initialising the repository if required.
+func GitCommit(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		GitInit(t, dir)
+	}
+	WriteFiles(t, dir, files)
+	Git(t, dir, "add", ".")
+	Git(t, dir, "commit", "-m", "commit")
+}
@@ -0,0 +1,176 @@
+package apicompat
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestLenient checks a package failing to type check at one revision is still
+// compared in lenient mode, with its changes marked as low confidence.
+func TestLenient(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "lenient")
+	testenv.GitCommit(t, repo, map[string]string{
+		"p/p.go": "package p\n\nfunc F(a int) {}\n\nvar V = generated\n",
+		"q/q.go": "package q\n\nfunc F() {}\n",
+	})
+	testenv.GitCommit(t, repo, map[string]string{
+		"p/p.go": "package p\n\nfunc F(a, b int) {}\n\nvar V = generated\n",
+		"q/q.go": "package q\n",
+	})
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	req := CheckRequest{
+		Patterns: []string{filepath.Join(repo, "p"), filepath.Join(repo, "q")}, Before: "HEAD~1", After: "HEAD",
+	}
+
+	if _, err := New(SetVCS(vcs)).CheckPackages(context.Background(), req); !errors.As(err, new(*TypeCheckError)) {
+		t.Errorf("strict: exp *TypeCheckError got %T %v", err, err)
+	}
+
+	report, err := New(SetVCS(vcs), SetLenient(true)).CheckReport(context.Background(), req)
+	if err != nil {
+		t.Fatalf("lenient: unexpected error: %v", err)
+	}
+
+	confidence := make(map[string]bool) // pkg.ID -> low confidence
+	for _, change := range report.Changes {
+		if change.Change == Breaking {
+			confidence[change.Pkg+"."+change.ID] = change.LowConfidence
+		}
+	}
+	exp := map[string]bool{"example.com/lenient/p.F": true, "example.com/lenient/q.F": false}
+	for id, low := range exp {
+		if got, ok := confidence[id]; !ok || got != low {
+			t.Errorf("lenient: exp breaking change to %s with low confidence %v, got %v", id, low, report.Changes)
+		}
+	}
+
+	// The error is in both revisions
+	if len(report.Diagnostics) != 2 {
+		t.Fatalf("lenient: exp 2 diagnostics got %v", report.Diagnostics)
+	}
+	for _, diag := range report.Diagnostics {
+		if diag.Pkg != "example.com/lenient/p" || filepath.Base(diag.Pos.Filename) != "p.go" || diag.Pos.Line != 5 {
+			t.Errorf("lenient: unexpected diagnostic %v", diag)
+		}
+	}
+}
+
+// TestLenientImports checks changes to a package are low confidence when it
+// imports, directly or transitively, a package with type errors.
+func TestLenientImports(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "lenimp")
+	testenv.GitCommit(t, repo, map[string]string{
+		"dep/d.go":   "package dep\n\nconst C = 1\n\nvar X int = \"string\"\n",
+		"mid/m.go":   "package mid\n\nimport \"example.com/lenimp/dep\"\n\nconst C = dep.C\n",
+		"use/u.go":   "package use\n\nimport \"example.com/lenimp/mid\"\n\nfunc F(a int) int { return mid.C }\n",
+		"other/o.go": "package other\n\nfunc F(a int) {}\n",
+	})
+	testenv.GitCommit(t, repo, map[string]string{
+		"use/u.go":   "package use\n\nimport \"example.com/lenimp/mid\"\n\nfunc F(a, b int) int { return mid.C }\n",
+		"other/o.go": "package other\n\nfunc F(a, b int) {}\n",
+	})
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	req := CheckRequest{
+		Patterns: []string{filepath.Join(repo, "use"), filepath.Join(repo, "other")}, Before: "HEAD~1", After: "HEAD",
+	}
+	report, err := New(SetVCS(vcs), SetLenient(true)).CheckReport(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	confidence := make(map[string]bool) // pkg.ID -> low confidence
+	for _, change := range report.Changes {
+		confidence[change.Pkg+"."+change.ID] = change.LowConfidence
+	}
+	exp := map[string]bool{"example.com/lenimp/use.F": true, "example.com/lenimp/other.F": false}
+	for id, low := range exp {
+		if got, ok := confidence[id]; !ok || got != low {
+			t.Errorf("exp change to %s with low confidence %v, got %v", id, low, report.Changes)
+		}
+	}
+}
+
+// TestLenientErrors checks packages which fail to parse or build, and type
+// errors in imported packages, are diagnostics in lenient mode.
+func TestLenientErrors(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "lenerr")
+	testenv.GitCommit(t, repo, map[string]string{
+		"syntax/s.go": "package syntax\n\nfunc F() {}\n",
+		"build/a.go":  "package build\n\nfunc F() {}\n",
+		"dep/d.go":    "package dep\n\nconst C = 1\n\nvar X int = \"string\"\n",
+		"use/u.go":    "package use\n\nimport \"example.com/lenerr/dep\"\n\nvar V = dep.C\n",
+	})
+	testenv.GitCommit(t, repo, map[string]string{
+		"syntax/s.go": "package syntax\n\nfunc F( {}\n",
+		"build/b.go":  "package other\n",
+	})
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	var patterns []string
+	for _, pkg := range []string{"syntax", "build", "use"} {
+		patterns = append(patterns, filepath.Join(repo, pkg))
+	}
+	req := CheckRequest{Patterns: patterns, Before: "HEAD~1", After: "HEAD"}
+
+	strict := CheckRequest{Patterns: patterns[:1], Before: req.Before, After: req.After}
+	if _, err := New(SetVCS(vcs)).CheckPackages(context.Background(), strict); !errors.As(err, new(*ParseError)) {
+		t.Errorf("strict: exp *ParseError got %T %v", err, err)
+	}
+
+	report, err := New(SetVCS(vcs), SetLenient(true)).CheckReport(context.Background(), req)
+	if err != nil {
+		t.Fatalf("lenient: unexpected error: %v", err)
+	}
+	for _, change := range report.Changes {
+		if change.Change != None {
+			t.Errorf("lenient: exp no changes to packages which failed got %v", change)
+		}
+	}
+
+	got := make(map[string]bool)
+	for _, diag := range report.Diagnostics {
+		got[diag.Pkg] = true
+		switch diag.Pkg {
+		case "example.com/lenerr/syntax", "example.com/lenerr/build":
+			var perr *ParseError
+			if !errors.As(diag.Err, &perr) || diag.Rev != "HEAD" {
+				t.Errorf("lenient: exp *ParseError at HEAD got %v", diag)
+			}
+		case "example.com/lenerr/dep":
+			if !errors.As(diag.Err, new(*TypeCheckError)) || filepath.Base(diag.Pos.Filename) != "d.go" || diag.Pos.Line != 5 {
+				t.Errorf("lenient: exp type error in d.go line 5 got %v", diag)
+			}
+		default:
+			t.Errorf("lenient: unexpected diagnostic %v", diag)
+		}
+	}
+	for _, pkg := range []string{"syntax", "build", "dep"} {
+		if !got["example.com/lenerr/"+pkg] {
+			t.Errorf("lenient: exp diagnostic for %s got %v", pkg, report.Diagnostics)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package apicompat
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// addedDecl is a declaration added to a package, possibly moved from another.
+type addedDecl struct {
+	path string // import path of the package it was added to
+	decl ast.Decl
+}
+
+// moves reports declarations removed from a package and added to another
+// package in the check, with the same ID and shape, as moved. Declarations
+// which keep their shape and forward to a moved declaration, such as an alias
+// of a moved type, are reported as moved and non-breaking, replacing their
+// other changes.
+func moves(b, a map[string]pkg, changes []Change) []Change {
+	var paths []string
+	for path := range a {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// declarations added to each package, by ID
+	added := make(map[string][]addedDecl)
+	for _, path := range paths {
+		for id, decl := range a[path].decls {
+			if _, ok := b[path].decls[id]; !ok {
+				added[id] = append(added[id], addedDecl{path: path, decl: decl})
+			}
+		}
+	}
+
+	index := make(map[[2]string]int) // package and ID -> index of change
+	for i, change := range changes {
+		index[[2]string{change.Pkg, change.ID}] = i
+		if change.Msg != "declaration removed" {
+			continue
+		}
+		bpkg := b[change.Pkg]
+		bDecl := bpkg.decls[change.ID]
+		for _, to := range added[change.ID] {
+			apkg := a[to.path]
+			if to.path == change.Pkg || !sameShape(bpkg, apkg, bDecl, to.decl, nil, movedQualifier(change.Pkg, to.path)) {
+				continue
+			}
+			changes[i].Msg, changes[i].Detail = "declaration moved", "moved to package "+to.path
+			changes[i].After, changes[i].AfterPos, changes[i].AfterFset = to.decl, apkg.position(declPos(to.decl)), apkg.fset
+			changes[i].Fixes = nil
+			if from, ok := a[change.Pkg]; ok {
+				changes[i].Fixes = forwardFixes(bpkg, from, apkg, change.ID, bDecl)
+				if fn, ok := bDecl.(*ast.FuncDecl); ok && fn.Recv != nil {
+					// a method is forwarded by an alias of its receiver
+					if path, _, kind, ok := forwardTarget(from, from.decls[recvName(fn)]); ok && path == to.path && kind == "alias" {
+						changes[i].Change = NonBreaking
+						changes[i].Msg += ", forwarded by alias"
+						changes[i].Detail += ", forwarded by alias"
+					}
+				}
+			}
+			break
+		}
+	}
+
+	for _, path := range paths {
+		bpkg, ok := b[path]
+		if !ok {
+			continue
+		}
+		apkg := a[path]
+		var ids []string
+		for id := range apkg.decls {
+			if _, ok := bpkg.decls[id]; ok {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			bDecl, aDecl := bpkg.decls[id], apkg.decls[id]
+			to, name, kind, ok := forwardTarget(apkg, aDecl)
+			if !ok || to == path {
+				continue
+			}
+			var target ast.Decl
+			for _, added := range added[name] {
+				if added.path == to {
+					target = added.decl
+				}
+			}
+			qf := movedQualifier(path, to)
+			if target == nil || !sameShape(bpkg, a[to], bDecl, target, nil, qf) {
+				continue
+			}
+			if kind != "alias" && !sameShape(bpkg, apkg, bDecl, aDecl, nil, qf) {
+				// the forwarding declaration changed, such as a wrapper
+				// with different parameters
+				continue
+			}
+
+			moved := Change{
+				Pkg: path, ID: id, Change: NonBreaking,
+				Msg: "declaration moved, forwarded by " + kind, Detail: fmt.Sprintf("moved to package %s, forwarded by %s", to, kind),
+				Pos: pos(apkg.fset, declPos(aDecl)), Before: bDecl, After: aDecl,
+				BeforePos: bpkg.position(declPos(bDecl)), AfterPos: apkg.position(declPos(aDecl)),
+				BeforeFset: bpkg.fset, AfterFset: apkg.fset, LowConfidence: len(bpkg.diags) > 0 || len(apkg.diags) > 0,
+			}
+			if i, ok := index[[2]string{path, id}]; ok {
+				changes[i] = moved
+				continue
+			}
+			changes = append(changes, moved)
+		}
+	}
+	return changes
+}
+
+// movedQualifier names types by their package's import path, naming package
+// to as from, so declarations moved between them have the same types.
+func movedQualifier(from, to string) types.Qualifier {
+	return func(p *types.Package) string {
+		if p.Path() == to {
+			return from
+		}
+		return p.Path()
+	}
+}
+
+// forwardTarget returns the import path and name of the declaration in
+// another package decl forwards to, and how: as an alias of a type, a constant
+// of a constant, or a function whose body only calls the other, passing its
+// parameters unchanged.
+func forwardTarget(p pkg, decl ast.Decl) (path, name, kind string, ok bool) {
+	switch d := decl.(type) {
+	case *ast.GenDecl:
+		switch s := d.Specs[0].(type) {
+		case *ast.TypeSpec:
+			if s.Assign.IsValid() {
+				path, name, ok = p.qualifiedName(s.Type)
+				return path, name, "alias", ok
+			}
+		case *ast.ValueSpec:
+			if d.Tok == token.CONST && len(s.Values) == 1 {
+				path, name, ok = p.qualifiedName(s.Values[0])
+				return path, name, "constant", ok
+			}
+		}
+	case *ast.FuncDecl:
+		body := p.bodies[d]
+		if d.Recv != nil || body == nil || len(body.List) != 1 {
+			return "", "", "", false
+		}
+		var expr ast.Expr
+		switch stmt := body.List[0].(type) {
+		case *ast.ReturnStmt:
+			if len(stmt.Results) == 1 {
+				expr = stmt.Results[0]
+			}
+		case *ast.ExprStmt:
+			expr = stmt.X
+		}
+		if call, isCall := expr.(*ast.CallExpr); isCall && passesParams(d.Type, call) {
+			path, name, ok = p.qualifiedName(call.Fun)
+			return path, name, "wrapper", ok
+		}
+	}
+	return "", "", "", false
+}
+
+// passesParams returns true if call's arguments are the parameters of fn, in
+// order, with a variadic parameter passed with an ellipsis.
+func passesParams(fn *ast.FuncType, call *ast.CallExpr) bool {
+	var (
+		params   []string
+		variadic bool
+	)
+	for _, field := range fn.Params.List {
+		if len(field.Names) == 0 {
+			return false
+		}
+		for _, name := range field.Names {
+			params = append(params, name.Name)
+		}
+		_, variadic = field.Type.(*ast.Ellipsis)
+	}
+	if len(call.Args) != len(params) || call.Ellipsis.IsValid() != variadic {
+		return false
+	}
+	for i, arg := range call.Args {
+		ident, ok := arg.(*ast.Ident)
+		if !ok || ident.Name != params[i] || ident.Name == "_" {
+			return false
+		}
+	}
+	return true
+}
+
+// qualifiedName returns the import path and name of expr, if it's a qualified
+// identifier such as pkg.Name.
+func (p pkg) qualifiedName(expr ast.Expr) (path, name string, ok bool) {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return "", "", false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return "", "", false
+	}
+	if pkgName, ok := p.info.Uses[x].(*types.PkgName); ok {
+		return pkgName.Imported().Path(), sel.Sel.Name, true
+	}
+	if p.tpkg != nil {
+		// function bodies aren't type checked, so match by package name
+		for _, imp := range p.tpkg.Imports() {
+			if imp.Name() == x.Name {
+				return imp.Path(), sel.Sel.Name, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// forwardFixes returns a fix forwarding the declaration id, moved from package
+// from to package to, such as an alias of a type or a function calling the
+// moved function.
+func forwardFixes(bpkg, from, to pkg, id string, bDecl ast.Decl) []SuggestedFix {
+	if to.tpkg == nil {
+		return nil
+	}
+	edit, ok := appendEdit(bpkg, from, bDecl, "")
+	if !ok {
+		return nil
+	}
+	qual, imp, ok := fileImport(from, edit.Filename, to.importPath, to.tpkg.Name())
+	if !ok {
+		return nil
+	}
+
+	var (
+		name = declName(bDecl)
+		text string
+	)
+	switch d := bDecl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil {
+			// methods move with their type
+			return nil
+		}
+		src, ok := wrapperSource(d, qual+"."+name)
+		if !ok {
+			return nil
+		}
+		text = src
+	case *ast.GenDecl:
+		switch d.Specs[0].(type) {
+		case *ast.TypeSpec:
+			text = fmt.Sprintf("type %s = %s.%s\n", name, qual, name)
+		case *ast.ValueSpec:
+			if d.Tok != token.CONST {
+				// a variable can't forward to another
+				return nil
+			}
+			text = fmt.Sprintf("const %s = %s.%s\n", name, qual, name)
+		}
+	}
+	if text == "" {
+		return nil
+	}
+
+	edit.NewText += deprecated(bpkg, bDecl, fmt.Sprintf("Use %s.%s instead.", qual, name)) + text
+	edits := append(imp, edit)
+	return []SuggestedFix{{Msg: fmt.Sprintf("forward %s to %s.%s", id, qual, name), Edits: edits}}
+}
+
+// fileImport returns the name file filename of p refers to the package with
+// import path as, and edits importing it with the name pkgName if it's not
+// already.
+func fileImport(p pkg, filename, path, pkgName string) (string, []TextEdit, bool) {
+	var src []byte
+	p.fset.Iterate(func(f *token.File) bool {
+		if strings.TrimPrefix(f.Name(), p.rev+":") == filename {
+			src = p.srcs[f.Name()]
+			return false
+		}
+		return true
+	})
+	if src == nil {
+		return "", nil, false
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ImportsOnly)
+	if err != nil {
+		return "", nil, false
+	}
+
+	for _, spec := range file.Imports {
+		if ipath, err := strconv.Unquote(spec.Path.Value); err != nil || ipath != path {
+			continue
+		}
+		switch {
+		case spec.Name == nil:
+			return pkgName, nil, true
+		case spec.Name.Name != "_" && spec.Name.Name != ".":
+			return spec.Name.Name, nil, true
+		}
+	}
+
+	offset := fset.Position(file.Name.End()).Offset
+	edit := TextEdit{Filename: filename, Offset: offset, End: offset, NewText: "\n\nimport " + strconv.Quote(path) + "\n"}
+	return pkgName, []TextEdit{edit}, true
+}
@@ -0,0 +1,143 @@
+package apicompat
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestMoves moves declarations to a new package, some forwarded from their
+// previous package, and checks the fixes forward the others.
+func TestMoves(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "lib")
+	testenv.GitCommit(t, repo, map[string]string{
+		"a/client.go": `package a
+
+// Client is a client.
+type Client struct{ A int }
+
+func (c *Client) Do() error { return nil }
+
+func New() *Client { return &Client{} }
+`,
+		"a/util.go": `package a
+
+// Helper helps.
+func Helper(x int) int { return x }
+
+const Size = 1
+
+const Version = 1
+
+func F(x int) int { return x }
+`,
+	})
+	testenv.GitCommit(t, repo, map[string]string{
+		"a/client.go": `package a
+
+import "example.com/lib/b"
+
+// Client is a client.
+type Client = b.Client
+
+func New() *Client { return b.New() }
+`,
+		"a/util.go": `package a
+
+import "example.com/lib/b"
+
+const Version = 1
+
+// F forwards to b.F, but its parameter changed
+func F(x string) int { return b.F(0) }
+`,
+		"b/b.go": `package b
+
+type Client struct{ A int }
+
+func (c *Client) Do() error { return nil }
+
+func New() *Client { return &Client{} }
+
+func Helper(x int) int { return x }
+
+const Size = 1
+
+func F(x int) int { return x }
+`,
+	})
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	checker := New(SetVCS(vcs), SetReportUnchanged(true))
+	req := CheckRequest{Patterns: []string{repo + "/..."}, Before: "HEAD~1", After: "HEAD"}
+	changes, err := checker.CheckPackages(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type result struct {
+		id, change, msg, detail string
+		fixes                   int
+	}
+	var (
+		got   []result
+		edits = make(map[string][]TextEdit)
+	)
+	for _, change := range changes {
+		if change.Pkg != "example.com/lib/a" || change.Change == None {
+			continue
+		}
+		got = append(got, result{change.ID, change.Change, change.Msg, change.Detail, len(change.Fixes)})
+		for _, fix := range change.Fixes {
+			for _, edit := range fix.Edits {
+				edits[edit.Filename] = append(edits[edit.Filename], edit)
+			}
+		}
+	}
+	exp := []result{
+		{"Client", NonBreaking, "declaration moved, forwarded by alias", "moved to package example.com/lib/b, forwarded by alias", 0},
+		{"Client.Do", NonBreaking, "declaration moved, forwarded by alias", "moved to package example.com/lib/b, forwarded by alias", 0},
+		{"F", Breaking, "parameter types changed", "", 1},
+		{"Helper", Breaking, "declaration moved", "moved to package example.com/lib/b", 1},
+		{"New", NonBreaking, "declaration moved, forwarded by wrapper", "moved to package example.com/lib/b, forwarded by wrapper", 0},
+		{"Size", Breaking, "declaration moved", "moved to package example.com/lib/b", 1},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("exp changes:\n%v\ngot:\n%v", exp, got)
+	}
+
+	for file, fileEdits := range edits {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err = ApplyEdits(src, fileEdits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req.After = "."
+	changes, err = checker.CheckPackages(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error checking fixed packages: %v", err)
+	}
+	for _, change := range changes {
+		if change.Change == Breaking {
+			t.Errorf("unexpected breaking change after fixes: %v", change)
+		}
+	}
+}
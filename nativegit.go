@@ -0,0 +1,925 @@
+package apicompat
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// guarantee at compile time that *NativeGit implements ContextVCS and io.Closer
+var (
+	_ ContextVCS = (*NativeGit)(nil)
+	_ io.Closer  = (*NativeGit)(nil)
+)
+
+// NativeGit implements VCS by reading a git repository's refs and objects
+// directly from the .git directory, without requiring the git binary. Loose
+// and packed objects (including deltas) are supported.
+type NativeGit struct {
+	dir   string // directory of .git
+	base  string // directory containing .git, used to to make paths relative
+	bases *baseCache
+
+	mu    sync.Mutex             // protects packs and revs, not held while reading objects
+	packs []*gitPack             // nil until loaded
+	revs  map[string]*nativeTree // revision -> tree
+}
+
+// nativeTree is the flattened tree of a single revision, it's loaded once by
+// the first read of the revision.
+type nativeTree struct {
+	once  sync.Once
+	err   error
+	files map[string]gitHash       // relative path -> blob
+	dirs  map[string][]os.FileInfo // relative directory -> entries
+}
+
+// defaultBaseCacheSize is the maximum size of the delta bases cached by a
+// NativeGit.
+const defaultBaseCacheSize = 32 << 20
+
+// gitHash is a SHA-1 object name.
+type gitHash [sha1.Size]byte
+
+func (h gitHash) String() string { return hex.EncodeToString(h[:]) }
+
+// gitRawObject is a decompressed object.
+type gitRawObject struct {
+	typ  string // commit, tree, blob or tag
+	data []byte
+}
+
+// NewNativeGit returns a VCS which reads the git repository containing path.
+func NewNativeGit(path string) (*NativeGit, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Search for .git in path or any of its parents
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		gitDir := filepath.Join(dir, ".git")
+		if fi, err := os.Stat(gitDir); err == nil {
+			if !fi.IsDir() {
+				// worktrees and submodules use a file pointing to the git dir
+				if gitDir, err = readGitFile(gitDir); err != nil {
+					return nil, &VCSError{VCS: "git", Op: "open", Path: path, Err: err}
+				}
+			}
+			return &NativeGit{
+				dir:   gitDir,
+				base:  dir,
+				bases: newBaseCache(defaultBaseCacheSize),
+				revs:  make(map[string]*nativeTree),
+			}, nil
+		}
+		if filepath.Dir(dir) == dir {
+			return nil, &VCSError{VCS: "git", Op: "open", Path: path, Err: fmt.Errorf("could not find .git directory in %q or any parent", abs)}
+		}
+	}
+}
+
+// readGitFile returns the git directory from a .git file containing
+// "gitdir: path".
+func readGitFile(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(contents))
+	if !strings.HasPrefix(line, "gitdir: ") {
+		return "", fmt.Errorf("invalid .git file %q", path)
+	}
+	dir := strings.TrimPrefix(line, "gitdir: ")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+	return dir, nil
+}
+
+// rel returns the relative path to this path.
+func (g *NativeGit) rel(path string) (string, error) {
+	relPath, err := filepath.Rel(g.base, path)
+	if err != nil {
+		return "", fmt.Errorf("git cannot make path relative: %v", err)
+	}
+	if relPath == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+// ReadDir returns a list of files in a directory at revision
+func (g *NativeGit) ReadDir(revision, path string) ([]os.FileInfo, error) {
+	files, err := g.readDir(revision, path)
+	return files, vcsError("git", "read dir", revision, path, err)
+}
+
+func (g *NativeGit) readDir(revision, path string) ([]os.FileInfo, error) {
+	if revision == revisionFS {
+		return ioutil.ReadDir(path)
+	}
+
+	relPath, err := g.rel(path)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := g.tree(revision)
+	if err != nil {
+		return nil, err
+	}
+	return tree.dirs[relPath], nil
+}
+
+// OpenFile returns a reader for a given absolute path at a revision
+func (g *NativeGit) OpenFile(revision, path string) (io.ReadCloser, error) {
+	rc, err := g.openFile(revision, path)
+	return rc, vcsError("git", "open", revision, path, err)
+}
+
+func (g *NativeGit) openFile(revision, path string) (io.ReadCloser, error) {
+	if revision == revisionFS {
+		return os.Open(path)
+	}
+
+	relPath, err := g.rel(path)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := g.tree(revision)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := tree.files[relPath]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: revision + ":" + relPath, Err: os.ErrNotExist}
+	}
+
+	obj, err := g.object(hash)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+// ReadDirContext returns a list of files in a directory at revision, unless
+// ctx is done. Objects are read without starting processes, so a read is not
+// interrupted.
+func (g *NativeGit) ReadDirContext(ctx context.Context, revision, path string) ([]os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return g.ReadDir(revision, path)
+}
+
+// OpenFileContext returns a reader for a given absolute path at a revision,
+// unless ctx is done.
+func (g *NativeGit) OpenFileContext(ctx context.Context, revision, path string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return g.OpenFile(revision, path)
+}
+
+// DefaultRevision returns the default revisions if none specified. Unlike
+// git ls-files -m, the working tree is compared to HEAD and not the index.
+func (g *NativeGit) DefaultRevision() (string, string) {
+	tree, err := g.tree("HEAD")
+	if err != nil {
+		return "HEAD~1", "HEAD"
+	}
+	for path, hash := range tree.files {
+		contents, err := ioutil.ReadFile(filepath.Join(g.base, filepath.FromSlash(path)))
+		if err != nil || hashObject("blob", contents) != hash {
+			return "HEAD", "."
+		}
+	}
+	return "HEAD~1", "HEAD"
+}
+
+// Close closes all open pack files.
+func (g *NativeGit) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var firstErr error
+	for _, pack := range g.packs {
+		if err := pack.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	g.packs = nil
+	return firstErr
+}
+
+// hashObject returns the object name of an object's contents.
+func hashObject(typ string, data []byte) gitHash {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", typ, len(data))
+	h.Write(data)
+	var hash gitHash
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
+// tree returns the flattened tree of a revision, concurrent reads of the same
+// revision wait for the first to load it.
+func (g *NativeGit) tree(revision string) (*nativeTree, error) {
+	g.mu.Lock()
+	tree, ok := g.revs[revision]
+	if !ok {
+		tree = &nativeTree{}
+		g.revs[revision] = tree
+	}
+	g.mu.Unlock()
+
+	tree.once.Do(func() {
+		tree.err = g.loadTree(tree, revision)
+	})
+	if tree.err != nil {
+		return nil, tree.err
+	}
+	return tree, nil
+}
+
+// loadTree resolves revision and adds its files and directories to tree.
+func (g *NativeGit) loadTree(tree *nativeTree, revision string) error {
+	commit, err := g.resolve(revision)
+	if err != nil {
+		return err
+	}
+	obj, err := g.object(commit)
+	if err != nil {
+		return err
+	}
+	root, _, err := parseCommit(obj)
+	if err != nil {
+		return fmt.Errorf("revision %q: %v", revision, err)
+	}
+
+	tree.files = make(map[string]gitHash)
+	tree.dirs = make(map[string][]os.FileInfo)
+	return g.walkTree(tree, root, "")
+}
+
+// walkTree adds the entries of the tree object hash, and its subtrees, to
+// tree, where dir is the tree's relative path.
+func (g *NativeGit) walkTree(tree *nativeTree, hash gitHash, dir string) error {
+	obj, err := g.object(hash)
+	if err != nil {
+		return err
+	}
+	if obj.typ != "tree" {
+		return fmt.Errorf("object %s is a %s not a tree", hash, obj.typ)
+	}
+	tree.dirs[dir] = []os.FileInfo{}
+
+	// Each entry is: <mode> SP <name> NUL <20 byte hash>
+	data := obj.data
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp < 0 || nul < sp || len(data) < nul+1+sha1.Size {
+			return fmt.Errorf("corrupt tree object %s", hash)
+		}
+		mode, name := string(data[:sp]), string(data[sp+1:nul])
+		var entry gitHash
+		copy(entry[:], data[nul+1:])
+		data = data[nul+1+sha1.Size:]
+
+		path := name
+		if dir != "" {
+			path = dir + "/" + name
+		}
+		switch {
+		case mode == "40000":
+			tree.dirs[dir] = append(tree.dirs[dir], fileInfo{name: name, dir: true})
+			if err := g.walkTree(tree, entry, path); err != nil {
+				return err
+			}
+		case mode == "160000":
+			// submodule commit, not available in this repository
+		default:
+			tree.dirs[dir] = append(tree.dirs[dir], fileInfo{name: name})
+			tree.files[path] = entry
+		}
+	}
+	return nil
+}
+
+// parseCommit returns the tree and parents of a commit object.
+func parseCommit(obj *gitRawObject) (tree gitHash, parents []gitHash, err error) {
+	if obj.typ != "commit" {
+		return tree, nil, fmt.Errorf("object is a %s not a commit", obj.typ)
+	}
+	for _, line := range strings.Split(string(obj.data), "\n") {
+		if line == "" {
+			// end of headers
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "tree "):
+			if tree, err = parseHash(line[len("tree "):]); err != nil {
+				return tree, nil, err
+			}
+		case strings.HasPrefix(line, "parent "):
+			parent, err := parseHash(line[len("parent "):])
+			if err != nil {
+				return tree, nil, err
+			}
+			parents = append(parents, parent)
+		}
+	}
+	return tree, parents, nil
+}
+
+// parseHash parses a 40 character hex object name.
+func parseHash(s string) (gitHash, error) {
+	var hash gitHash
+	if len(s) != hex.EncodedLen(sha1.Size) {
+		return hash, fmt.Errorf("invalid object name %q", s)
+	}
+	_, err := hex.Decode(hash[:], []byte(s))
+	return hash, err
+}
+
+// resolve returns the commit a revision refers to, supporting refs,
+// full and abbreviated object names and the ~ and ^ suffixes.
+func (g *NativeGit) resolve(revision string) (gitHash, error) {
+	name, suffix := revision, ""
+	if i := strings.IndexAny(revision, "~^"); i >= 0 {
+		name, suffix = revision[:i], revision[i:]
+	}
+
+	hash, err := g.resolveName(name)
+	if err != nil {
+		return hash, fmt.Errorf("could not resolve revision %q: %v", revision, err)
+	}
+	if hash, err = g.peel(hash); err != nil {
+		return hash, err
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+		n, digits := 1, 0
+		for digits < len(suffix) && suffix[digits] >= '0' && suffix[digits] <= '9' {
+			digits++
+		}
+		if digits > 0 {
+			n, _ = strconv.Atoi(suffix[:digits])
+			suffix = suffix[digits:]
+		}
+
+		switch op {
+		case '~':
+			// n-th first parent
+			for ; n > 0; n-- {
+				if hash, err = g.parent(hash, 1); err != nil {
+					return hash, fmt.Errorf("could not resolve revision %q: %v", revision, err)
+				}
+			}
+		case '^':
+			// n-th parent, ^0 is the commit itself
+			if n > 0 {
+				if hash, err = g.parent(hash, n); err != nil {
+					return hash, fmt.Errorf("could not resolve revision %q: %v", revision, err)
+				}
+			}
+		default:
+			return hash, fmt.Errorf("unsupported revision %q", revision)
+		}
+	}
+	return hash, nil
+}
+
+// parent returns the n-th (1 based) parent of commit.
+func (g *NativeGit) parent(commit gitHash, n int) (gitHash, error) {
+	obj, err := g.object(commit)
+	if err != nil {
+		return commit, err
+	}
+	_, parents, err := parseCommit(obj)
+	if err != nil {
+		return commit, err
+	}
+	if n > len(parents) {
+		return commit, fmt.Errorf("commit %s has %d parents", commit, len(parents))
+	}
+	return parents[n-1], nil
+}
+
+// peel follows annotated tags until a commit is found.
+func (g *NativeGit) peel(hash gitHash) (gitHash, error) {
+	for {
+		obj, err := g.object(hash)
+		if err != nil {
+			return hash, err
+		}
+		if obj.typ != "tag" {
+			return hash, nil
+		}
+		line := strings.SplitN(string(obj.data), "\n", 2)[0]
+		if hash, err = parseHash(strings.TrimPrefix(line, "object ")); err != nil {
+			return hash, err
+		}
+	}
+}
+
+// resolveName resolves a ref or object name, without any suffixes, following
+// the same order as git rev-parse.
+func (g *NativeGit) resolveName(name string) (gitHash, error) {
+	if hash, err := parseHash(name); err == nil {
+		return hash, nil
+	}
+
+	for _, ref := range []string{
+		name,
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/HEAD",
+	} {
+		hash, err := g.readRef(ref, 0)
+		if err == nil {
+			return hash, nil
+		}
+		if !os.IsNotExist(err) {
+			return hash, err
+		}
+	}
+
+	if len(name) >= 4 && strings.Trim(strings.ToLower(name), "0123456789abcdef") == "" {
+		return g.resolveAbbrev(strings.ToLower(name))
+	}
+	return gitHash{}, errors.New("unknown revision")
+}
+
+// readRef returns the object a ref points to, following symbolic refs.
+func (g *NativeGit) readRef(ref string, depth int) (gitHash, error) {
+	if depth > 5 {
+		return gitHash{}, fmt.Errorf("too many levels of symbolic refs for %q", ref)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(g.dir, filepath.FromSlash(ref)))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return gitHash{}, err
+		}
+		return g.readPackedRef(ref)
+	}
+	line := strings.TrimSpace(string(contents))
+	if strings.HasPrefix(line, "ref: ") {
+		return g.readRef(strings.TrimPrefix(line, "ref: "), depth+1)
+	}
+	return parseHash(line)
+}
+
+// readPackedRef returns the object a ref points to from the packed-refs file.
+func (g *NativeGit) readPackedRef(ref string) (gitHash, error) {
+	notExist := &os.PathError{Op: "read", Path: ref, Err: os.ErrNotExist}
+	if !strings.HasPrefix(ref, "refs/") {
+		return gitHash{}, notExist
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(g.dir, "packed-refs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return gitHash{}, notExist
+		}
+		return gitHash{}, err
+	}
+	// <hash> SP <ref>, comments start with # and peeled tags with ^
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == ref {
+			return parseHash(fields[0])
+		}
+	}
+	return gitHash{}, notExist
+}
+
+// resolveAbbrev resolves an abbreviated hex object name to a unique object.
+func (g *NativeGit) resolveAbbrev(prefix string) (gitHash, error) {
+	matches := make(map[gitHash]bool)
+
+	// Loose objects are stored as objects/xx/yyyy...
+	names, _ := ioutil.ReadDir(filepath.Join(g.dir, "objects", prefix[:2]))
+	for _, fi := range names {
+		if strings.HasPrefix(prefix[:2]+fi.Name(), prefix) {
+			if hash, err := parseHash(prefix[:2] + fi.Name()); err == nil {
+				matches[hash] = true
+			}
+		}
+	}
+
+	packs, err := g.loadPacks()
+	if err != nil {
+		return gitHash{}, err
+	}
+	for _, pack := range packs {
+		for _, hash := range pack.hashesWithPrefix(prefix) {
+			matches[hash] = true
+		}
+	}
+
+	if len(matches) != 1 {
+		return gitHash{}, fmt.Errorf("abbreviated object name %q matches %d objects", prefix, len(matches))
+	}
+	for hash := range matches {
+		return hash, nil
+	}
+	panic("unreachable")
+}
+
+// object returns the decompressed object.
+func (g *NativeGit) object(hash gitHash) (*gitRawObject, error) {
+	obj, err := g.looseObject(hash)
+	if err == nil || !os.IsNotExist(err) {
+		return obj, err
+	}
+
+	packs, err := g.loadPacks()
+	if err != nil {
+		return nil, err
+	}
+	for _, pack := range packs {
+		if offset, ok := pack.offset(hash); ok {
+			return g.packObject(pack, offset)
+		}
+	}
+	return nil, fmt.Errorf("object %s not found", hash)
+}
+
+// looseObject reads an object stored as a zlib compressed file.
+func (g *NativeGit) looseObject(hash gitHash) (*gitRawObject, error) {
+	name := hash.String()
+	f, err := os.Open(filepath.Join(g.dir, "objects", name[:2], name[2:]))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %v", hash, err)
+	}
+	defer zr.Close()
+	contents, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %v", hash, err)
+	}
+
+	// <type> SP <size> NUL <data>
+	nul := bytes.IndexByte(contents, 0)
+	sp := bytes.IndexByte(contents, ' ')
+	if nul < 0 || sp < 0 || sp > nul {
+		return nil, fmt.Errorf("corrupt object %s", hash)
+	}
+	return &gitRawObject{typ: string(contents[:sp]), data: contents[nul+1:]}, nil
+}
+
+// Pack object types
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+var packTypes = map[byte]string{packCommit: "commit", packTree: "tree", packBlob: "blob", packTag: "tag"}
+
+// gitPack is a pack file and its version 2 index, the file is only read with
+// ReadAt, so it may be read concurrently.
+type gitPack struct {
+	file    *os.File
+	hashes  []gitHash // sorted
+	offsets []int64   // offsets for each of hashes
+}
+
+// loadPacks opens all pack files on first use.
+func (g *NativeGit) loadPacks() ([]*gitPack, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.packs != nil {
+		return g.packs, nil
+	}
+
+	g.packs = []*gitPack{}
+	idxs, err := filepath.Glob(filepath.Join(g.dir, "objects", "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range idxs {
+		pack, err := openPack(idx)
+		if err != nil {
+			return nil, err
+		}
+		g.packs = append(g.packs, pack)
+	}
+	return g.packs, nil
+}
+
+// openPack reads a version 2 pack index and opens its pack file.
+func openPack(idxPath string) (*gitPack, error) {
+	idx, err := ioutil.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	// header, version, 256 fan-out entries
+	const headerLen = 4 + 4 + 256*4
+	if len(idx) < headerLen || !bytes.Equal(idx[:4], []byte{0xff, 't', 'O', 'c'}) || binary.BigEndian.Uint32(idx[4:]) != 2 {
+		return nil, fmt.Errorf("%s: unsupported pack index version", idxPath)
+	}
+	n := int(binary.BigEndian.Uint32(idx[headerLen-4:]))
+
+	// hashes, crc32s, 32 bit offsets then 64 bit offsets
+	var (
+		hashStart  = headerLen
+		crcStart   = hashStart + n*sha1.Size
+		offStart   = crcStart + n*4
+		largeStart = offStart + n*4
+	)
+	if len(idx) < largeStart {
+		return nil, fmt.Errorf("%s: corrupt pack index", idxPath)
+	}
+
+	pack := &gitPack{
+		hashes:  make([]gitHash, n),
+		offsets: make([]int64, n),
+	}
+	for i := 0; i < n; i++ {
+		copy(pack.hashes[i][:], idx[hashStart+i*sha1.Size:])
+		offset := binary.BigEndian.Uint32(idx[offStart+i*4:])
+		if offset&0x80000000 == 0 {
+			pack.offsets[i] = int64(offset)
+			continue
+		}
+		large := largeStart + int(offset&0x7fffffff)*8
+		if len(idx) < large+8 {
+			return nil, fmt.Errorf("%s: corrupt pack index", idxPath)
+		}
+		pack.offsets[i] = int64(binary.BigEndian.Uint64(idx[large:]))
+	}
+
+	pack.file, err = os.Open(strings.TrimSuffix(idxPath, ".idx") + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// offset returns the offset of an object in the pack file.
+func (p *gitPack) offset(hash gitHash) (int64, bool) {
+	i := sort.Search(len(p.hashes), func(i int) bool {
+		return bytes.Compare(p.hashes[i][:], hash[:]) >= 0
+	})
+	if i < len(p.hashes) && p.hashes[i] == hash {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// hashesWithPrefix returns all objects in the pack with the hex prefix.
+func (p *gitPack) hashesWithPrefix(prefix string) (hashes []gitHash) {
+	i := sort.Search(len(p.hashes), func(i int) bool {
+		return p.hashes[i].String() >= prefix
+	})
+	for ; i < len(p.hashes) && strings.HasPrefix(p.hashes[i].String(), prefix); i++ {
+		hashes = append(hashes, p.hashes[i])
+	}
+	return hashes
+}
+
+// packObject reads the object at offset in pack, applying any deltas.
+func (g *NativeGit) packObject(pack *gitPack, offset int64) (*gitRawObject, error) {
+	r := bufio.NewReader(io.NewSectionReader(pack.file, offset, 1<<62))
+
+	// Type and size header: 1 bit more, 3 bits type, 4 bits size, then 7
+	// bits of size per byte while more is set
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	typ := (c >> 4) & 0x7
+	size := int64(c & 0x0f)
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		size |= int64(c&0x7f) << shift
+	}
+
+	var base *gitRawObject
+	switch typ {
+	case packOfsDelta:
+		// Offset to the base object, relative to this object
+		if c, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			rel = ((rel + 1) << 7) | int64(c&0x7f)
+		}
+		// Bases are often shared by many deltas, so cache them
+		key := baseKey{pack: pack, offset: offset - rel}
+		var ok bool
+		if base, ok = g.bases.get(key); !ok {
+			if base, err = g.packObject(pack, offset-rel); err != nil {
+				return nil, err
+			}
+			g.bases.add(key, base)
+		}
+	case packRefDelta:
+		var hash gitHash
+		if _, err := io.ReadFull(r, hash[:]); err != nil {
+			return nil, err
+		}
+		if base, err = g.object(hash); err != nil {
+			return nil, err
+		}
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("pack object at %d: %v", offset, err)
+	}
+	defer zr.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, fmt.Errorf("pack object at %d: %v", offset, err)
+	}
+
+	if base == nil {
+		name, ok := packTypes[typ]
+		if !ok {
+			return nil, fmt.Errorf("pack object at %d: unknown type %d", offset, typ)
+		}
+		return &gitRawObject{typ: name, data: data}, nil
+	}
+
+	data, err = applyDelta(base.data, data)
+	if err != nil {
+		return nil, fmt.Errorf("pack object at %d: %v", offset, err)
+	}
+	return &gitRawObject{typ: base.typ, data: data}, nil
+}
+
+// baseKey identifies a delta base by its offset in a pack.
+type baseKey struct {
+	pack   *gitPack
+	offset int64
+}
+
+// baseCache is a least recently used cache of delta bases, limited to a
+// maximum total size of the objects' data. It's safe for concurrent use.
+type baseCache struct {
+	mu      sync.Mutex
+	max     int
+	size    int
+	entries map[baseKey]*list.Element // values are *baseEntry
+	lru     *list.List                // front is most recently used
+}
+
+type baseEntry struct {
+	key baseKey
+	obj *gitRawObject
+}
+
+// newBaseCache returns a cache of at most max bytes of objects.
+func newBaseCache(max int) *baseCache {
+	return &baseCache{max: max, entries: make(map[baseKey]*list.Element), lru: list.New()}
+}
+
+// get returns the cached base, marking it as recently used.
+func (c *baseCache) get(key baseKey) (*gitRawObject, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*baseEntry).obj, true
+}
+
+// add caches a base, evicting the least recently used bases until the cache
+// is within its maximum size. Objects larger than the cache aren't cached.
+func (c *baseCache) add(key baseKey, obj *gitRawObject) {
+	if len(obj.data) > c.max {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		// added by a concurrent read
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&baseEntry{key: key, obj: obj})
+	c.size += len(obj.data)
+	for c.size > c.max {
+		oldest := c.lru.Back()
+		entry := oldest.Value.(*baseEntry)
+		c.lru.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= len(entry.obj.data)
+	}
+}
+
+// applyDelta applies a pack delta to base.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	errCorrupt := errors.New("corrupt delta")
+
+	// varint reads a little endian base 128 size
+	varint := func() (int, error) {
+		var n int
+		for shift := uint(0); ; shift += 7 {
+			if len(delta) == 0 {
+				return 0, errCorrupt
+			}
+			c := delta[0]
+			delta = delta[1:]
+			n |= int(c&0x7f) << shift
+			if c&0x80 == 0 {
+				return n, nil
+			}
+		}
+	}
+	srcSize, err := varint()
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != len(base) {
+		return nil, errCorrupt
+	}
+	dstSize, err := varint()
+	if err != nil {
+		return nil, err
+	}
+
+	dst := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// copy from base, the bits of op indicate which offset and size
+			// bytes follow
+			var offset, size int
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errCorrupt
+				}
+				if i < 4 {
+					offset |= int(delta[0]) << (8 * i)
+				} else {
+					size |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(base) {
+				return nil, errCorrupt
+			}
+			dst = append(dst, base[offset:offset+size]...)
+		case op != 0:
+			// insert the next op bytes
+			if int(op) > len(delta) {
+				return nil, errCorrupt
+			}
+			dst = append(dst, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errCorrupt
+		}
+	}
+	if len(dst) != dstSize {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
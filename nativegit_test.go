@@ -0,0 +1,119 @@
+package apicompat
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestNativeGit compares the files read by NativeGit with those read by Git,
+// from loose objects and then from a pack file with deltas.
+func TestNativeGit(t *testing.T) {
+	dir := makeGitRepo(t, 3, 3)
+	defer os.RemoveAll(dir)
+
+	for _, packed := range []bool{false, true} {
+		if packed {
+			// Force deltas between the similar files
+			cmd := exec.Command("git", "repack", "-a", "-d", "-f", "--depth=50", "--window=250")
+			cmd.Dir = dir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git repack: %v output: %s", err, out)
+			}
+		}
+
+		g, err := NewGit(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := NewNativeGit(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		execd := walkVCS(t, g.ReadDir, gitOpenFile(g), "HEAD", g.base, g.base)
+		native := walkVCS(t, n.ReadDir, nativeOpenFile(n), "HEAD", g.base, n.base)
+		if len(native) != len(execd) {
+			t.Errorf("packed %v: exp %d files got %d", packed, len(execd), len(native))
+		}
+		for path, contents := range execd {
+			if !bytes.Equal(native[path], contents) {
+				t.Errorf("packed %v: %s: exp %q got %q", packed, path, contents, native[path])
+			}
+		}
+
+		// Resolve revisions the same as git rev-parse
+		for _, rev := range []string{"HEAD", "HEAD^0", "HEAD~0"} {
+			cmd := exec.Command("git", "rev-parse", rev)
+			cmd.Dir = dir
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("git rev-parse %s: %v", rev, err)
+			}
+			exp := strings.TrimSpace(string(out))
+			for _, name := range []string{rev, exp, exp[:7]} {
+				hash, err := n.resolve(name)
+				if err != nil {
+					t.Errorf("packed %v: resolve %q: unexpected error: %v", packed, name, err)
+				} else if hash.String() != exp {
+					t.Errorf("packed %v: resolve %q: exp %s got %s", packed, name, exp, hash)
+				}
+			}
+		}
+		if before, after := n.DefaultRevision(); before != "HEAD~1" || after != "HEAD" {
+			t.Errorf("packed %v: exp default revisions HEAD~1 HEAD got %q %q", packed, before, after)
+		}
+
+		if err := g.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := n.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// nativeOpenFile reads an entire file via the NativeGit VCS.
+func nativeOpenFile(n *NativeGit) func(rev, path string) ([]byte, error) {
+	return func(rev, path string) ([]byte, error) {
+		rc, err := n.OpenFile(rev, path)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+}
+
+func TestBaseCache(t *testing.T) {
+	cache := newBaseCache(10)
+	pack := &gitPack{}
+	obj := func(size int) *gitRawObject { return &gitRawObject{data: make([]byte, size)} }
+
+	cache.add(baseKey{pack, 1}, obj(4))
+	cache.add(baseKey{pack, 2}, obj(4))
+	if _, ok := cache.get(baseKey{pack, 1}); !ok {
+		t.Fatal("exp base 1 cached")
+	}
+	// exceeds the maximum, so evicts the least recently used, base 2
+	cache.add(baseKey{pack, 3}, obj(4))
+	if _, ok := cache.get(baseKey{pack, 2}); ok {
+		t.Error("exp base 2 evicted")
+	}
+	for _, offset := range []int64{1, 3} {
+		if _, ok := cache.get(baseKey{pack, offset}); !ok {
+			t.Errorf("exp base %d cached", offset)
+		}
+	}
+	// larger than the cache, so not cached
+	cache.add(baseKey{pack, 4}, obj(11))
+	if _, ok := cache.get(baseKey{pack, 4}); ok {
+		t.Error("exp base 4 not cached")
+	}
+	if cache.size != 8 {
+		t.Errorf("exp size 8 got %d", cache.size)
+	}
+}
@@ -0,0 +1,89 @@
+package apicompat
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+)
+
+// Package is a single package's declarations, parsed and type checked by
+// LoadPackage or NewPackage, to be compared by ComparePackages. This allows
+// the package being checked to come from another tool, such as an analysis
+// driver, rather than the VCS.
+type Package struct {
+	p pkg
+}
+
+// LoadPackage parses and type checks the package in directory dir at revision
+// rev using the Checker's VCS. path is the package's import path, as dir may
+// be outside of GOPATH, such as in a module.
+func (c *Checker) LoadPackage(ctx context.Context, dir, path, rev string) (*Package, error) {
+	chk := check{Checker: c, ctx: ctx, vcs: withContext(ctx, c.vcs), build: c.defaultBuild()}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	bctx := chk.buildContext(rev)
+	ipkg, err := bctx.ImportDir(dir, 0)
+	if err != nil {
+		return nil, &ParseError{Rev: rev, Pkg: path, Err: err}
+	}
+	ipkg.ImportPath = path
+
+	imp := newVCSImporter(chk.vcs, rev, chk.build)
+	imp.lenient = c.lenient
+	p, err := chk.parseFiles(rev, wd, ipkg, imp)
+	if err != nil {
+		return nil, err
+	}
+	return &Package{p: p}, nil
+}
+
+// NewPackage type checks files, the package with import path path, using imp
+// to import its dependencies. The files are modified, so must not be shared,
+// and are treated as being on the file system, rather than at a revision.
+func NewPackage(path string, fset *token.FileSet, files []*ast.File, imp types.Importer) (*Package, error) {
+	p := pkg{
+		importPath: path,
+		rev:        revisionFS,
+		fset:       fset,
+		info: &types.Info{
+			Types: make(map[ast.Expr]types.TypeAndValue),
+			Defs:  make(map[*ast.Ident]types.Object),
+			Uses:  make(map[*ast.Ident]types.Object),
+		},
+	}
+	conf := &types.Config{
+		IgnoreFuncBodies:         true,
+		DisableUnusedImportCheck: true,
+		Importer:                 imp,
+	}
+	tpkg, err := conf.Check(path, fset, files, p.info)
+	if err != nil {
+		return nil, p.typeCheckError(err)
+	}
+	if len(files) > 0 {
+		p.clause = files[0].Name.Pos()
+	}
+	p.tpkg = tpkg
+	p.saveBodies(files)
+	p.decls = pkgDecls(files, p.info, tpkg)
+	return &Package{p: p}, nil
+}
+
+// ComparePackages compares two versions of a package, returning the changes
+// sorted by ID. Changes use the import path of before.
+func (c *Checker) ComparePackages(ctx context.Context, before, after *Package) ([]Change, error) {
+	chk := check{Checker: c, ctx: ctx, vcs: withContext(ctx, c.vcs), build: c.defaultBuild()}
+	path := before.p.importPath
+	changes, _, err := chk.compareDecls(map[string]pkg{path: before.p}, map[string]pkg{path: after.p})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byID(changes))
+	return changes, nil
+}
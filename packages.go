@@ -0,0 +1,92 @@
+package apicompat
+
+import "sort"
+
+// packageChanges returns the changes of packages only in b or a, which have
+// an empty ID. A removed package's declarations are also reported as removed,
+// unless the package moved to an added package with the same declarations.
+func packageChanges(b, a map[string]pkg) []Change {
+	var removed, added []string
+	for path := range b {
+		if _, ok := a[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	for path := range a {
+		if _, ok := b[path]; !ok {
+			added = append(added, path)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+
+	var (
+		changes []Change
+		movedTo = make(map[string]bool)
+	)
+	for _, path := range removed {
+		bpkg := b[path]
+		lowConfidence := len(bpkg.diags) > 0
+
+		if to, ok := packageMove(b, a, path, added, movedTo); ok {
+			movedTo[to] = true
+			apkg := a[to]
+			changes = append(changes, Change{
+				Pkg: path, Change: Breaking, Msg: "package moved", Detail: "package moved to " + to, Pos: pos(apkg.fset, apkg.clause),
+				BeforePos: bpkg.position(bpkg.clause), AfterPos: apkg.position(apkg.clause),
+				LowConfidence: lowConfidence || len(apkg.diags) > 0,
+			})
+			continue
+		}
+
+		changes = append(changes, Change{
+			Pkg: path, Change: Breaking, Msg: "package removed", Pos: pos(bpkg.fset, bpkg.clause),
+			BeforePos: bpkg.position(bpkg.clause), LowConfidence: lowConfidence,
+		})
+		for id, bDecl := range bpkg.decls {
+			changes = append(changes, Change{
+				Pkg: path, ID: id, Change: Breaking, Msg: "declaration removed", Pos: pos(bpkg.fset, bDecl.End()),
+				Before: bDecl, BeforePos: bpkg.position(declPos(bDecl)), BeforeFset: bpkg.fset, LowConfidence: lowConfidence,
+			})
+		}
+	}
+
+	for _, path := range added {
+		if movedTo[path] {
+			continue
+		}
+		apkg := a[path]
+		changes = append(changes, Change{
+			Pkg: path, Change: NonBreaking, Msg: "package added", Pos: pos(apkg.fset, apkg.clause),
+			AfterPos: apkg.position(apkg.clause), LowConfidence: len(apkg.diags) > 0,
+		})
+	}
+	return changes
+}
+
+// packageMove returns the first added package, not already moved to, which
+// has the same declarations as the removed package path.
+func packageMove(b, a map[string]pkg, path string, added []string, movedTo map[string]bool) (string, bool) {
+	bpkg := b[path]
+	if len(bpkg.decls) == 0 {
+		return "", false
+	}
+	for _, to := range added {
+		apkg := a[to]
+		if movedTo[to] || len(apkg.decls) != len(bpkg.decls) {
+			continue
+		}
+		same := true
+		for id, bDecl := range bpkg.decls {
+			aDecl, ok := apkg.decls[id]
+			if !ok || !sameShape(bpkg, apkg, bDecl, aDecl, nil, movedQualifier(path, to)) {
+				same = false
+				break
+			}
+		}
+		if same {
+			return to, true
+		}
+	}
+	return "", false
+}
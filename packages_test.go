@@ -0,0 +1,73 @@
+package apicompat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestPackageChanges removes, adds and moves packages within a recursive
+// check.
+func TestPackageChanges(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "lib")
+	testenv.GitCommit(t, repo, map[string]string{
+		"kept/kept.go": "package kept\n\nfunc K() {}\n",
+		"gone/gone.go": "package gone\n\nfunc F() {}\n\ntype T struct{}\n",
+		"old/old.go":   "package old\n\ntype C struct{ A int }\n\nfunc New() *C { return nil }\n",
+	})
+	for _, dir := range []string{"gone", "old"} {
+		if err := os.RemoveAll(filepath.Join(repo, dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testenv.GitCommit(t, repo, map[string]string{
+		"fresh/fresh.go":     "package fresh\n\nfunc N() {}\n",
+		"renamed/renamed.go": "package renamed\n\ntype C struct{ A int }\n\nfunc New() *C { return nil }\n",
+	})
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	changes, err := New(SetVCS(vcs), SetReportUnchanged(true)).CheckPackages(context.Background(), CheckRequest{
+		Patterns: []string{repo + "/..."}, Before: "HEAD~1", After: "HEAD",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type result struct {
+		pkg, id, change, msg string
+	}
+	var got []result
+	for _, change := range changes {
+		if change.Change == None {
+			continue
+		}
+		got = append(got, result{strings.TrimPrefix(change.Pkg, "example.com/lib/"), change.ID, change.Change, change.Msg})
+		if exp := "package moved to example.com/lib/renamed"; change.Msg == "package moved" && change.Detail != exp {
+			t.Errorf("%s: exp detail %q got %q", change.Pkg, exp, change.Detail)
+		}
+		if change.ID == "" && !strings.HasSuffix(change.Pos, ".go:1") {
+			t.Errorf("%s: exp position of package clause got %q", change.Pkg, change.Pos)
+		}
+	}
+	exp := []result{
+		{"fresh", "", NonBreaking, "package added"},
+		{"gone", "", Breaking, "package removed"},
+		{"old", "", Breaking, "package moved"},
+		{"gone", "F", Breaking, "declaration removed"},
+		{"gone", "T", Breaking, "declaration removed"},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("exp changes:\n%v\ngot:\n%v", exp, got)
+	}
+}
@@ -0,0 +1,320 @@
+package apicompat
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// renames pairs declarations removed from bpkg with declarations added to
+// apkg which have the same kind and signature, and either a similar name or
+// the same doc comment besides the name, returning the added ID of each
+// renamed ID. Renamed IDs paired only by the edit distance of their names are
+// also returned in guessed, as the pairing is too weak to suggest a fix.
+func renames(bpkg, apkg pkg, removed, added []string) (renamed map[string]string, guessed map[string]bool) {
+	sort.Strings(removed)
+	sort.Strings(added)
+
+	renamed = make(map[string]string)
+	guessed = make(map[string]bool)
+	paired := make(map[string]bool)
+	// pair types first, so methods can be paired by their renamed receiver
+	for _, pairTypes := range []bool{true, false} {
+		for _, bid := range removed {
+			bDecl := bpkg.decls[bid]
+			if _, ok := typeSpec(bDecl); ok != pairTypes {
+				continue
+			}
+			var (
+				best      string
+				bestScore int
+				bestGuess bool
+			)
+			for _, aid := range added {
+				aDecl := apkg.decls[aid]
+				if paired[aid] || !sameShape(bpkg, apkg, bDecl, aDecl, renamed, qualifier) {
+					continue
+				}
+				if score, guess := renameScore(bpkg, apkg, bDecl, aDecl); score > bestScore {
+					best, bestScore, bestGuess = aid, score, guess
+				}
+			}
+			if best != "" {
+				renamed[bid] = best
+				guessed[bid] = bestGuess
+				paired[best] = true
+			}
+		}
+	}
+	return renamed, guessed
+}
+
+// renameScore returns how likely aDecl is bDecl renamed, 0 if its name isn't
+// similar and its doc comment is different. guess is true if only the edit
+// distance of the names is similar, such as Initialise renamed Initialize,
+// which requires names of at least 5 characters differing by at most a
+// quarter, so short names such as Get and Set, or Encode and Decode, aren't
+// paired.
+func renameScore(bpkg, apkg pkg, bDecl, aDecl ast.Decl) (score int, guess bool) {
+	bname, aname := declName(bDecl), declName(aDecl)
+	bdoc, _ := bpkg.docSource(declPos(bDecl))
+	adoc, _ := apkg.docSource(declPos(aDecl))
+	if bdoc != "" && strings.Replace(bdoc, bname, aname, -1) == adoc {
+		// moved doc comments are a better match than any similar name
+		return 1 << 16, false
+	}
+
+	longest, shortest := len(bname), len(aname)
+	if shortest > longest {
+		longest, shortest = shortest, longest
+	}
+	dist := editDistance(bname, aname)
+	switch {
+	case strings.EqualFold(bname, aname):
+	case shortest >= 3 && (strings.Contains(bname, aname) || strings.Contains(aname, bname)):
+		// such as Fetch renamed FetchByID
+	case shortest >= 5 && dist*4 <= longest:
+		guess = true
+	default:
+		return 0, false
+	}
+	return longest - dist + 1, guess
+}
+
+// sameShape returns true if bDecl and aDecl are the same kind of declaration
+// with the same signature, ignoring parameter names, or underlying type, with
+// types named by qf. A method's receiver must be the same or renamed.
+func sameShape(bpkg, apkg pkg, bDecl, aDecl ast.Decl, renamed map[string]string, qf types.Qualifier) bool {
+	switch b := bDecl.(type) {
+	case *ast.FuncDecl:
+		a, ok := aDecl.(*ast.FuncDecl)
+		if !ok {
+			return false
+		}
+		brecv, arecv := recvName(b), recvName(a)
+		if brecv != arecv && (brecv == "" || renamed[brecv] != arecv) {
+			return false
+		}
+		bobj, aobj := bpkg.info.Defs[b.Name], apkg.info.Defs[a.Name]
+		if bobj == nil || aobj == nil {
+			return false
+		}
+		bsig, bok := bobj.Type().(*types.Signature)
+		asig, aok := aobj.Type().(*types.Signature)
+		return bok && aok && sameSignature(bsig, asig, qf)
+	case *ast.GenDecl:
+		a, ok := aDecl.(*ast.GenDecl)
+		if !ok || b.Tok != a.Tok || len(b.Specs) != 1 || len(a.Specs) != 1 {
+			return false
+		}
+		var bobj, aobj types.Object
+		switch bspec := b.Specs[0].(type) {
+		case *ast.TypeSpec:
+			aspec, ok := a.Specs[0].(*ast.TypeSpec)
+			if !ok || bspec.Assign.IsValid() != aspec.Assign.IsValid() {
+				return false
+			}
+			bobj, aobj = bpkg.info.Defs[bspec.Name], apkg.info.Defs[aspec.Name]
+			if bobj == nil || aobj == nil {
+				return false
+			}
+			return types.TypeString(bobj.Type().Underlying(), qf) == types.TypeString(aobj.Type().Underlying(), qf)
+		case *ast.ValueSpec:
+			aspec, ok := a.Specs[0].(*ast.ValueSpec)
+			if !ok {
+				return false
+			}
+			bobj, aobj = bpkg.info.Defs[bspec.Names[0]], apkg.info.Defs[aspec.Names[0]]
+			if bobj == nil || aobj == nil {
+				return false
+			}
+			return types.TypeString(bobj.Type(), qf) == types.TypeString(aobj.Type(), qf)
+		}
+	}
+	return false
+}
+
+// sameSignature returns true if the signatures have the same parameter and
+// result types, named by qf.
+func sameSignature(b, a *types.Signature, qf types.Qualifier) bool {
+	if b.Variadic() != a.Variadic() {
+		return false
+	}
+	return sameTuple(b.Params(), a.Params(), qf) && sameTuple(b.Results(), a.Results(), qf)
+}
+
+// sameTuple returns true if the tuples have the same types, ignoring names.
+func sameTuple(b, a *types.Tuple, qf types.Qualifier) bool {
+	if b.Len() != a.Len() {
+		return false
+	}
+	for i := 0; i < b.Len(); i++ {
+		if types.TypeString(b.At(i).Type(), qf) != types.TypeString(a.At(i).Type(), qf) {
+			return false
+		}
+	}
+	return true
+}
+
+// declName returns the name of decl, without a method's receiver.
+func declName(decl ast.Decl) string {
+	if names := declNames(decl); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// recvName returns the name of fn's receiver type, or an empty string if it's
+// not a method.
+func recvName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return ""
+	}
+	recv := fn.Recv.List[0].Type
+	if star, ok := recv.(*ast.StarExpr); ok {
+		recv = star.X
+	}
+	if ident, ok := recv.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// renameFixes returns fixes keeping the previous name of a declaration renamed
+// from bid to aid: an alias of a type or constant, or a function or method
+// calling the renamed one.
+func renameFixes(bpkg, apkg pkg, bid, aid string, bDecl, aDecl ast.Decl) []SuggestedFix {
+	var (
+		aname     = declName(aDecl)
+		text, msg string
+	)
+	switch b := bDecl.(type) {
+	case *ast.FuncDecl:
+		if recvName(b) != recvName(aDecl.(*ast.FuncDecl)) {
+			// the receiver was renamed, its alias includes the method
+			return nil
+		}
+		src, ok := wrapperSource(b, aname)
+		if !ok {
+			return nil
+		}
+		text, msg = src, fmt.Sprintf("add deprecated %s calling %s", bid, aid)
+	case *ast.GenDecl:
+		switch spec := b.Specs[0].(type) {
+		case *ast.TypeSpec:
+			if spec.Assign.IsValid() {
+				return nil
+			}
+			text = fmt.Sprintf("type %s = %s\n", bid, aname)
+			msg = fmt.Sprintf("add deprecated alias %s = %s", bid, aid)
+		case *ast.ValueSpec:
+			if b.Tok != token.CONST {
+				// a variable can't alias another
+				return nil
+			}
+			text = fmt.Sprintf("const %s = %s\n", bid, aname)
+			msg = fmt.Sprintf("add deprecated constant %s = %s", bid, aid)
+		}
+	}
+	if text == "" {
+		return nil
+	}
+
+	edit, ok := appendEdit(bpkg, apkg, bDecl, deprecated(bpkg, bDecl, "Use "+aid+" instead.")+text)
+	if !ok {
+		return nil
+	}
+	return []SuggestedFix{{Msg: msg, Edits: []TextEdit{edit}}}
+}
+
+// wrapperSource returns the source of fn with a body calling name, a function
+// or method with the same signature.
+func wrapperSource(fn *ast.FuncDecl, name string) (string, bool) {
+	ftype := *fn.Type
+	params, args := namedParams(fn.Type.Params, "p")
+	ftype.Params = params
+	wrapper := &ast.FuncDecl{Name: ast.NewIdent(fn.Name.Name), Type: &ftype}
+
+	call := name + "(" + strings.Join(args, ", ") + ")"
+	if fn.Recv != nil && len(fn.Recv.List) > 0 {
+		recv, recvArgs := namedParams(fn.Recv, "r")
+		wrapper.Recv = recv
+		call = recvArgs[0] + "." + call
+	}
+	if fn.Type.Results != nil && len(fn.Type.Results.List) > 0 {
+		call = "return " + call
+	}
+
+	// positions refer to another file set, so print without one, as String
+	var buf bytes.Buffer
+	if err := format.Node(&buf, token.NewFileSet(), wrapper); err != nil {
+		return "", false
+	}
+	return buf.String() + " {\n\t" + call + "\n}\n", true
+}
+
+// namedParams returns a copy of list with each unnamed or blank parameter
+// named using prefix, and the arguments to pass the parameters to another
+// function.
+func namedParams(list *ast.FieldList, prefix string) (*ast.FieldList, []string) {
+	var (
+		named = &ast.FieldList{}
+		args  []string
+	)
+	for _, field := range list.List {
+		names := field.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{ast.NewIdent("_")}
+		}
+		fcopy := *field
+		fcopy.Names = nil
+		for _, ident := range names {
+			name := ident.Name
+			if name == "_" {
+				name = prefix
+				if len(list.List) > 1 || len(names) > 1 {
+					name = fmt.Sprintf("%s%d", prefix, len(args))
+				}
+			}
+			fcopy.Names = append(fcopy.Names, ast.NewIdent(name))
+			if _, ok := field.Type.(*ast.Ellipsis); ok {
+				name += "..."
+			}
+			args = append(args, name)
+		}
+		named.List = append(named.List, &fcopy)
+	}
+	return named, args
+}
@@ -0,0 +1,131 @@
+package apicompat
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestRenames pairs renamed declarations by similar names and moved doc
+// comments, and checks their fixes keep the previous names. Short names only
+// differing by a few characters aren't paired.
+func TestRenames(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "rename")
+	testenv.GitCommit(t, repo, map[string]string{
+		"p.go": `package p
+
+// Client is a client.
+type Client struct{ A int }
+
+func (c *Client) Do(x int) error { return nil }
+
+func Fetch(id int, opts ...string) (string, error) { return "", nil }
+
+const MaxSize = 1
+
+func A(int) {}
+
+func Get() {}
+
+func Encode(b []byte) error { return nil }
+
+func Initialise(n int) {}
+`,
+	})
+	testenv.GitCommit(t, repo, map[string]string{
+		"p.go": `package p
+
+// HTTPClient is a client.
+type HTTPClient struct{ A int }
+
+func (c *HTTPClient) Do(x int) error { return nil }
+
+func FetchByID(key int, opts ...string) (string, error) { return "", nil }
+
+const MaxSizeBytes = 1
+
+func Zebra(int) {}
+
+func Set() {}
+
+func Decode(b []byte) error { return nil }
+
+func Initialize(n int) {}
+`,
+	})
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	checker := New(SetVCS(vcs))
+	changes, err := checker.CheckPackages(context.Background(), CheckRequest{Patterns: []string{repo}, Before: "HEAD~1", After: "HEAD"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type result struct {
+		id, msg, detail string
+		fixes           int
+	}
+	var (
+		got   []result
+		edits = make(map[string][]TextEdit)
+	)
+	for _, change := range changes {
+		got = append(got, result{change.ID, change.Msg, change.Detail, len(change.Fixes)})
+		for _, fix := range change.Fixes {
+			for _, edit := range fix.Edits {
+				edits[edit.Filename] = append(edits[edit.Filename], edit)
+			}
+		}
+	}
+	exp := []result{
+		{"A", "declaration removed", "", 1},
+		{"Client", "declaration renamed", "renamed Client → HTTPClient", 1},
+		{"Client.Do", "declaration renamed", "renamed Client.Do → HTTPClient.Do", 0},
+		{"Decode", "declaration added", "", 0},
+		{"Encode", "declaration removed", "", 1},
+		{"Fetch", "declaration renamed", "renamed Fetch → FetchByID", 1},
+		{"Get", "declaration removed", "", 1},
+		{"Initialise", "declaration renamed", "renamed Initialise → Initialize", 0},
+		{"MaxSize", "declaration renamed", "renamed MaxSize → MaxSizeBytes", 1},
+		{"Set", "declaration added", "", 0},
+		{"Zebra", "declaration added", "", 0},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("exp changes:\n%v\ngot:\n%v", exp, got)
+	}
+
+	for file, fileEdits := range edits {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err = ApplyEdits(src, fileEdits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes, err = checker.CheckPackages(context.Background(), CheckRequest{Patterns: []string{repo}, Before: "HEAD~1", After: "."})
+	if err != nil {
+		t.Fatalf("unexpected error checking fixed package: %v", err)
+	}
+	for _, change := range changes {
+		// a rename guessed from the names alone has no fix
+		if change.Change == Breaking && change.ID != "Initialise" {
+			t.Errorf("unexpected breaking change after fixes: %v", change)
+		}
+	}
+}
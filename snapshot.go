@@ -0,0 +1,162 @@
+package apicompat
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// guarantee at compile time that Snapshot implements VCS
+var _ VCS = (*Snapshot)(nil)
+
+// Snapshot is a VCS with a single revision read from a tar archive, such as
+// one created by git archive, for use as a baseline without the repository's
+// history. Every revision except "." reads the archive, "." reads the file
+// system.
+type Snapshot struct {
+	root  string                     // directory the archive's root corresponds to
+	files map[string][]byte          // slash separated path relative to root -> contents
+	dirs  map[string]map[string]bool // slash separated directory -> entries, true if a directory
+}
+
+// NewSnapshot reads the tar archive, optionally gzipped, from file name. The
+// archive's root corresponds to directory root, such as the root of the
+// repository it was archived from.
+func NewSnapshot(name, root string) (*Snapshot, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, &VCSError{VCS: "snapshot", Op: "open", Path: name, Err: err}
+	}
+	defer f.Close()
+
+	s := &Snapshot{
+		root:  root,
+		files: make(map[string][]byte),
+		dirs:  map[string]map[string]bool{".": {}},
+	}
+	if err := s.read(f); err != nil {
+		return nil, &VCSError{VCS: "snapshot", Op: "read", Path: name, Err: err}
+	}
+	return s, nil
+}
+
+// read reads the archive from r.
+func (s *Snapshot) read(r io.Reader) error {
+	br := bufio.NewReader(r)
+	r = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			s.addDir(name)
+		case tar.TypeReg:
+			contents, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			s.files[name] = contents
+			s.addDir(path.Dir(name))
+			s.dirs[path.Dir(name)][path.Base(name)] = false
+		}
+	}
+}
+
+// addDir adds directory name and its parents.
+func (s *Snapshot) addDir(name string) {
+	if name != "." && s.dirs[name] == nil {
+		s.dirs[name] = make(map[string]bool)
+		parent := path.Dir(name)
+		s.addDir(parent)
+		s.dirs[parent][path.Base(name)] = true
+	}
+}
+
+// rel returns the slash separated path of p relative to the root.
+func (s *Snapshot) rel(p string) (string, error) {
+	if !filepath.IsAbs(p) {
+		var err error
+		if p, err = filepath.Abs(p); err != nil {
+			return "", err
+		}
+	}
+	rel, err := filepath.Rel(s.root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// ReadDir implements VCS.ReadDir
+func (s *Snapshot) ReadDir(revision, dir string) ([]os.FileInfo, error) {
+	if revision == revisionFS {
+		return ioutil.ReadDir(dir)
+	}
+	rel, err := s.rel(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, ok := s.dirs[rel]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: os.ErrNotExist}
+	}
+	var files []os.FileInfo
+	for name, isDir := range entries {
+		files = append(files, fileInfo{name: name, size: int64(len(s.files[path.Join(rel, name)])), dir: isDir})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files, nil
+}
+
+// OpenFile implements VCS.OpenFile
+func (s *Snapshot) OpenFile(revision, file string) (io.ReadCloser, error) {
+	if revision == revisionFS {
+		return os.Open(file)
+	}
+	rel, err := s.rel(file)
+	if err != nil {
+		return nil, err
+	}
+	contents, ok := s.files[rel]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: file, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(bytes.NewReader(contents)), nil
+}
+
+// DefaultRevision implements VCS.DefaultRevision, comparing the snapshot to
+// the file system.
+func (s *Snapshot) DefaultRevision() (string, string) {
+	return "snapshot", revisionFS
+}
+
+// Close implements VCS.Close
+func (s *Snapshot) Close() error { return nil }
@@ -329,3 +329,17 @@ type s struct{ Member uint }
 func F1() s       { return s{} }
 func F2() *s      { return &s{} }
 func (s) F() uint { return 0 }
+
+// TypeToAlias tests replacing a type with an alias of an identical type (is not a problem)
+type TypeToAlias = TypeToAliasTarget
+
+type TypeToAliasTarget struct{ Member int }
+
+func (TypeToAliasTarget) Method() {}
+
+// TypeToAliasMethods detects methods lost by replacing a type with an alias
+type TypeToAliasMethods = TypeToAliasMethodsTarget
+
+type TypeToAliasMethodsTarget struct{ Member int }
+
+func (TypeToAliasMethodsTarget) Method() {}
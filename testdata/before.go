@@ -329,3 +329,23 @@ type s struct{ Member int }
 func F1() s      { return s{} }
 func F2() *s     { return &s{} }
 func (s) F() int { return 0 }
+
+// TypeToAlias tests replacing a type with an alias of an identical type (is not a problem)
+type TypeToAlias struct{ Member int }
+
+func (TypeToAlias) Method() {}
+
+type TypeToAliasTarget struct{ Member int }
+
+func (TypeToAliasTarget) Method() {}
+
+// TypeToAliasMethods detects methods lost by replacing a type with an alias
+type TypeToAliasMethods struct{ Member int }
+
+func (TypeToAliasMethods) Method() {}
+
+func (*TypeToAliasMethods) PtrMethod() {}
+
+type TypeToAliasMethodsTarget struct{ Member int }
+
+func (TypeToAliasMethodsTarget) Method() {}
@@ -0,0 +1,241 @@
+package apicompat
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// refs are the declarations referenced by a package, syntactically.
+type refs struct {
+	pkgs  map[string]map[string]bool // import path -> identifiers selected from the package, "" if imported
+	names map[string]map[string]bool // import path -> all names selected in files importing the package, such as method calls
+}
+
+// countUsage sets the Usage of each change to the number of packages within
+// the corpus directories which reference its declaration.
+//
+// References are found syntactically, without type checking the corpus. A
+// declaration is referenced if it's selected from the package's import name,
+// a method is referenced by any selector with its name in a file importing
+// the changed package, and a package by being imported. As the receiver of a
+// selector isn't known, a method's usage is an upper bound.
+func countUsage(ctx context.Context, changes []Change, corpus []string) error {
+	paths := make(map[string]bool) // changed packages
+	for _, change := range changes {
+		paths[change.Pkg] = true
+	}
+
+	var dirs []string
+	for _, root := range corpus {
+		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if fi.IsDir() && path != root && (fi.Name() == "testdata" || strings.HasPrefix(fi.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			if fi.IsDir() {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Each directory is scanned concurrently
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		scanned = make([]refs, len(dirs))
+		sem     = make(chan struct{}, runtime.GOMAXPROCS(0))
+		scanErr error
+	)
+	for i, dir := range dirs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func(i int, dir string) {
+			defer func() { <-sem; wg.Done() }()
+			r, err := scanRefs(dir, paths)
+			if err != nil {
+				mu.Lock()
+				if scanErr == nil {
+					scanErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			scanned[i] = r
+		}(i, dir)
+	}
+	wg.Wait()
+	if scanErr != nil {
+		return scanErr
+	}
+
+	for i := range changes {
+		changes[i].Usage = 0
+		for _, r := range scanned {
+			if r.references(changes[i].Pkg, changes[i].ID) {
+				changes[i].Usage++
+			}
+		}
+	}
+	return nil
+}
+
+// references returns true if the declaration id in package path is
+// referenced.
+func (r refs) references(path, id string) bool {
+	sels, ok := r.pkgs[path]
+	switch {
+	case !ok:
+		return false
+	case id == "":
+		return true
+	}
+	if i := strings.IndexByte(id, '.'); i >= 0 {
+		// A method, the receiver's type needn't be named to call it
+		return r.names[path][id[i+1:]]
+	}
+	return sels[id]
+}
+
+// scanRefs returns the references to the packages in paths by the Go files in
+// dir, or empty refs if none import them.
+func scanRefs(dir string, paths map[string]bool) (refs, error) {
+	r := refs{pkgs: make(map[string]map[string]bool), names: make(map[string]map[string]bool)}
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return r, err
+	}
+
+	fset := token.NewFileSet()
+	for _, name := range files {
+		// Only files importing a changed package are fully parsed
+		file, err := parser.ParseFile(fset, name, nil, parser.ImportsOnly)
+		if err != nil || !importsAny(file, paths) {
+			continue
+		}
+		file, err = parser.ParseFile(fset, name, nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+
+		var (
+			local    = make(map[string]string) // local package name -> import path
+			dot      []string                  // dot imported paths
+			imported []string                  // changed paths imported by the file
+		)
+		for _, spec := range file.Imports {
+			ipath, err := strconv.Unquote(spec.Path.Value)
+			if err != nil || !paths[ipath] {
+				continue
+			}
+			if r.pkgs[ipath] == nil {
+				r.pkgs[ipath] = make(map[string]bool)
+				r.names[ipath] = make(map[string]bool)
+			}
+			r.pkgs[ipath][""] = true
+			imported = append(imported, ipath)
+
+			name := importName(ipath)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			switch name {
+			case "_":
+			case ".":
+				dot = append(dot, ipath)
+			default:
+				local[name] = ipath
+			}
+		}
+
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				for _, ipath := range imported {
+					r.names[ipath][n.Sel.Name] = true
+				}
+				if x, ok := n.X.(*ast.Ident); ok {
+					if ipath, ok := local[x.Name]; ok {
+						r.pkgs[ipath][n.Sel.Name] = true
+					}
+				}
+			case *ast.Ident:
+				for _, ipath := range dot {
+					r.pkgs[ipath][n.Name] = true
+				}
+			}
+			return true
+		})
+	}
+	return r, nil
+}
+
+// importsAny returns true if file imports any of paths.
+func importsAny(file *ast.File, paths map[string]bool) bool {
+	for _, spec := range file.Imports {
+		if ipath, err := strconv.Unquote(spec.Path.Value); err == nil && paths[ipath] {
+			return true
+		}
+	}
+	return false
+}
+
+// importName returns the conventional package name for an import path, its
+// last element without a major version suffix such as /v2 or .v2.
+func importName(ipath string) string {
+	name := path.Base(ipath)
+	if isMajorVersion(name) && path.Dir(ipath) != "." {
+		name = path.Base(path.Dir(ipath))
+	}
+	if i := strings.LastIndex(name, ".v"); i > 0 && isMajorVersion(name[i+1:]) {
+		name = name[:i] // gopkg.in/yaml.v2
+	}
+	return name
+}
+
+// isMajorVersion returns true if s is a major version, such as v2.
+func isMajorVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	_, err := strconv.Atoi(s[1:])
+	return err == nil
+}
+
+// SortByUsage sorts changes by their severity, breaking changes first, then
+// by their Usage, most used first, and then by ID.
+func SortByUsage(changes []Change) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if sa, sb := severity(a.Change), severity(b.Change); sa != sb {
+			return sa > sb
+		}
+		if a.Usage != b.Usage {
+			return a.Usage > b.Usage
+		}
+		return byID(changes).Less(i, j)
+	})
+}
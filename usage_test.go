@@ -0,0 +1,71 @@
+package apicompat
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestUsage counts references to each change by packages in a corpus laid
+// out like a module cache.
+func TestUsage(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	repo := filepath.Join(gopath, "src", "example.com", "lib")
+	testenv.GitCommit(t, repo, map[string]string{
+		"v2/v2.go":       "package lib\n\nfunc A() {}\n\nfunc B() {}\n\ntype T struct{}\n\nfunc (T) M() {}\n\nfunc C() {}\n",
+		"other/other.go": "package other\n\nfunc D() {}\n",
+	})
+	testenv.GitCommit(t, repo, map[string]string{
+		"v2/v2.go":       "package lib\n\nfunc A(int) {}\n\nfunc B(int) {}\n\ntype T struct{}\n\nfunc C(int) {}\n",
+		"other/other.go": "package other\n\nfunc D(int) {}\n",
+	})
+
+	corpus := filepath.Join(gopath, "corpus")
+	testenv.WriteFiles(t, corpus, map[string]string{
+		"example.com/x@v1.0.0/x.go":         "package x\n\nimport \"example.com/lib/v2\"\n\nfunc f() { lib.A(); lib.B() }\n",
+		"example.com/y@v1.0.0/y/y.go":       "package y\n\nimport l \"example.com/lib/v2\"\n\nfunc f(t l.T) { l.A(); t.M() }\n",
+		"example.com/z@v1.0.0/z.go":         "package z\n\nimport . \"example.com/lib/v2\"\n\nfunc f() { A() }\n",
+		"example.com/z@v1.0.0/unrelated.go": "package z\n\nfunc C() {}\n",
+		// M is selected in a file which doesn't import lib
+		"example.com/w@v1.0.0/lib.go":   "package w\n\nimport \"example.com/lib/v2\"\n\nvar _ = lib.B\n",
+		"example.com/w@v1.0.0/other.go": "package w\n\nimport \"example.com/lib/other\"\n\ntype m struct{}\n\nfunc (m) M() {}\n\nfunc f() { other.D(); m{}.M() }\n",
+	})
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+	checker := New(SetVCS(vcs), SetUsageCorpus(corpus))
+	req := CheckRequest{
+		Patterns: []string{filepath.Join(repo, "v2"), filepath.Join(repo, "other")}, Before: "HEAD~1", After: "HEAD",
+	}
+	changes, err := checker.CheckPackages(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	SortByUsage(changes)
+	exp := []struct {
+		id    string
+		usage int
+	}{{"A", 3}, {"B", 2}, {"D", 1}, {"T.M", 1}, {"C", 0}}
+	if len(changes) != len(exp) {
+		t.Fatalf("exp %d changes got %d: %v", len(exp), len(changes), changes)
+	}
+	for i, e := range exp {
+		if changes[i].ID != e.id || changes[i].Usage != e.usage {
+			t.Errorf("change %d: exp %s used by %d got %s used by %d", i, e.id, e.usage, changes[i].ID, changes[i].Usage)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := countUsage(ctx, changes, []string{corpus}); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled: exp %v got %v", context.Canceled, err)
+	}
+}
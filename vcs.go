@@ -1,14 +1,18 @@
 package apicompat
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,6 +23,8 @@ const revisionFS = "."
 // the vcs should be able to handle calls to ReadFile concurrently
 // A special case for the revision of "." (without quotes) is used to check
 // local filesystem
+// A VCS holding resources, such as processes, also implements io.Closer and
+// should be closed when it's no longer used
 type VCS interface {
 	// ReadDir returns a list of files in a directory at revision
 	ReadDir(revision, path string) ([]os.FileInfo, error)
@@ -28,13 +34,83 @@ type VCS interface {
 	DefaultRevision() (before string, after string)
 }
 
-// guarantee at compile time that *Git implements VCS
-var _ VCS = (*Git)(nil)
+// ContextVCS is a VCS whose reads can be cancelled by a context, any process
+// started by a read is stopped when the context is done.
+type ContextVCS interface {
+	VCS
+	// ReadDirContext is ReadDir using ctx
+	ReadDirContext(ctx context.Context, revision, path string) ([]os.FileInfo, error)
+	// OpenFileContext is OpenFile using ctx
+	OpenFileContext(ctx context.Context, revision, path string) (io.ReadCloser, error)
+}
+
+// contextVCS binds a context to a VCS, using the context methods if vcs is a
+// ContextVCS, otherwise only checking the context before each read.
+type contextVCS struct {
+	VCS
+	ctx context.Context
+}
+
+// withContext returns vcs bound to ctx.
+func withContext(ctx context.Context, vcs VCS) VCS {
+	return contextVCS{VCS: vcs, ctx: ctx}
+}
+
+// ReadDir implements VCS.ReadDir
+func (v contextVCS) ReadDir(revision, path string) ([]os.FileInfo, error) {
+	if cvcs, ok := v.VCS.(ContextVCS); ok {
+		return cvcs.ReadDirContext(v.ctx, revision, path)
+	}
+	if err := v.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return v.VCS.ReadDir(revision, path)
+}
 
-// Git implements vcs and uses exec.Command to access repository
+// OpenFile implements VCS.OpenFile
+func (v contextVCS) OpenFile(revision, path string) (io.ReadCloser, error) {
+	if cvcs, ok := v.VCS.(ContextVCS); ok {
+		return cvcs.OpenFileContext(v.ctx, revision, path)
+	}
+	if err := v.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return v.VCS.OpenFile(revision, path)
+}
+
+// guarantee at compile time that *Git implements ContextVCS and io.Closer
+var (
+	_ ContextVCS = (*Git)(nil)
+	_ io.Closer  = (*Git)(nil)
+)
+
+// Git implements vcs and uses exec.Command to access repository. Each
+// revision's tree is listed once, and files are read from a long running
+// git cat-file --batch process per revision, which is stopped by Close.
 type Git struct {
 	dir  string // directory of .git, used to for --git-dir
 	base string // directory containing .git, used to to make paths relative
+
+	mu   sync.Mutex
+	revs map[string]*gitRevision // revision -> tree and cat-file process
+}
+
+// gitRevision is the tree of a single revision and the process reading its
+// objects.
+type gitRevision struct {
+	files map[string]gitObject     // relative path -> object
+	dirs  map[string][]os.FileInfo // relative directory -> entries
+
+	mu     sync.Mutex // serialises requests to cat-file
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// gitObject is a single entry in a tree.
+type gitObject struct {
+	hash string
+	info fileInfo
 }
 
 // NewGit returns a VCS based based on git.
@@ -44,13 +120,14 @@ func NewGit(path string) (*Git, error) {
 	cmd.Dir = path
 	dir, err := cmd.CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("error running %v: %v output: %q", cmd.Args, err, dir)
+		return nil, &VCSError{VCS: "git", Op: "open", Path: path, Err: fmt.Errorf("error running %v: %w output: %q", cmd.Args, err, dir)}
 	}
 
 	base := string(bytes.TrimSpace(dir))
 	return &Git{
 		base: base,
 		dir:  filepath.Join(base, ".git"),
+		revs: make(map[string]*gitRevision),
 	}, nil
 }
 
@@ -60,11 +137,155 @@ func (g *Git) rel(path string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("git cannot make path relative: %v", err)
 	}
-	return relPath, nil
+	if relPath == "." {
+		// root of the repository
+		return "", nil
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+// revision returns the revision's tree, listing the tree and starting the
+// cat-file process on first use.
+func (g *Git) revision(ctx context.Context, revision string) (*gitRevision, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if r, ok := g.revs[revision]; ok {
+		return r, nil
+	}
+
+	// List the entire tree, including the trees themselves and blob sizes
+	args := []string{"--git-dir", g.dir, "ls-tree", "-r", "-t", "-l", "-z", revision}
+	ls, err := exec.CommandContext(ctx, "git", args...).Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("could not execute git %v, error: %s", args, err)
+	}
+
+	r := &gitRevision{
+		files: make(map[string]gitObject),
+		dirs:  map[string][]os.FileInfo{"": nil},
+	}
+	for _, entry := range bytes.Split(ls, []byte{0}) {
+		// 100644 blob 78edbf3fb411055b4a3d4d3d137ccbec160ac956     116	.gitignore
+		// 040000 tree e62f2cac29e1d6e31aeac65ded75df98b9c1be43       -	testdata
+		tab := bytes.IndexByte(entry, '\t')
+		if tab < 0 {
+			continue
+		}
+		fields := bytes.Fields(entry[:tab])
+		if len(fields) != 4 {
+			continue
+		}
+		path := string(entry[tab+1:])
+		dir, name := "", path
+		if i := strings.LastIndex(path, "/"); i >= 0 {
+			dir, name = path[:i], path[i+1:]
+		}
+
+		info := fileInfo{name: name}
+		switch string(fields[1]) {
+		case "tree":
+			info.dir = true
+			if _, ok := r.dirs[path]; !ok {
+				r.dirs[path] = nil
+			}
+		case "blob":
+			info.size, _ = strconv.ParseInt(string(fields[3]), 10, 64)
+		default:
+			// submodules
+			continue
+		}
+		r.files[path] = gitObject{hash: string(fields[2]), info: info}
+		r.dirs[dir] = append(r.dirs[dir], info)
+	}
+
+	r.cmd = exec.Command("git", "--git-dir", g.dir, "cat-file", "--batch")
+	if r.stdin, err = r.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := r.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	r.stdout = bufio.NewReader(stdout)
+	if err := r.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start git cat-file: %v", err)
+	}
+
+	g.revs[revision] = r
+	return r, nil
+}
+
+// readObject reads the contents of an object from cat-file, returning early if
+// ctx is done. cat-file is shared by all reads of the revision, so it isn't
+// interrupted, instead an abandoned read is finished in the background to
+// keep its output in sync.
+func (r *gitRevision) readObject(ctx context.Context, hash string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		contents []byte
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		contents, err := r.read(hash)
+		done <- result{contents, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.contents, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// read reads the contents of an object from cat-file, r.mu must be held.
+func (r *gitRevision) read(hash string) ([]byte, error) {
+	if _, err := fmt.Fprintln(r.stdin, hash); err != nil {
+		return nil, fmt.Errorf("could not write to git cat-file: %v", err)
+	}
+
+	// <hash> <type> <size> LF <contents> LF
+	header, err := r.stdout.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("could not read from git cat-file: %v", err)
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unexpected git cat-file header: %q", header)
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("unexpected git cat-file header: %q", header)
+	}
+	contents := make([]byte, size+1)
+	if _, err := io.ReadFull(r.stdout, contents); err != nil {
+		return nil, fmt.Errorf("could not read from git cat-file: %v", err)
+	}
+	return contents[:size], nil
 }
 
 // ReadDir returns a list of files in a directory at revision
 func (g *Git) ReadDir(revision, path string) ([]os.FileInfo, error) {
+	return g.ReadDirContext(context.Background(), revision, path)
+}
+
+// ReadDirContext returns a list of files in a directory at revision, listing
+// the revision's tree is stopped if ctx is done.
+func (g *Git) ReadDirContext(ctx context.Context, revision, path string) ([]os.FileInfo, error) {
+	files, err := g.readDir(ctx, revision, path)
+	return files, vcsError("git", "read dir", revision, path, err)
+}
+
+func (g *Git) readDir(ctx context.Context, revision, path string) ([]os.FileInfo, error) {
 	if revision == revisionFS {
 		return ioutil.ReadDir(path)
 	}
@@ -73,34 +294,28 @@ func (g *Git) ReadDir(revision, path string) ([]os.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	relPath += string(os.PathSeparator)
 
-	args := []string{"--git-dir", g.dir, "ls-tree", revision, relPath}
-	ls, err := exec.Command("git", args...).CombinedOutput()
+	r, err := g.revision(ctx, revision)
 	if err != nil {
-		return nil, fmt.Errorf("could not execute git %v, error: %s", args, err)
-	}
-
-	var files []os.FileInfo
-	for _, file := range bytes.Split(ls, []byte{'\n'}) {
-		// 100644 blob 78edbf3fb411055b4a3d4d3d137ccbec160ac956    .gitignore
-		// 040000 tree e62f2cac29e1d6e31aeac65ded75df98b9c1be43    testdata
-		fields := bytes.Fields(file)
-		if len(fields) != 4 {
-			continue
-		}
-
-		files = append(files, fileInfo{
-			// name is basename (no directory structure)
-			name: strings.TrimPrefix(string(fields[3]), relPath),
-			dir:  bytes.Equal(fields[1], []byte("tree")),
-		})
+		return nil, err
 	}
-	return files, nil
+	return r.dirs[relPath], nil
 }
 
 // OpenFile returns a reader for a given absolute path at a revision
 func (g *Git) OpenFile(revision, path string) (io.ReadCloser, error) {
+	return g.OpenFileContext(context.Background(), revision, path)
+}
+
+// OpenFileContext returns a reader for a given absolute path at a revision,
+// the read is abandoned if ctx is done before the file is read, without
+// affecting reads using other contexts.
+func (g *Git) OpenFileContext(ctx context.Context, revision, path string) (io.ReadCloser, error) {
+	rc, err := g.openFile(ctx, revision, path)
+	return rc, vcsError("git", "open", revision, path, err)
+}
+
+func (g *Git) openFile(ctx context.Context, revision, path string) (io.ReadCloser, error) {
 	if revision == revisionFS {
 		return os.Open(path)
 	}
@@ -110,18 +325,50 @@ func (g *Git) OpenFile(revision, path string) (io.ReadCloser, error) {
 		return nil, err
 	}
 
-	var args = []string{"--git-dir", g.dir, "show", revision + ":" + relPath}
-	contents, err := exec.Command("git", args...).Output()
+	r, err := g.revision(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := r.files[relPath]
+	if !ok || obj.info.dir {
+		return nil, &os.PathError{Op: "open", Path: revision + ":" + relPath, Err: os.ErrNotExist}
+	}
+
+	contents, err := r.readObject(ctx, obj.hash)
 	if err != nil {
-		return nil, fmt.Errorf("could not execute git with args %v: %v", args, err)
+		return nil, err
 	}
 	return ioutil.NopCloser(bytes.NewReader(contents)), nil
 }
 
+// Close stops all git cat-file processes.
+func (g *Git) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var firstErr error
+	for revision, r := range g.revs {
+		r.mu.Lock()
+		// cat-file exits when its input is closed
+		if err := r.stdin.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := r.cmd.Wait(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("git cat-file for revision %q: %v", revision, err)
+		}
+		r.mu.Unlock()
+		delete(g.revs, revision)
+	}
+	return firstErr
+}
+
 // DefaultRevision returns the default revisions if none specified
 func (g *Git) DefaultRevision() (string, string) {
-	// Check if there's unstaged changes, if so, return dot
-	contents, _ := exec.Command("git", "--git-dir", g.dir, "ls-files", "-m").Output()
+	// Check if there's unstaged changes, if so, return dot. Run in the
+	// repository's working tree, not the process's working directory
+	cmd := exec.Command("git", "--git-dir", g.dir, "--work-tree", g.base, "ls-files", "-m")
+	cmd.Dir = g.base
+	contents, _ := cmd.Output()
 	if len(contents) > 0 {
 		return "HEAD", "."
 	}
@@ -131,6 +378,7 @@ func (g *Git) DefaultRevision() (string, string) {
 // fileInfo is a struct to simulate the real filesystem file info
 type fileInfo struct {
 	name string // base name of file
+	size int64  // size of file in bytes
 	dir  bool
 }
 
@@ -138,19 +386,24 @@ type fileInfo struct {
 func (fi fileInfo) Name() string { return fi.name }
 
 // Size is one of the method needed to implement os.FileInfo
-func (fi fileInfo) Size() int64 { panic("not implemented") }
+func (fi fileInfo) Size() int64 { return fi.size }
 
 // Mode is one of the method needed to implement os.FileInfo
-func (fi fileInfo) Mode() os.FileMode { panic("not implemented") }
+func (fi fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
 
 // ModTime is one of the method needed to implement os.FileInfo
-func (fi fileInfo) ModTime() time.Time { panic("not implemented") }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
 
 // IsDir is one of the method needed to implement os.FileInfo
 func (fi fileInfo) IsDir() bool { return fi.dir }
 
 // Sys is one of the method needed to implement os.FileInfo
-func (fi fileInfo) Sys() interface{} { panic("not implemented") }
+func (fi fileInfo) Sys() interface{} { return nil }
 
 // guarantee at compile time that StrVCS implements VCS
 var _ VCS = (*StrVCS)(nil)
@@ -177,6 +430,7 @@ func (v StrVCS) ReadDir(revision, path string) (files []os.FileInfo, err error)
 	for file := range v.files[revision] {
 		files = append(files, fileInfo{
 			name: file,
+			size: int64(len(v.files[revision][file])),
 		})
 	}
 	return files, nil
@@ -0,0 +1,336 @@
+package apicompat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// makeGitRepo creates a git repository in a temporary directory with a
+// single commit containing dirs directories, each with files Go files. The
+// files share a header, so packing the repository produces deltas.
+func makeGitRepo(tb testing.TB, dirs, files int) string {
+	dir, err := ioutil.TempDir("", "apicompat")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var header bytes.Buffer
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&header, "// Line %d of a header shared by every generated file.\n", i)
+	}
+
+	for d := 0; d < dirs; d++ {
+		pkgDir := filepath.Join(dir, fmt.Sprintf("pkg%d", d))
+		if err := os.MkdirAll(pkgDir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		for f := 0; f < files; f++ {
+			src := fmt.Sprintf("%s\npackage pkg%d\n\nconst A%d int = %d\n", header.Bytes(), d, f, f)
+			if err := ioutil.WriteFile(filepath.Join(pkgDir, fmt.Sprintf("file%d.go", f)), []byte(src), 0644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "--local", "user.name", "testdata"},
+		{"config", "--local", "user.email", "testdata@example.com"},
+		{"add", "."},
+		{"commit", "-m", "1st commit"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			tb.Fatalf("git %v: %v output: %s", args, err, out)
+		}
+	}
+	return dir
+}
+
+// walkVCS reads every file within dir at revision, returning the contents
+// of each file by relative path.
+func walkVCS(tb testing.TB, readDir func(rev, path string) ([]os.FileInfo, error), openFile func(rev, path string) ([]byte, error), rev, base, dir string) map[string][]byte {
+	files := make(map[string][]byte)
+	infos, err := readDir(rev, dir)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if info.IsDir() {
+			for rel, contents := range walkVCS(tb, readDir, openFile, rev, base, path) {
+				files[rel] = contents
+			}
+			continue
+		}
+		contents, err := openFile(rev, path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		rel, _ := filepath.Rel(base, path)
+		files[rel] = contents
+	}
+	return files
+}
+
+// gitOpenFile reads an entire file via the Git VCS.
+func gitOpenFile(g *Git) func(rev, path string) ([]byte, error) {
+	return func(rev, path string) ([]byte, error) {
+		rc, err := g.OpenFile(rev, path)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+}
+
+// execReadDir lists a directory by executing git ls-tree for each directory.
+func execReadDir(g *Git) func(rev, path string) ([]os.FileInfo, error) {
+	return func(rev, path string) ([]os.FileInfo, error) {
+		relPath, err := g.rel(path)
+		if err != nil {
+			return nil, err
+		}
+		args := []string{"--git-dir", g.dir, "ls-tree", rev}
+		if relPath != "" {
+			relPath += "/"
+			args = append(args, relPath)
+		}
+		ls, err := exec.Command("git", args...).Output()
+		if err != nil {
+			return nil, err
+		}
+		var files []os.FileInfo
+		for _, file := range bytes.Split(ls, []byte{'\n'}) {
+			fields := bytes.Fields(file)
+			if len(fields) != 4 {
+				continue
+			}
+			files = append(files, fileInfo{
+				name: strings.TrimPrefix(string(fields[3]), relPath),
+				dir:  bytes.Equal(fields[1], []byte("tree")),
+			})
+		}
+		return files, nil
+	}
+}
+
+// execOpenFile reads a file by executing git show for each file.
+func execOpenFile(g *Git) func(rev, path string) ([]byte, error) {
+	return func(rev, path string) ([]byte, error) {
+		relPath, err := g.rel(path)
+		if err != nil {
+			return nil, err
+		}
+		return exec.Command("git", "--git-dir", g.dir, "show", rev+":"+relPath).Output()
+	}
+}
+
+func TestGit(t *testing.T) {
+	dir := makeGitRepo(t, 3, 3)
+	defer os.RemoveAll(dir)
+
+	g, err := NewGit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := g.Close(); err != nil {
+			t.Errorf("unexpected error closing: %v", err)
+		}
+	}()
+
+	batch := walkVCS(t, g.ReadDir, gitOpenFile(g), "HEAD", g.base, g.base)
+	execd := walkVCS(t, execReadDir(g), execOpenFile(g), "HEAD", g.base, g.base)
+	if len(batch) != 9 {
+		t.Errorf("exp 9 files got %d", len(batch))
+	}
+	if len(batch) != len(execd) {
+		t.Errorf("exp %d files got %d", len(execd), len(batch))
+	}
+	for path, contents := range execd {
+		if !bytes.Equal(batch[path], contents) {
+			t.Errorf("%s: exp %q got %q", path, contents, batch[path])
+		}
+	}
+
+	if _, err := g.OpenFile("HEAD", filepath.Join(g.base, "missing.go")); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got: %v", err)
+	}
+}
+
+// TestGitDefaultRevision checks unstaged changes are found in the repository's
+// working tree, regardless of the process's working directory.
+func TestGitDefaultRevision(t *testing.T) {
+	repo, other := testenv.TempDir(t), testenv.TempDir(t)
+	for _, dir := range []string{repo, other} {
+		testenv.GitCommit(t, dir, map[string]string{"a.go": "package a\n"})
+		testenv.GitCommit(t, dir, map[string]string{"a.go": "package a\n\nconst A = 1\n"})
+	}
+	testenv.WriteFiles(t, other, map[string]string{"a.go": "package a\n\nconst A = 2\n"})
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(other); err != nil {
+		t.Fatal(err)
+	}
+
+	for dir, exp := range map[string][2]string{repo: {"HEAD~1", "HEAD"}, other: {"HEAD", "."}} {
+		g, err := NewGit(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if before, after := g.DefaultRevision(); before != exp[0] || after != exp[1] {
+			t.Errorf("%s: exp %s %s got %s %s", dir, exp[0], exp[1], before, after)
+		}
+		g.Close()
+	}
+}
+
+// BenchmarkGitBatch reads every file in a generated repository using a
+// single git ls-tree and git cat-file --batch process.
+func BenchmarkGitBatch(b *testing.B) {
+	dir := makeGitRepo(b, 20, 10)
+	defer os.RemoveAll(dir)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g, err := NewGit(dir)
+		if err != nil {
+			b.Fatal(err)
+		}
+		walkVCS(b, g.ReadDir, gitOpenFile(g), "HEAD", g.base, g.base)
+		if err := g.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGitExec reads every file in a generated repository executing git
+// ls-tree for each directory and git show for each file.
+func BenchmarkGitExec(b *testing.B) {
+	dir := makeGitRepo(b, 20, 10)
+	defer os.RemoveAll(dir)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g, err := NewGit(dir)
+		if err != nil {
+			b.Fatal(err)
+		}
+		walkVCS(b, execReadDir(g), execOpenFile(g), "HEAD", g.base, g.base)
+	}
+}
+
+// TestGitContext cancels reads, which must not affect later reads.
+func TestGitContext(t *testing.T) {
+	dir := makeGitRepo(t, 1, 1)
+	defer os.RemoveAll(dir)
+
+	g, err := NewGit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	path := filepath.Join(g.base, "pkg0", "file0.go")
+	if _, err := g.OpenFileContext(context.Background(), "HEAD", path); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.OpenFileContext(ctx, "HEAD", path); err != context.Canceled {
+		t.Errorf("exp %v got %v", context.Canceled, err)
+	}
+	if _, err := g.ReadDirContext(ctx, "HEAD~0", g.base); err != context.Canceled {
+		t.Errorf("exp %v got %v", context.Canceled, err)
+	}
+	if _, err := g.OpenFile("HEAD", path); err != nil {
+		t.Errorf("unexpected error after cancel: %v", err)
+	}
+
+	// Checks are abandoned
+	_, err = New(SetVCS(g)).CheckPackages(ctx, CheckRequest{Before: "HEAD", After: "HEAD"})
+	if err != context.Canceled {
+		t.Errorf("check: exp %v got %v", context.Canceled, err)
+	}
+}
+
+// cancelVCS cancels a check's context after its first file is read.
+type cancelVCS struct {
+	*Git
+	cancel context.CancelFunc
+	reads  int32
+}
+
+func (v *cancelVCS) OpenFileContext(ctx context.Context, revision, path string) (io.ReadCloser, error) {
+	rc, err := v.Git.OpenFileContext(ctx, revision, path)
+	if atomic.AddInt32(&v.reads, 1) == 1 {
+		v.cancel()
+	}
+	return rc, err
+}
+
+// TestGitCancelCheck cancels a check after it has started reading, while
+// other checks share the same Git, which must not be affected.
+func TestGitCancelCheck(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+	repo := filepath.Join(gopath, "src", "example.com", "cancel")
+	before, after := make(map[string]string), make(map[string]string)
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("p%d/a.go", i)
+		before[name] = fmt.Sprintf("package p%d\n\nfunc F(a int) {}\n", i)
+		after[name] = fmt.Sprintf("package p%d\n\nfunc F(a uint) {}\n", i)
+	}
+	testenv.GitCommit(t, repo, before)
+	testenv.GitCommit(t, repo, after)
+
+	g, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	req := CheckRequest{Patterns: []string{repo + "/..."}, Before: "HEAD~1", After: "HEAD"}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, err := New(SetVCS(&cancelVCS{Git: g, cancel: cancel})).CheckPackages(ctx, req)
+			if err != context.Canceled {
+				t.Errorf("cancelled check: exp %v got %v", context.Canceled, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			changes, err := New(SetVCS(g)).CheckPackages(context.Background(), req)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if len(changes) != 4 {
+				t.Errorf("exp 4 changes got %d: %v", len(changes), changes)
+			}
+		}()
+	}
+	wg.Wait()
+}
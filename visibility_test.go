@@ -0,0 +1,110 @@
+package apicompat
+
+import (
+	"context"
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bradleyfalzon/apicompat/internal/testenv"
+)
+
+// TestVisibility checks internal packages only for consumers permitted to
+// import them, and excludes whole path segments.
+func TestVisibility(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	dirs := []string{"internal/x", "myinternal/y", "vendor/z", "myvendor/v", "a/internal/w"}
+	repo := filepath.Join(gopath, "src", "example.com", "lib")
+	before, after := make(map[string]string), make(map[string]string)
+	for _, dir := range dirs {
+		name := filepath.Base(dir)
+		before[dir+"/p.go"] = "package " + name + "\n"
+		after[dir+"/p.go"] = "package " + name + "\n\nfunc F() {}\n"
+	}
+	testenv.GitCommit(t, repo, before)
+	testenv.GitCommit(t, repo, after)
+
+	vcs, err := NewGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vcs.Close()
+
+	tests := []struct {
+		options []func(*Checker)
+		exp     []string
+	}{
+		{nil, []string{"myinternal/y", "myvendor/v"}},
+		{
+			[]func(*Checker){SetInternalConsumers("example.com/lib/cmd")},
+			[]string{"internal/x", "myinternal/y", "myvendor/v"},
+		},
+		{
+			[]func(*Checker){SetInternalConsumers("example.com/lib/a/...")},
+			[]string{"a/internal/w", "internal/x", "myinternal/y", "myvendor/v"},
+		},
+		{
+			[]func(*Checker){SetInternalConsumers("example.com/other"), SetExcludeSegments("myvendor")},
+			[]string{"myinternal/y", "vendor/z"},
+		},
+	}
+	for _, test := range tests {
+		options := append([]func(*Checker){SetVCS(vcs)}, test.options...)
+		changes, err := New(options...).CheckPackages(context.Background(), CheckRequest{
+			Patterns: []string{repo + "/..."}, Before: "HEAD~1", After: "HEAD",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var got []string
+		for _, change := range changes {
+			got = append(got, strings.TrimPrefix(change.Pkg, "example.com/lib/"))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("exp packages %v got %v", test.exp, got)
+		}
+	}
+}
+
+// TestInternalVisibleModule finds the import path of a directory outside GOPATH
+// from its go.mod, and errors when there's none to match consumers against.
+func TestInternalVisibleModule(t *testing.T) {
+	gopath := testenv.GOPATH(t)
+
+	dir := testenv.TempDir(t)
+	testenv.WriteFiles(t, dir, map[string]string{
+		"mod/go.mod":            "module example.com/mod\n",
+		"mod/internal/x/p.go":   "package x\n",
+		"nomod/internal/x/p.go": "package x\n",
+	})
+
+	tests := []struct {
+		dir      string
+		consumer string
+		exp      bool
+		err      bool
+	}{
+		{"mod/internal/x", "example.com/mod/cmd", true, false},
+		{"mod/internal/x", "example.com/other", false, false},
+		{"nomod/internal/x", "example.com/mod/cmd", false, true},
+	}
+	for _, test := range tests {
+		bctx := build.Default
+		bctx.GOPATH = gopath
+		c := check{Checker: New(SetInternalConsumers(test.consumer)), build: bctx}
+
+		visible, err := c.internalVisible(filepath.Join(dir, test.dir))
+		if (err != nil) != test.err {
+			t.Errorf("%s %s: exp error %v got %v", test.dir, test.consumer, test.err, err)
+		}
+		if visible != test.exp {
+			t.Errorf("%s %s: exp visible %v got %v", test.dir, test.consumer, test.exp, visible)
+		}
+	}
+}